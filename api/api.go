@@ -16,7 +16,9 @@ package api
 
 // PodQoS describe Pod QoS settings
 type PodQoS struct {
-	CgroupPath      string `json:"CgroupPath"`
+	CgroupPath string `json:"CgroupPath"`
+	// QosLevel is negative for offline pods and non-negative for online pods, the higher the level is,
+	// the higher the priority is, the levels other than -1 and 0 need the kernel support
 	QosLevel        int    `json:"QosLevel"`
 	CacheLimitLevel string `json:"CacheLimitLevel"`
 }
@@ -40,3 +42,30 @@ type VersionResponse struct {
 	BuildTime string `json:"BuildTime"`
 	Usage     string `json:"Usage,omitempty"`
 }
+
+// StatusResponse is daemon status response for http responser
+type StatusResponse struct {
+	Version        string   `json:"Version"`
+	ConfigFile     string   `json:"ConfigFile"`
+	Services       []string `json:"Services"`
+	Pods           int      `json:"Pods"`
+	PendingEvents  int      `json:"PendingEvents"`
+	JournalEntries int      `json:"JournalEntries"`
+	CgroupMode     string   `json:"CgroupMode"`
+	// Unsupported lists the cgroup files without equivalent in the cgroup mode
+	Unsupported []string `json:"Unsupported"`
+	// Capabilities tells whether each kernel interface probed at startup is supported
+	Capabilities map[string]bool `json:"Capabilities,omitempty"`
+	// PodSource is the source the pods are watched with, empty if none is available
+	PodSource string `json:"PodSource,omitempty"`
+}
+
+// DryRunRecord is an intended write recorded in dry-run mode
+type DryRunRecord struct {
+	Time   string `json:"Time"`
+	Module string `json:"Module"`
+	File   string `json:"File"`
+	Old    string `json:"Old"`
+	New    string `json:"New"`
+	Reason string `json:"Reason"`
+}
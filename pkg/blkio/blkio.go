@@ -20,27 +20,39 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strings"
 	"syscall"
 
-	corev1 "k8s.io/api/core/v1"
+	"github.com/pkg/errors"
 
-	"isula.org/rubik/pkg/config"
-	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/events"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/metrics"
+	"isula.org/rubik/pkg/services"
 	log "isula.org/rubik/pkg/tinylog"
-	"isula.org/rubik/pkg/util"
+	"isula.org/rubik/pkg/typedef"
 )
 
 const (
+	moduleName          = "blkio"
+	blkioPath           = "blkio"
 	deviceReadBpsFile   = "blkio.throttle.read_bps_device"
 	deviceWriteBpsFile  = "blkio.throttle.write_bps_device"
 	deviceReadIopsFile  = "blkio.throttle.read_iops_device"
 	deviceWriteIopsFile = "blkio.throttle.write_iops_device"
 )
 
+// ioMaxKeys maps the blkio throttle files of cgroup v1 to the keys of io.max of cgroup v2
+var ioMaxKeys = map[string]string{
+	deviceReadBpsFile:   "rbps",
+	deviceWriteBpsFile:  "wbps",
+	deviceReadIopsFile:  "riops",
+	deviceWriteIopsFile: "wiops",
+}
+
 // DeviceConfig defines blkio device configurations
 type DeviceConfig struct {
 	DeviceName  string `json:"device,omitempty"`
@@ -55,56 +67,73 @@ type BlkConfig struct {
 	DeviceWriteIops []DeviceConfig `json:"device_write_iops,omitempty"`
 }
 
-// SetBlkio set blkio limtis according to annotation
-func SetBlkio(pod *corev1.Pod) {
-	cfg := decodeBlkioCfg(pod.Annotations[constant.BlkioKey])
+// SetBlkio set blkio limtis according to annotation, the error of writing the limits is returned to be retried,
+// while the invalid annotation is reported only
+func SetBlkio(pi *typedef.PodInfo) error {
+	cfg, err := decodeBlkioCfg(pi.BlkioLimit)
+	if err != nil {
+		reportInvalidCfg(pi, err)
+	}
 	if cfg == nil {
-		return
+		return nil
 	}
-	blkioLimit(pod, cfg, false)
+	return blkioLimit(pi, cfg, false)
 }
 
-// WriteBlkio updates blkio limtis according to annotation
-func WriteBlkio(old *corev1.Pod, new *corev1.Pod) {
-	if new.Status.Phase != corev1.PodRunning {
-		return
-	}
-
-	if old.Annotations[constant.BlkioKey] == new.Annotations[constant.BlkioKey] {
-		return
+// WriteBlkio updates blkio limtis according to annotation, the error of writing the limits is returned to be retried
+func WriteBlkio(old *typedef.PodInfo, new *typedef.PodInfo) error {
+	if old.BlkioLimit == new.BlkioLimit {
+		return nil
 	}
 
-	// empty old blkio limits
-	if oldCfg := decodeBlkioCfg(old.Annotations[constant.BlkioKey]); oldCfg != nil {
-		blkioLimit(old, oldCfg, true)
+	// empty old blkio limits, the old annotation has been reported if it is invalid
+	if oldCfg, _ := decodeBlkioCfg(old.BlkioLimit); oldCfg != nil {
+		if err := blkioLimit(old, oldCfg, true); err != nil {
+			return err
+		}
 	}
 
 	// set new blkio limits
-	if newCfg := decodeBlkioCfg(new.Annotations[constant.BlkioKey]); newCfg != nil {
-		blkioLimit(new, newCfg, false)
+	newCfg, err := decodeBlkioCfg(new.BlkioLimit)
+	if err != nil {
+		reportInvalidCfg(new, err)
 	}
+	if newCfg == nil {
+		return nil
+	}
+	return blkioLimit(new, newCfg, false)
 }
 
-func blkioLimit(pod *corev1.Pod, cfg *BlkConfig, empty bool) {
-	if len(cfg.DeviceReadBps) > 0 {
-		tryWriteBlkioLimit(pod, cfg.DeviceReadBps, deviceReadBpsFile, empty)
-	}
-	if len(cfg.DeviceWriteBps) > 0 {
-		tryWriteBlkioLimit(pod, cfg.DeviceWriteBps, deviceWriteBpsFile, empty)
-	}
-	if len(cfg.DeviceReadIops) > 0 {
-		tryWriteBlkioLimit(pod, cfg.DeviceReadIops, deviceReadIopsFile, empty)
-	}
-	if len(cfg.DeviceWriteIops) > 0 {
-		tryWriteBlkioLimit(pod, cfg.DeviceWriteIops, deviceWriteIopsFile, empty)
+func reportInvalidCfg(pi *typedef.PodInfo, err error) {
+	log.Errorf("invalid blkio limit of pod %s(%s): %v", pi.Name, pi.UID, err)
+	events.Warningf(events.PodRef(pi), events.ReasonInvalidBlkioAnnotation, "Invalid blkio limit annotation: %v", err)
+}
+
+// blkioLimit writes all limits of the config, the last error of writing is returned after all are tried
+func blkioLimit(pi *typedef.PodInfo, cfg *BlkConfig, empty bool) error {
+	var lastErr error
+	for _, limits := range []struct {
+		devCfgs []DeviceConfig
+		file    string
+	}{
+		{cfg.DeviceReadBps, deviceReadBpsFile},
+		{cfg.DeviceWriteBps, deviceWriteBpsFile},
+		{cfg.DeviceReadIops, deviceReadIopsFile},
+		{cfg.DeviceWriteIops, deviceWriteIopsFile},
+	} {
+		if err := tryWriteBlkioLimit(pi, limits.devCfgs, limits.file, empty); err != nil {
+			lastErr = err
+		}
 	}
+	return lastErr
 }
 
-func decodeBlkioCfg(blkioCfg string) *BlkConfig {
+// decodeBlkioCfg decodes the blkio limit annotation, nil without error is returned if the annotation is empty
+func decodeBlkioCfg(blkioCfg string) (*BlkConfig, error) {
 	if len(blkioCfg) == 0 {
-		return nil
+		return nil, nil
 	}
-	log.Infof("blkioCfg is %v", blkioCfg)
+	log.Debugf("blkioCfg is %v", blkioCfg)
 	cfg := &BlkConfig{
 		DeviceReadBps:   []DeviceConfig{},
 		DeviceWriteBps:  []DeviceConfig{},
@@ -113,60 +142,208 @@ func decodeBlkioCfg(blkioCfg string) *BlkConfig {
 	}
 	reader := bytes.NewReader([]byte(blkioCfg))
 	if err := json.NewDecoder(reader).Decode(cfg); err != nil {
-		log.Errorf("decode blkioCfg failed with error: %v", err)
-		return nil
+		return nil, errors.Errorf("decode blkioCfg failed with error: %v", err)
 	}
-	return cfg
+	return cfg, nil
 }
 
-func tryWriteBlkioLimit(pod *corev1.Pod, devCfgs []DeviceConfig, deviceFilePath string, empty bool) {
+// tryWriteBlkioLimit writes the limits of the devices, the device invalid is skipped as retry does not help
+func tryWriteBlkioLimit(pi *typedef.PodInfo, devCfgs []DeviceConfig, deviceFilePath string, empty bool) error {
+	var lastErr error
 	for _, devCfg := range devCfgs {
-		devName, devLimit := devCfg.DeviceName, devCfg.DeviceValue
+		limit, err := deviceLimit(devCfg, empty)
+		if err != nil {
+			log.Errorf("%v", err)
+			continue
+		}
+		if err := writeBlkioLimit(pi, limit, deviceFilePath); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// deviceLimit returns the throttle limit of the device like "8:0 1048576", the value is 0 if empty
+func deviceLimit(devCfg DeviceConfig, empty bool) (string, error) {
+	devName, devLimit := devCfg.DeviceName, devCfg.DeviceValue
+
+	fi, err := os.Stat(devName)
+	if err != nil {
+		return "", errors.Errorf("stat %s failed with error %v", devName, err)
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return "", errors.Errorf("%s is not a device", devName)
+	}
 
-		fi, err := os.Stat(devName)
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", errors.Errorf("failed to get Sys(), %v has type %v", devName, fi.Sys())
+	}
+	devno := st.Rdev
+	major, minor := devno/256, devno%256
+	if empty {
+		devLimit = "0"
+	}
+	return fmt.Sprintf("%v:%v %s", major, minor, devLimit), nil
+}
+
+// writeBlkioLimit writes the limit to all containers of the pod, the last error is returned
+func writeBlkioLimit(pi *typedef.PodInfo, limit, deviceFilePath string) error {
+	if cgroup.IsV2() {
+		limit = ioMaxLimit(deviceFilePath, limit)
+	}
+	deviceFilePath, _ = cgroup.FileName(deviceFilePath)
+	var lastErr error
+	for _, c := range pi.Containers {
+		// the container may be in the creation or deletion phase.
+		if c.ID == "" {
+			continue
+		}
+		containerBlkFilePath, err := cgroup.Join(cgroup.ContainerPath(blkioPath, c), deviceFilePath)
 		if err != nil {
-			log.Errorf("stat %s failed with error %v", devName, err)
+			log.Errorf("writeBlkioLimit failed: %v", err)
 			continue
 		}
-		if fi.Mode()&os.ModeDevice == 0 {
-			log.Errorf("%s is not a device", devName)
+		if err := writeContainerLimit(pi, containerBlkFilePath, limit); err != nil {
+			log.Errorf("writeBlkioLimit %v", err)
+			lastErr = err
 			continue
 		}
+		log.Infof("writeBlkioLimit write %s to %v success", limit, containerBlkFilePath)
+	}
+	return lastErr
+}
 
-		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
-			devno := st.Rdev
-			major, minor := devno/256, devno%256
-			var limit string
-			if empty == true {
-				limit = fmt.Sprintf("%v:%v 0", major, minor)
-			} else {
-				limit = fmt.Sprintf("%v:%v %s", major, minor, devLimit)
+func writeContainerLimit(pi *typedef.PodInfo, path, limit string) error {
+	if !dryrun.Enabled() {
+		recordBlkioLimit(path, limit)
+	}
+	if err := dryrun.WriteFile(moduleName, path, limit, "set blkio throttle of pod "+pi.UID); err != nil {
+		metrics.WriteFailures.Inc(moduleName, pi.Namespace, pi.Name)
+		return errors.Errorf("write %v to %v failed with error: %v", limit, path, err)
+	}
+	return nil
+}
+
+// reconcileBlkio rewrites the throttle limits of the containers of the pod drifted from its annotation
+func reconcileBlkio(pi *typedef.PodInfo) []services.Correction {
+	cfg, err := decodeBlkioCfg(pi.BlkioLimit)
+	if err != nil || cfg == nil {
+		return nil
+	}
+	var corrections []services.Correction
+	for file, devCfgs := range map[string][]DeviceConfig{
+		deviceReadBpsFile:   cfg.DeviceReadBps,
+		deviceWriteBpsFile:  cfg.DeviceWriteBps,
+		deviceReadIopsFile:  cfg.DeviceReadIops,
+		deviceWriteIopsFile: cfg.DeviceWriteIops,
+	} {
+		for _, devCfg := range devCfgs {
+			limit, err := deviceLimit(devCfg, false)
+			if err != nil {
+				continue
 			}
-			writeBlkioLimit(pod, limit, deviceFilePath)
-		} else {
-			log.Errorf("failed to get Sys(), %v has type %v", devName, st)
+			corrections = append(corrections, reconcileLimit(pi, limit, file)...)
 		}
 	}
+	return corrections
 }
 
-func writeBlkioLimit(pod *corev1.Pod, limit, deviceFilePath string) {
-	const (
-		dockerPrefix = "docker://"
-		containerdPrefix = "containerd://"
-		blkioPath = "blkio"
-	)
-	podCgroupPath := util.GetPodCgroupPath(pod)
-	for _, container := range pod.Status.ContainerStatuses {
-		containerID := strings.TrimPrefix(container.ContainerID, dockerPrefix)
-		containerID = strings.TrimPrefix(containerID, containerdPrefix)
-		containerPath := filepath.Join(podCgroupPath, containerID)
-		containerBlkFilePath := filepath.Join(config.CgroupRoot, blkioPath, containerPath, deviceFilePath)
-
-		err := ioutil.WriteFile(containerBlkFilePath, []byte(limit), constant.DefaultFileMode)
+func reconcileLimit(pi *typedef.PodInfo, limit, deviceFilePath string) []services.Correction {
+	if cgroup.IsV2() {
+		limit = ioMaxLimit(deviceFilePath, limit)
+	}
+	deviceFilePath, _ = cgroup.FileName(deviceFilePath)
+	var corrections []services.Correction
+	for _, c := range pi.Containers {
+		if c.ID == "" {
+			continue
+		}
+		path, err := cgroup.Join(cgroup.ContainerPath(blkioPath, c), deviceFilePath)
 		if err != nil {
-			log.Errorf("writeBlkioLimit write %v to %v failed with error: %v", limit, containerBlkFilePath, err)
 			continue
 		}
-		log.Infof("writeBlkioLimit write %s to %v success", limit, containerBlkFilePath)
+		actual, err := readBlkioLimit(path, limit)
+		if err != nil || actual == limit {
+			continue
+		}
+		if err := writeContainerLimit(pi, path, limit); err != nil {
+			log.Errorf("reconcile blkio limit failed: %v", err)
+			continue
+		}
+		corrections = append(corrections, services.Correction{Pod: pi, File: path, Actual: actual, Desired: limit})
+	}
+	return corrections
+}
+
+// ioMaxLimit converts the throttle limit like "8:0 1048576" to the io.max limit like "8:0 rbps=1048576",
+// where 0 meaning no limit is converted to max
+func ioMaxLimit(file, limit string) string {
+	fields := strings.Fields(limit)
+	if len(fields) != 2 {
+		return limit
+	}
+	value := fields[1]
+	if value == "0" {
+		value = "max"
+	}
+	return fmt.Sprintf("%s %s=%s", fields[0], ioMaxKeys[file], value)
+}
+
+// recordBlkioLimit records the origin limit of the device in the throttle file before it is overwritten,
+// io.max of cgroup v2 lists all limits of a device in one line, so that the limit of each key is recorded separately
+func recordBlkioLimit(path, limit string) {
+	fields := strings.Fields(limit)
+	if len(fields) == 0 {
+		return
+	}
+	key := path + " " + fields[0]
+	if len(fields) == 2 && strings.Contains(fields[1], "=") {
+		key += " " + strings.SplitN(fields[1], "=", 2)[0]
+	}
+	if journal.Recorded(moduleName, key) {
+		return
+	}
+	origin, err := readBlkioLimit(path, limit)
+	if err != nil {
+		return
+	}
+	journal.Record(moduleName, key, path, origin)
+}
+
+// readBlkioLimit reads the current limit of the device and the io.max key in limit from the throttle file
+// in the same format as limit. The throttle file lists limits per device like "8:0 1048576", and a device
+// not listed means no limit. io.max of cgroup v2 lists all limits of a device in one line like
+// "8:0 rbps=max wbps=max riops=max wiops=max".
+func readBlkioLimit(path, limit string) (string, error) {
+	fields := strings.Fields(limit)
+	if len(fields) == 0 {
+		return "", errors.Errorf("invalid blkio limit %q", limit)
+	}
+	device, ioKey := fields[0], ""
+	current := device + " 0"
+	if len(fields) == 2 && strings.Contains(fields[1], "=") {
+		ioKey = strings.SplitN(fields[1], "=", 2)[0]
+		current = device + " " + ioKey + "=max"
+	}
+
+	content, err := cgroup.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, device+" ") {
+			continue
+		}
+		if ioKey == "" {
+			return strings.TrimSpace(line), nil
+		}
+		for _, f := range strings.Fields(line)[1:] {
+			if strings.HasPrefix(f, ioKey+"=") {
+				current = device + " " + f
+			}
+		}
+		break
 	}
+	return current, nil
 }
@@ -23,11 +23,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
-	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/journal"
 	"isula.org/rubik/pkg/typedef"
 	"isula.org/rubik/pkg/util"
 )
@@ -47,16 +46,27 @@ var (
 		"device_read_iops":  deviceReadIopsFile,
 		"device_write_iops": deviceWriteIopsFile,
 	}
-	status = corev1.PodStatus{
-		ContainerStatuses: []corev1.ContainerStatus{
-			{ContainerID: "docker://aaa"},
-		},
-		QOSClass: corev1.PodQOSBurstable,
-		Phase:    corev1.PodRunning,
-	}
 	containerDir = filepath.Join(constant.TmpTestDir, "blkio/kubepods/burstable/podaaa/aaa")
 )
 
+func newPodInfo(blkioLimit string) *typedef.PodInfo {
+	return &typedef.PodInfo{
+		UID:        "aaa",
+		CgroupRoot: constant.TmpTestDir,
+		CgroupPath: "kubepods/burstable/podaaa",
+		BlkioLimit: blkioLimit,
+		Containers: map[string]*typedef.ContainerInfo{
+			"foo": {
+				Name:       "foo",
+				ID:         "aaa",
+				PodID:      "aaa",
+				CgroupRoot: constant.TmpTestDir,
+				CgroupAddr: "kubepods/burstable/podaaa/aaa",
+			},
+		},
+	}
+}
+
 func getMajor(devName string) (major int, err error) {
 	cmd := fmt.Sprintf("ls -l %v | awk -F ' ' '{print $5}'", devName)
 	out, err := exec.Command("/bin/bash", "-c", cmd).Output()
@@ -79,7 +89,8 @@ func getMinor(devName string) (minor int, err error) {
 
 func TestBlkioAnnotation1(t *testing.T) {
 	// valid blkiocfg format
-	cfg := decodeBlkioCfg(blkioAnnotation)
+	cfg, err := decodeBlkioCfg(blkioAnnotation)
+	assert.NoError(t, err)
 	assert.True(t, len(cfg.DeviceReadBps) > 0)
 	assert.True(t, len(cfg.DeviceWriteBps) > 0)
 	assert.True(t, len(cfg.DeviceReadIops) > 0)
@@ -100,7 +111,13 @@ func TestBlkioAnnotation1(t *testing.T) {
 	assert.Equal(t, "300", cfg.DeviceWriteIops[0].DeviceValue)
 
 	// invalid blkiocfg format
-	cfg = decodeBlkioCfg(invBlkioAnnotation)
+	cfg, err = decodeBlkioCfg(invBlkioAnnotation)
+	assert.Error(t, err)
+	assert.Equal(t, (*BlkConfig)(nil), cfg)
+
+	// empty annotation means no limit
+	cfg, err = decodeBlkioCfg("")
+	assert.NoError(t, err)
 	assert.Equal(t, (*BlkConfig)(nil), cfg)
 }
 
@@ -108,7 +125,8 @@ func TestBlkioAnnotation2(t *testing.T) {
 	// valid blkiocfg format, valid + invalid device name
 	s1 := `{"device_read_bps":[{"device":"/dev/sda1","value":"10485760"}, {"device":"/dev/sda","value":"10485760"}],
 			"device_read_iops":[{"device":"/dev/sda1","value":"200"}, {"device":"/dev/123","value":"123"}]}`
-	cfg := decodeBlkioCfg(s1)
+	cfg, err := decodeBlkioCfg(s1)
+	assert.NoError(t, err)
 	assert.True(t, len(cfg.DeviceReadBps) == 2)
 	assert.True(t, len(cfg.DeviceWriteBps) == 0)
 	assert.True(t, len(cfg.DeviceReadIops) == 2)
@@ -164,18 +182,7 @@ func TestSetBlkio(t *testing.T) {
 		mkdirHelper(t)
 		defer os.RemoveAll(constant.TmpTestDir)
 
-		config.CgroupRoot = constant.TmpTestDir
-		pod := &corev1.Pod{
-			ObjectMeta: v1.ObjectMeta{
-				UID: "aaa",
-				Annotations: map[string]string{
-					constant.BlkioKey: s1,
-				},
-			},
-			Status: status,
-		}
-
-		SetBlkio(pod)
+		SetBlkio(newPodInfo(s1))
 
 		expected := ""
 		for _, device := range devices {
@@ -225,29 +232,12 @@ func TestWriteBlkio(t *testing.T) {
 	mkdirHelper(t)
 	defer os.RemoveAll(constant.TmpTestDir)
 
-	config.CgroupRoot = constant.TmpTestDir
-	old := corev1.Pod{
-		ObjectMeta: v1.ObjectMeta{
-			UID: "aaa",
-			Annotations: map[string]string{
-				constant.BlkioKey: "",
-			},
-		},
-		Status: status,
-	}
-	SetBlkio(&old)
+	old := newPodInfo("")
+	SetBlkio(old)
 
 	testFunc := func(newCfg, deviceType, devicePath string, devices []string) {
-		new := corev1.Pod{
-			ObjectMeta: v1.ObjectMeta{
-				UID: "aaa",
-				Annotations: map[string]string{
-					constant.BlkioKey: newCfg,
-				},
-			},
-			Status: status,
-		}
-		WriteBlkio(&old, &new)
+		new := newPodInfo(newCfg)
+		WriteBlkio(old, new)
 
 		old = new
 		expected := ""
@@ -274,3 +264,76 @@ func TestWriteBlkio(t *testing.T) {
 		}
 	}
 }
+
+// TestIoMax tests the blkio limits written to io.max of cgroup v2 and restored
+func TestIoMax(t *testing.T) {
+	assert.Equal(t, "8:0 rbps=1048576", ioMaxLimit(deviceReadBpsFile, "8:0 1048576"))
+	assert.Equal(t, "8:0 wiops=max", ioMaxLimit(deviceWriteIopsFile, "8:0 0"))
+
+	oldMode := cgroup.SetMode(cgroup.ModeV2)
+	defer cgroup.SetMode(oldMode)
+	fake := cgroup.NewFake()
+	oldBackend := cgroup.SetBackend(fake)
+	defer cgroup.SetBackend(oldBackend)
+	assert.NoError(t, journal.Init(""))
+
+	pi := &typedef.PodInfo{UID: "podabc", Containers: map[string]*typedef.ContainerInfo{
+		"c1": {Name: "c1", ID: "id1", CgroupRoot: "/cgroup", CgroupAddr: "kubepods/podabc/id1"},
+	}}
+	ioMax := "/cgroup/kubepods/podabc/id1/io.max"
+	fake.Set(ioMax, "8:0 rbps=2097152 wbps=max riops=max wiops=max\n")
+
+	assert.NoError(t, writeBlkioLimit(pi, "8:0 1048576", deviceReadBpsFile))
+	assert.NoError(t, writeBlkioLimit(pi, "8:16 100", deviceWriteIopsFile))
+	// the fake backend keeps the last write only while the kernel merges the keys of io.max
+	v, _ := fake.Get(ioMax)
+	assert.Equal(t, "8:16 wiops=100", v)
+
+	assert.NoError(t, journal.Restore(moduleName))
+	v, _ = fake.Get(ioMax)
+	assert.Equal(t, "8:0 rbps=2097152", v)
+	assert.Equal(t, 0, journal.Len())
+
+	// the write failed is returned to be retried
+	pi.Containers["c2"] = &typedef.ContainerInfo{Name: "c2", ID: "id2", CgroupRoot: "/cgroup",
+		CgroupAddr: "kubepods/podabc/id2"}
+	assert.Error(t, writeBlkioLimit(pi, "8:0 1048576", deviceReadBpsFile))
+}
+
+// TestReconcileBlkio tests the drifted limits are written again
+func TestReconcileBlkio(t *testing.T) {
+	oldMode := cgroup.SetMode(cgroup.ModeV1)
+	defer cgroup.SetMode(oldMode)
+	fake := cgroup.NewFake()
+	oldBackend := cgroup.SetBackend(fake)
+	defer cgroup.SetBackend(oldBackend)
+	assert.NoError(t, journal.Init(""))
+	defer journal.Restore(moduleName)
+
+	// /dev/null is the character device 1:3
+	pi := &typedef.PodInfo{UID: "podabc", BlkioLimit: `{"device_read_bps":[{"device":"/dev/null","value":"1048576"}]}`,
+		Containers: map[string]*typedef.ContainerInfo{
+			"c1": {Name: "c1", ID: "id1", CgroupRoot: "/cgroup", CgroupAddr: "kubepods/podabc/id1"},
+		}}
+	readBps := "/cgroup/blkio/kubepods/podabc/id1/" + deviceReadBpsFile
+	fake.Set(readBps, "8:0 100\n")
+
+	corrections := reconcileBlkio(pi)
+	assert.Len(t, corrections, 1)
+	assert.Equal(t, readBps, corrections[0].File)
+	assert.Equal(t, "1:3 0", corrections[0].Actual)
+	assert.Equal(t, "1:3 1048576", corrections[0].Desired)
+
+	fake.Set(readBps, "8:0 100\n1:3 1048576\n")
+	assert.Empty(t, reconcileBlkio(pi))
+
+	cur, err := readBlkioLimit(readBps, "1:3 2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1:3 1048576", cur)
+	cur, err = readBlkioLimit(readBps, "8:0 rbps=2")
+	assert.NoError(t, err)
+	assert.Equal(t, "8:0 rbps=max", cur)
+
+	pi.BlkioLimit = "invalid"
+	assert.Empty(t, reconcileBlkio(pi))
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-10-17
+// Description: blkio service registration
+
+package blkio
+
+import (
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+type blkioService struct{}
+
+func init() {
+	services.Register(&blkioService{})
+}
+
+// Name returns the service name
+func (s *blkioService) Name() string {
+	return moduleName
+}
+
+// Enabled tells whether blkio is enabled in config
+func (s *blkioService) Enabled(cfg *config.Config) bool {
+	return cfg.BlkioCfg.Enable
+}
+
+// Init initializes the service
+func (s *blkioService) Init(cfg *config.Config, cpm *checkpoint.Manager) error {
+	return nil
+}
+
+// PodAdded sets blkio limits of the new pod
+func (s *blkioService) PodAdded(pod *typedef.PodInfo) error {
+	return SetBlkio(pod)
+}
+
+// PodUpdated updates blkio limits of the pod if the annotation changes
+func (s *blkioService) PodUpdated(old, new *typedef.PodInfo) error {
+	return WriteBlkio(old, new)
+}
+
+// PodDeleted does nothing as the pod cgroups are removed along with the pod
+func (s *blkioService) PodDeleted(pod *typedef.PodInfo) error {
+	return nil
+}
+
+// Sync sets blkio limits of all pods
+func (s *blkioService) Sync(pods map[string]*typedef.PodInfo) error {
+	for _, pod := range pods {
+		if err := SetBlkio(pod); err != nil {
+			log.Errorf("sync set pod %v blkio limits error: %v", pod.UID, err)
+		}
+	}
+	return nil
+}
+
+// Reconcile sets the blkio limits of the containers drifted again
+func (s *blkioService) Reconcile(pods map[string]*typedef.PodInfo) ([]services.Correction, error) {
+	var corrections []services.Correction
+	for _, pod := range pods {
+		corrections = append(corrections, reconcileBlkio(pod)...)
+	}
+	return corrections, nil
+}
+
+// Shutdown stops the service
+func (s *blkioService) Shutdown() error {
+	return nil
+}
@@ -15,17 +15,16 @@
 package cachelimit
 
 import (
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 
-	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/pkg/errors"
 	"k8s.io/apimachinery/pkg/types"
 
-	"isula.org/rubik/pkg/config"
-	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/services"
 	log "isula.org/rubik/pkg/tinylog"
 	"isula.org/rubik/pkg/typedef"
 	"isula.org/rubik/pkg/util"
@@ -75,7 +74,7 @@ func SetCacheLimit(pi *typedef.PodInfo) error {
 }
 
 func writeTasksToResctrl(pi *typedef.PodInfo, resctrlRoot string) error {
-	taskRootPath := filepath.Join(config.CgroupRoot, "cpu", pi.CgroupPath)
+	taskRootPath := cgroup.PodPath("cpu", pi)
 	if !util.PathExist(taskRootPath) {
 		log.Infof("path %v not exist, maybe pod %v is deleted", taskRootPath, pi.UID)
 		return nil
@@ -91,7 +90,7 @@ func writeTasksToResctrl(pi *typedef.PodInfo, resctrlRoot string) error {
 
 	resctrlTaskFile := filepath.Join(resctrlRoot, dirPrefix+pi.CacheLimitLevel, "tasks")
 	for _, task := range tasks {
-		if err := ioutil.WriteFile(resctrlTaskFile, []byte(task), constant.DefaultFileMode); err != nil {
+		if err := dryrun.WriteFile(moduleName, resctrlTaskFile, task, "move task of pod "+pi.UID); err != nil {
 			if strings.Contains(err.Error(), noProErr) {
 				log.Errorf("pod %s task %s not exist", pi.UID, task)
 				continue
@@ -103,6 +102,47 @@ func writeTasksToResctrl(pi *typedef.PodInfo, resctrlRoot string) error {
 	return nil
 }
 
+// reconcileTasks moves the tasks of the offline pod missing from the resctrl group of its level
+func reconcileTasks(pi *typedef.PodInfo, resctrlRoot string) ([]services.Correction, error) {
+	if err := SyncLevel(pi); err != nil {
+		return nil, err
+	}
+	taskRootPath := cgroup.PodPath("cpu", pi)
+	if !util.PathExist(taskRootPath) {
+		return nil, nil
+	}
+	tasks, _, err := getTasks(pi, taskRootPath)
+	if err != nil || len(tasks) == 0 {
+		return nil, err
+	}
+
+	resctrlTaskDir := filepath.Join(resctrlRoot, dirPrefix+pi.CacheLimitLevel)
+	grouped, err := cgroup.ReadLines(resctrlTaskDir, "tasks")
+	if err != nil {
+		return nil, errors.Errorf("read tasks of %v error: %v", resctrlTaskDir, err)
+	}
+	inGroup := make(map[string]bool, len(grouped))
+	for _, task := range grouped {
+		inGroup[task] = true
+	}
+
+	var corrections []services.Correction
+	resctrlTaskFile := filepath.Join(resctrlTaskDir, "tasks")
+	for _, task := range tasks {
+		if inGroup[task] {
+			continue
+		}
+		if err := dryrun.WriteFile(moduleName, resctrlTaskFile, task, "reconcile task of pod "+pi.UID); err != nil {
+			if strings.Contains(err.Error(), noProErr) {
+				continue
+			}
+			return corrections, errors.Errorf("add task %v to file %v error: %v", task, resctrlTaskFile, err)
+		}
+		corrections = append(corrections, services.Correction{Pod: pi, File: resctrlTaskFile, Desired: task})
+	}
+	return corrections, nil
+}
+
 func getTasks(pi *typedef.PodInfo, taskRootPath string) ([]string, []string, error) {
 	file := "cgroup.procs"
 	var taskList, containers []string
@@ -112,21 +152,17 @@ func getTasks(pi *typedef.PodInfo, taskRootPath string) ([]string, []string, err
 			if cpm.ContainerExist(types.UID(pi.UID), containerID) {
 				return nil
 			}
-			cgFilePath, err := securejoin.SecureJoin(path, file)
-			if err != nil {
-				return errors.Errorf("join path failed for %s and %s: %v", path, file, err)
-			}
-			tasks, err := ioutil.ReadFile(filepath.Clean(cgFilePath))
+			tasks, err := cgroup.ReadLines(path, file)
 			if err != nil {
-				return errors.Errorf("read task file %v err: %v", cgFilePath, err)
+				return errors.Errorf("read task file under %v err: %v", path, err)
 			}
-			if strings.TrimSpace(string(tasks)) == "" {
+			if len(tasks) == 0 {
 				return nil
 			}
 			if containerID != filepath.Base(taskRootPath) {
 				containers = append(containers, containerID)
 			}
-			taskList = append(taskList, strings.Split(strings.TrimSpace(string(tasks)), "\n")...)
+			taskList = append(taskList, tasks...)
 		}
 		return nil
 	})
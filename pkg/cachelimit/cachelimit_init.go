@@ -15,19 +15,24 @@ package cachelimit
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 	"k8s.io/apimachinery/pkg/util/wait"
 
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/checkpoint"
 	"isula.org/rubik/pkg/config"
-	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/metrics"
 	"isula.org/rubik/pkg/perf"
 	log "isula.org/rubik/pkg/tinylog"
 	"isula.org/rubik/pkg/typedef"
@@ -35,6 +40,7 @@ import (
 )
 
 const (
+	moduleName   = "cachelimit"
 	schemataFile = "schemata"
 	numaNodeDir  = "/sys/devices/system/node"
 	cpuDir       = "/sys/devices/system/cpu"
@@ -68,8 +74,34 @@ const (
 var (
 	numaNum, l3PercentDynamic, mbPercentDynamic int
 	defaultLimitMode                            string
-	enable                                      bool
 	cpm                                         *checkpoint.Manager
+	// enable is 1 when cache limit is running, read by the http server without runLock
+	enable int32
+	// runLock serializes Init, Reload and Stop
+	runLock sync.Mutex
+	// stopCh stops the cache limit goroutines started by Init
+	stopCh chan struct{}
+	// running waits for the cache limit goroutines to exit
+	running sync.WaitGroup
+)
+
+var (
+	podIPC = metrics.NewGauge("rubik_cachelimit_pod_ipc",
+		"Instructions per cycle of the online pod sampled by dynamic cache limit.",
+		metrics.LabelNamespace, metrics.LabelPod)
+	podCPUUsage = metrics.NewGauge("rubik_cachelimit_pod_cpu_usage_percent",
+		"CPU usage of the online pod sampled by dynamic cache limit.",
+		metrics.LabelNamespace, metrics.LabelPod)
+	podCacheMiss = metrics.NewGauge("rubik_cachelimit_pod_cache_miss_percent",
+		"Cache miss rate of the online pod sampled by dynamic cache limit.",
+		metrics.LabelNamespace, metrics.LabelPod)
+	podLLCMiss = metrics.NewGauge("rubik_cachelimit_pod_llc_miss_percent",
+		"LLC miss rate of the online pod sampled by dynamic cache limit.",
+		metrics.LabelNamespace, metrics.LabelPod)
+	dynamicL3Percent = metrics.NewGauge("rubik_cachelimit_dynamic_l3_percent",
+		"L3 cache percentage currently allowed to offline pods of dynamic level.")
+	dynamicMbPercent = metrics.NewGauge("rubik_cachelimit_dynamic_mb_percent",
+		"Memory bandwidth percentage currently allowed to offline pods of dynamic level.")
 )
 
 type cacheLimitSet struct {
@@ -81,32 +113,81 @@ type cacheLimitSet struct {
 
 // Init init and starts cache limit
 func Init(m *checkpoint.Manager, cfg *config.CacheConfig) error {
-	enable = true
+	runLock.Lock()
+	defer runLock.Unlock()
+	return start(m, cfg)
+}
+
+// start checks the config and starts the cache limit goroutines, the caller must hold runLock
+func start(m *checkpoint.Manager, cfg *config.CacheConfig) error {
 	if !isHostPidns("/proc/self/ns/pid") {
 		return errors.New("share pid namespace with host is needed for cache limit")
 	}
-	if !perf.HwSupport() {
+	if !capability.Supported(capability.PerfHardware) {
 		return errors.New("hardware event perf not supported")
 	}
+	if !capability.Supported(capability.ResctrlL3) && !capability.Supported(capability.ResctrlMB) {
+		return errors.New("neither L3 cache nor memory bandwidth allocation of resctrl is supported")
+	}
 	if err := checkCacheCfg(cfg); err != nil {
 		return err
 	}
 	if err := checkResctrlExist(cfg); err != nil {
 		return err
 	}
+	cpm = m
 	if err := initCacheLimitDir(cfg); err != nil {
 		return errors.Errorf("cache limit directory create failed: %v", err)
 	}
 	defaultLimitMode = cfg.DefaultLimitMode
-	cpm = m
 
-	go wait.Until(syncCacheLimit, time.Second, config.ShutdownChan)
+	stopCh = make(chan struct{})
 	missMax, missMin := 20, 10
 	dynamicFunc := func() { startDynamic(cfg, missMax, missMin) }
-	go wait.Until(dynamicFunc, time.Duration(cfg.AdjustInterval)*time.Millisecond, config.ShutdownChan)
+	until(syncCacheLimit, time.Second, stopCh)
+	until(dynamicFunc, time.Duration(cfg.AdjustInterval)*time.Millisecond, stopCh)
+	atomic.StoreInt32(&enable, 1)
 	return nil
 }
 
+// until runs f periodically in a goroutine tracked by running until stop is closed
+func until(f func(), period time.Duration, stop <-chan struct{}) {
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		wait.Until(f, period, stop)
+	}()
+}
+
+// Reload applies the new config to cache limit, the dynamic limit adjusted so far is kept within the new range.
+// Cache limit is left stopped if the new config fails.
+func Reload(cfg *config.CacheConfig) error {
+	runLock.Lock()
+	defer runLock.Unlock()
+	stop()
+	return start(cpm, cfg)
+}
+
+// Stop stops the cache limit goroutines
+func Stop() {
+	runLock.Lock()
+	defer runLock.Unlock()
+	stop()
+}
+
+// stop stops the cache limit goroutines and waits for them to exit, the caller must hold runLock
+func stop() {
+	atomic.StoreInt32(&enable, 0)
+	if stopCh != nil {
+		close(stopCh)
+		stopCh = nil
+	}
+	running.Wait()
+	for _, v := range []*metrics.Vec{podIPC, podCPUUsage, podCacheMiss, podLLCMiss, dynamicL3Percent, dynamicMbPercent} {
+		v.Reset()
+	}
+}
+
 func isHostPidns(path string) bool {
 	ns, err := os.Readlink(path)
 	if err != nil {
@@ -118,8 +199,7 @@ func isHostPidns(path string) bool {
 }
 
 func checkCacheCfg(cfg *config.CacheConfig) error {
-	defaultLimitMode = cfg.DefaultLimitMode
-	if defaultLimitMode != staticMode && defaultLimitMode != dynamicMode {
+	if cfg.DefaultLimitMode != staticMode && cfg.DefaultLimitMode != dynamicMode {
 		return errors.Errorf("invalid cache limit mode: %s, should be %s or %s",
 			cfg.DefaultLimitMode, staticMode, dynamicMode)
 	}
@@ -155,8 +235,7 @@ func initCacheLimitDir(cfg *config.CacheConfig) error {
 		return errors.Errorf("get NUMA nodes number error: %v", err)
 	}
 
-	l3PercentDynamic = cfg.L3Percent.Low
-	mbPercentDynamic = cfg.MemBandPercent.Low
+	resumeDynamicPercent(cfg)
 	cacheLimitList := []*cacheLimitSet{
 		newCacheLimitSet(cfg.DefaultResctrlDir, dynamicLevel, l3PercentDynamic, mbPercentDynamic),
 		newCacheLimitSet(cfg.DefaultResctrlDir, lowLevel, cfg.L3Percent.Low, cfg.MemBandPercent.Low),
@@ -210,18 +289,24 @@ func (cl *cacheLimitSet) setClDir() error {
 	if len(cl.clDir) == 0 {
 		return errors.Errorf("cache limit path empty")
 	}
-	if err := os.Mkdir(cl.clDir, constant.DefaultDirMode); err != nil && !os.IsExist(err) {
+	if err := journal.Mkdir(moduleName, cl.clDir); err != nil {
 		return errors.Errorf("create cache limit directory error: %v", err)
 	}
 	return nil
 }
 
+// writeResctrlSchemata writes the limit of the resources supported by the kernel, the L3 cache or
+// the memory bandwidth missing is left unlimited
 func (cl *cacheLimitSet) writeResctrlSchemata(numaNum int) error {
-	// get cbm mask like "fffff" means 20 cache way
-	maskFile := filepath.Join(filepath.Dir(cl.clDir), "info", "L3", "cbm_mask")
-	llc, err := calcLimitedCacheValue(maskFile, cl.L3Percent)
-	if err != nil {
-		return errors.Errorf("get limited cache value from L3 percent error: %v", err)
+	l3, mb := capability.Supported(capability.ResctrlL3), capability.Supported(capability.ResctrlMB)
+	var llc string
+	if l3 {
+		// get cbm mask like "fffff" means 20 cache way
+		maskFile := filepath.Join(filepath.Dir(cl.clDir), "info", "L3", "cbm_mask")
+		var err error
+		if llc, err = calcLimitedCacheValue(maskFile, cl.L3Percent); err != nil {
+			return errors.Errorf("get limited cache value from L3 percent error: %v", err)
+		}
 	}
 
 	if err := cl.setClDir(); err != nil {
@@ -230,9 +315,14 @@ func (cl *cacheLimitSet) writeResctrlSchemata(numaNum int) error {
 	schemetaFile := filepath.Join(cl.clDir, schemataFile)
 	var content string
 	for i := 0; i < numaNum; i++ {
-		content = content + fmt.Sprintf("L3:%d=%s\n", i, llc) + fmt.Sprintf("MB:%d=%d\n", i, cl.MbPercent)
+		if l3 {
+			content += fmt.Sprintf("L3:%d=%s\n", i, llc)
+		}
+		if mb {
+			content += fmt.Sprintf("MB:%d=%d\n", i, cl.MbPercent)
+		}
 	}
-	if err := ioutil.WriteFile(schemetaFile, []byte(content), constant.DefaultFileMode); err != nil {
+	if err := dryrun.WriteFile(moduleName, schemetaFile, content, "set limit of "+cl.level); err != nil {
 		return errors.Errorf("write %s to file %s error: %v", content, schemetaFile, err)
 	}
 
@@ -244,12 +334,37 @@ func (cl *cacheLimitSet) doFlush() error {
 		return errors.Errorf("adjust dynamic cache limit to l3:%v mb:%v error: %v",
 			cl.L3Percent, cl.MbPercent, err)
 	}
-	l3PercentDynamic = cl.L3Percent
-	mbPercentDynamic = cl.MbPercent
+	setDynamicPercent(cl.L3Percent, cl.MbPercent)
 
 	return nil
 }
 
+// dynamicState is the dynamic limit kept in the checkpoint
+type dynamicState struct {
+	L3Percent int `json:"l3Percent"`
+	MbPercent int `json:"mbPercent"`
+}
+
+func setDynamicPercent(l3, mb int) {
+	l3PercentDynamic, mbPercentDynamic = l3, mb
+	dynamicL3Percent.Set(float64(l3))
+	dynamicMbPercent.Set(float64(mb))
+	if cpm != nil {
+		cpm.SetModuleState(moduleName, &dynamicState{L3Percent: l3, MbPercent: mb})
+	}
+}
+
+// resumeDynamicPercent resumes the dynamic limit adjusted before restart or reload within the range
+// of cfg, the dynamic limit starts from the low one if there is none
+func resumeDynamicPercent(cfg *config.CacheConfig) {
+	st := dynamicState{L3Percent: cfg.L3Percent.Low, MbPercent: cfg.MemBandPercent.Low}
+	if cpm != nil && cpm.ModuleState(moduleName, &st) {
+		log.Infof("resume dynamic limit L3 %v, Mb %v", st.L3Percent, st.MbPercent)
+	}
+	setDynamicPercent(nextPercent(st.L3Percent, cfg.L3Percent.Low, cfg.L3Percent.High, 0),
+		nextPercent(st.MbPercent, cfg.MemBandPercent.Low, cfg.MemBandPercent.High, 0))
+}
+
 func (cl *cacheLimitSet) flush(cfg *config.CacheConfig, step int) error {
 	l3 := nextPercent(l3PercentDynamic, cfg.L3Percent.Low, cfg.L3Percent.High, step)
 	mb := nextPercent(mbPercentDynamic, cfg.MemBandPercent.Low, cfg.MemBandPercent.High, step)
@@ -289,6 +404,11 @@ func startDynamic(cfg *config.CacheConfig, missMax, missMin int) {
 		ipc, cpuUsage, cacheMiss, LLCMiss, err := getPodPerf(p, cfg.PerfDuration)
 		if err != nil {
 			log.Errorf(err.Error())
+		} else {
+			podIPC.Set(ipc, p.Namespace, p.Name)
+			podCPUUsage.Set(float64(cpuUsage), p.Namespace, p.Name)
+			podCacheMiss.Set(float64(cacheMiss), p.Namespace, p.Name)
+			podLLCMiss.Set(float64(LLCMiss), p.Namespace, p.Name)
 		}
 
 		if estimateQosViolation(p, cpuUsage, missMax, cacheMiss, LLCMiss, ipcMin, ipc) {
@@ -350,8 +470,8 @@ func dynamicExist() bool {
 
 // getPodPerf return ipc, cpu usage, cache miss, llc miss of the pod
 func getPodPerf(pi *typedef.PodInfo, perfDu int) (float64, int, int, int, error) {
-	perfPath := filepath.Join(config.CgroupRoot, perfEvent, pi.CgroupPath)
-	loadPath := filepath.Join(config.CgroupRoot, cpu, pi.CgroupPath)
+	perfPath := cgroup.PodPath(perfEvent, pi)
+	loadPath := cgroup.PodPath(cpu, pi)
 	if !util.PathExist(perfPath) {
 		return 0.0, 0, 0, 0, errors.Errorf("path %v not exist, cannot get perf statistics", perfPath)
 	}
@@ -360,14 +480,12 @@ func getPodPerf(pi *typedef.PodInfo, perfDu int) (float64, int, int, int, error)
 	}
 
 	tStart := time.Now().UnixNano()
-	cpuUsageStart, _ := ioutil.ReadFile(filepath.Join(loadPath, "cpuacct.usage"))
-	cpuStart, _ := strconv.ParseInt(strings.TrimSpace(string(cpuUsageStart)), base10, bitSize)
+	cpuStart := getCPUUsage(loadPath)
 
 	stat, err := perf.CgroupStat(perfPath, time.Duration(perfDu)*time.Millisecond)
 
 	tStop := time.Now().UnixNano()
-	cpuUsageStop, _ := ioutil.ReadFile(filepath.Join(loadPath, "cpuacct.usage"))
-	cpuStop, _ := strconv.ParseInt(strings.TrimSpace(string(cpuUsageStop)), base10, bitSize)
+	cpuStop := getCPUUsage(loadPath)
 
 	if err != nil {
 		return 0.0, 0, 0, 0, err
@@ -380,6 +498,12 @@ func getPodPerf(pi *typedef.PodInfo, perfDu int) (float64, int, int, int, error)
 		nil
 }
 
+// getCPUUsage returns the cpu time consumed by the cgroup in nanoseconds
+func getCPUUsage(path string) int64 {
+	ns, _ := cgroup.ReadCPUUsage(path)
+	return ns
+}
+
 func getLoadAvg() (float64, error) {
 	var loadavg float64
 	file, err := os.Open("/proc/loadavg")
@@ -395,7 +519,7 @@ func getLoadAvg() (float64, error) {
 }
 
 func getPodCacheMiss(pi *typedef.PodInfo, perfDu int) (int, int) {
-	cgroupPath := filepath.Join(config.CgroupRoot, perfEvent, pi.CgroupPath)
+	cgroupPath := cgroup.PodPath(perfEvent, pi)
 	if !util.PathExist(cgroupPath) {
 		return 0, 0
 	}
@@ -411,7 +535,7 @@ func getPodCacheMiss(pi *typedef.PodInfo, perfDu int) (int, int) {
 
 // ClEnabled return if cache limit is enabled
 func ClEnabled() bool {
-	return enable
+	return atomic.LoadInt32(&enable) == 1
 }
 
 // checkResctrlExist check if resctrl directory exists
@@ -445,7 +569,7 @@ func getNUMANum(path string) (int, error) {
 
 // getBinaryMask get l3 limit mask like "7ff" and transfer it to binary like "111 1111 1111", return binary length 11
 func getBinaryMask(path string) (int, error) {
-	maskValue, err := ioutil.ReadFile(filepath.Clean(path))
+	maskValue, err := cgroup.ReadFile(path)
 	if err != nil {
 		return -1, errors.Errorf("get L3 mask value error: %v", err)
 	}
@@ -21,9 +21,11 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"isula.org/rubik/pkg/capability"
 	"isula.org/rubik/pkg/checkpoint"
 	"isula.org/rubik/pkg/config"
 	"isula.org/rubik/pkg/constant"
@@ -268,6 +270,25 @@ func TestWriteResctrlSchemata(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "TC-memory bandwidth allocation only",
+			fields: fields{
+				level:     lowLevel,
+				clDir:     filepath.Join(testFolder, "mbonly"),
+				L3Percent: 30,
+				MbPercent: 30,
+			},
+			args: args{numaNum: 2},
+			preHook: func(t *testing.T) {
+				capability.SetReport(map[string]bool{capability.ResctrlMB: true})
+			},
+			postHook: func(t *testing.T) {
+				capability.SetReport(nil)
+				b, err := ioutil.ReadFile(filepath.Join(testFolder, "mbonly", schemataFile))
+				assert.NoError(t, err)
+				assert.Equal(t, "MB:0=30\nMB:1=30\n", string(b))
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -879,7 +900,7 @@ func TestStartDynamic(t *testing.T) {
 }
 
 func TestClEnabled(t *testing.T) {
-	oldEnbaled := enable
+	oldEnbaled := atomic.LoadInt32(&enable)
 	tests := []struct {
 		preHook  func(t *testing.T)
 		postHook func(t *testing.T)
@@ -889,10 +910,10 @@ func TestClEnabled(t *testing.T) {
 		{
 			name: "TC-return enabled",
 			preHook: func(t *testing.T) {
-				enable = true
+				atomic.StoreInt32(&enable, 1)
 			},
 			postHook: func(t *testing.T) {
-				enable = oldEnbaled
+				atomic.StoreInt32(&enable, oldEnbaled)
 			},
 			want: true,
 		},
@@ -938,6 +959,8 @@ func TestInit(t *testing.T) {
 	if !perf.HwSupport() {
 		TC1WantErr = true
 	}
+	defer capability.SetReport(capability.SetReport(map[string]bool{capability.PerfHardware: perf.HwSupport(),
+		capability.ResctrlL3: true, capability.ResctrlMB: true}))
 	type args struct {
 		cfg config.CacheConfig
 	}
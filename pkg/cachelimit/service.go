@@ -0,0 +1,125 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-17
+// Description: cache limit service registration
+
+package cachelimit
+
+import (
+	"reflect"
+
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+type cacheLimitService struct {
+	cfg config.CacheConfig
+}
+
+func init() {
+	services.Register(&cacheLimitService{})
+}
+
+// Name returns the service name
+func (s *cacheLimitService) Name() string {
+	return moduleName
+}
+
+// Enabled tells whether cache limit is enabled in config
+func (s *cacheLimitService) Enabled(cfg *config.Config) bool {
+	return cfg.CacheCfg.Enable
+}
+
+// Init creates resctrl groups and starts cache limit
+func (s *cacheLimitService) Init(cfg *config.Config, cpm *checkpoint.Manager) error {
+	// the running goroutines hold their own copy of config
+	c := cfg.CacheCfg
+	s.cfg = c
+	return Init(cpm, &c)
+}
+
+// Validate checks the new cache limit config
+func (s *cacheLimitService) Validate(cfg *config.Config) error {
+	return checkCacheCfg(&cfg.CacheCfg)
+}
+
+// Reload rewrites the resctrl schemata if the cache limit config changes
+func (s *cacheLimitService) Reload(cfg *config.Config) error {
+	if reflect.DeepEqual(s.cfg, cfg.CacheCfg) {
+		return nil
+	}
+	c := cfg.CacheCfg
+	s.cfg = c
+	return Reload(&c)
+}
+
+// PodAdded moves tasks of the new offline pod to its resctrl group
+func (s *cacheLimitService) PodAdded(pod *typedef.PodInfo) error {
+	if !pod.Offline {
+		return nil
+	}
+	return syncPod(pod)
+}
+
+// PodUpdated moves tasks of the offline pod to the resctrl group of its current level
+func (s *cacheLimitService) PodUpdated(old, new *typedef.PodInfo) error {
+	return s.PodAdded(new)
+}
+
+// PodDeleted does nothing as tasks leave resctrl groups when they exit
+func (s *cacheLimitService) PodDeleted(pod *typedef.PodInfo) error {
+	return nil
+}
+
+// Sync moves tasks of all offline pods to their resctrl groups
+func (s *cacheLimitService) Sync(pods map[string]*typedef.PodInfo) error {
+	for _, pod := range pods {
+		if !pod.Offline {
+			continue
+		}
+		if err := syncPod(pod); err != nil {
+			log.Errorf("sync pod %v cache limit error: %v", pod.UID, err)
+		}
+	}
+	return nil
+}
+
+// Reconcile moves the tasks of offline pods missing from their resctrl groups
+func (s *cacheLimitService) Reconcile(pods map[string]*typedef.PodInfo) ([]services.Correction, error) {
+	var corrections []services.Correction
+	for _, pod := range pods {
+		if !pod.Offline {
+			continue
+		}
+		c, err := reconcileTasks(pod, resctrlDir)
+		if err != nil {
+			log.Errorf("reconcile pod %v cache limit error: %v", pod.UID, err)
+		}
+		corrections = append(corrections, c...)
+	}
+	return corrections, nil
+}
+
+// Shutdown stops the service
+func (s *cacheLimitService) Shutdown() error {
+	Stop()
+	return nil
+}
+
+func syncPod(pi *typedef.PodInfo) error {
+	if err := SyncLevel(pi); err != nil {
+		return err
+	}
+	return SetCacheLimit(pi)
+}
@@ -0,0 +1,197 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-05
+// Description: kernel capability probe
+
+// Package capability probes the kernel interfaces rubik uses at startup, so that the modules
+// decide whether to run, degrade or refuse to start by the report instead of failing on every write
+package capability
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/metrics"
+	"isula.org/rubik/pkg/perf"
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	// CgroupV2 means the unified hierarchy is mounted at the cgroup root
+	CgroupV2 = "cgroup_v2"
+	// CPUQosLevel means cpu.qos_level of openEuler kernels exists
+	CPUQosLevel = "cpu_qos_level"
+	// MemoryQosLevel means memory.qos_level of openEuler kernels exists
+	MemoryQosLevel = "memory_qos_level"
+	// CPUIdle means cpu.idle of upstream kernels since 5.15 exists
+	CPUIdle = "cpu_idle"
+	// CfsBurst means cpu.cfs_burst_us, or cpu.max.burst on cgroup v2, exists
+	CfsBurst = "cfs_burst"
+	// MemoryHigh means memory.high exists
+	MemoryHigh = "memory_high"
+	// MemoryLow means memory.low and memory.min protecting the memory of cgroups exist
+	MemoryLow = "memory_low"
+	// MemoryReclaim means memory.reclaim reclaiming the memory of cgroups proactively exists
+	MemoryReclaim = "memory_reclaim"
+	// MemoryHighAsyncRatio means memory.high_async_ratio of openEuler kernels exists
+	MemoryHighAsyncRatio = "memory_high_async_ratio"
+	// ResctrlL3 means the resctrl schemata has L3 cache allocation
+	ResctrlL3 = "resctrl_l3"
+	// ResctrlMB means the resctrl schemata has memory bandwidth allocation
+	ResctrlMB = "resctrl_mb"
+	// PSI means the pressure stall information of memory is available
+	PSI = "psi"
+	// PerfHardware means the hardware perf events can be counted for cgroups
+	PerfHardware = "perf_hardware"
+)
+
+// psiFile is the system-wide memory pressure, which fails to be read if psi is disabled
+var psiFile = "/proc/pressure/memory"
+
+// capabilityGauge tells whether each capability is supported by the kernel
+var capabilityGauge = metrics.NewGauge("rubik_capability",
+	"Whether the kernel interface is supported, 1 for supported and 0 for not.", "capability")
+
+// probe tells whether a capability is supported, and the detail of the result for the log
+type probe func(cfg *config.Config) (bool, string)
+
+var probes = map[string]probe{
+	CgroupV2: func(cfg *config.Config) (bool, string) {
+		return cgroup.IsV2(), "cgroup " + string(cgroup.GetMode()) + " mounted at " + cgroup.Root()
+	},
+	CPUQosLevel:          cgroupFile("cpu", constant.CPUCgroupFileName),
+	MemoryQosLevel:       cgroupFile("memory", constant.MemoryCgroupFileName),
+	CPUIdle:              cgroupFile("cpu", "cpu.idle"),
+	CfsBurst:             cgroupFile("cpu", "cpu.cfs_burst_us"),
+	MemoryHigh:           cgroupFile("memory", "memory.high"),
+	MemoryHighAsyncRatio: cgroupFile("memory", "memory.high_async_ratio"),
+	MemoryLow:            cgroupFile("memory", "memory.low"),
+	MemoryReclaim:        cgroupFile("memory", "memory.reclaim"),
+	ResctrlL3:            resctrlResource("L3"),
+	ResctrlMB:            resctrlResource("MB"),
+	PSI: func(cfg *config.Config) (bool, string) {
+		if _, err := ioutil.ReadFile(psiFile); err != nil {
+			return false, err.Error()
+		}
+		return true, psiFile
+	},
+	PerfHardware: func(cfg *config.Config) (bool, string) {
+		return perf.HwSupport(), "hardware events of perf_event cgroup"
+	},
+}
+
+var (
+	reportLock sync.RWMutex
+	// report is nil until Probe is called, all capabilities are assumed supported then,
+	// so that the modules work the same as before in tests
+	report map[string]bool
+)
+
+// cgroupFile probes the cgroup v1 file of subsys, or its cgroup v2 equivalent, in the kubepods cgroup
+func cgroupFile(subsys, name string) probe {
+	return func(cfg *config.Config) (bool, string) {
+		file, ok := cgroup.FileName(name)
+		if !ok {
+			return false, name + " has no equivalent on cgroup v2"
+		}
+		dir := cgroup.Path(subsys, cgroup.KubepodsCgroupPath(corev1.PodQOSGuaranteed))
+		if _, err := cgroup.ReadValue(dir, file); err != nil {
+			return false, err.Error()
+		}
+		return true, filepath.Join(dir, file)
+	}
+}
+
+// resctrlResource probes the resource in the schemata of the resctrl root, such as "L3:0=7ff;1=7ff"
+func resctrlResource(resource string) probe {
+	return func(cfg *config.Config) (bool, string) {
+		path := filepath.Join(cfg.CacheCfg.DefaultResctrlDir, "schemata")
+		content, err := cgroup.ReadFile(path)
+		if err != nil {
+			return false, err.Error()
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			if strings.HasPrefix(strings.TrimSpace(scanner.Text()), resource+":") {
+				return true, path
+			}
+		}
+		return false, resource + " not found in " + path
+	}
+}
+
+// Probe detects the capabilities of the kernel, which is called after the cgroup mode and driver are initialized
+func Probe(cfg *config.Config) {
+	r := make(map[string]bool, len(probes))
+	for _, name := range names() {
+		ok, detail := probes[name](cfg)
+		r[name] = ok
+		value := 0.0
+		if ok {
+			value = 1
+		}
+		capabilityGauge.Set(value, name)
+		log.Infof("capability %s supported: %v (%s)", name, ok, detail)
+	}
+	SetReport(r)
+}
+
+func names() []string {
+	list := make([]string, 0, len(probes))
+	for name := range probes {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// SetReport replaces the capability report and returns the previous one
+func SetReport(r map[string]bool) map[string]bool {
+	reportLock.Lock()
+	defer reportLock.Unlock()
+	old := report
+	report = r
+	return old
+}
+
+// Supported tells whether the capability is supported by the kernel
+func Supported(name string) bool {
+	reportLock.RLock()
+	defer reportLock.RUnlock()
+	if report == nil {
+		return true
+	}
+	return report[name]
+}
+
+// Report returns a copy of the capability report, which is nil before Probe is called
+func Report() map[string]bool {
+	reportLock.RLock()
+	defer reportLock.RUnlock()
+	if report == nil {
+		return nil
+	}
+	r := make(map[string]bool, len(report))
+	for name, ok := range report {
+		r[name] = ok
+	}
+	return r
+}
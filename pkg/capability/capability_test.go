@@ -0,0 +1,76 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-05
+// Description: kernel capability probe test
+
+package capability
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+)
+
+// TestProbe tests the capabilities are probed from the kubepods cgroup, the resctrl schemata and psi
+func TestProbe(t *testing.T) {
+	fake := cgroup.NewFake()
+	defer cgroup.SetBackend(cgroup.SetBackend(fake))
+	defer cgroup.SetMode(cgroup.SetMode(cgroup.ModeV1))
+	defer SetReport(SetReport(nil))
+	defer func(old string) { psiFile = old }(psiFile)
+	psiFile = filepath.Join(constant.TmpTestDir, "path/not/exist")
+
+	c := &config.Config{CacheCfg: config.CacheConfig{DefaultResctrlDir: "/sys/fs/resctrl"}}
+	kubepods := cgroup.KubepodsCgroupPath(corev1.PodQOSGuaranteed)
+	fake.Set(filepath.Join(cgroup.Path("cpu", kubepods), "cpu.cfs_burst_us"), "0")
+	fake.Set(filepath.Join(cgroup.Path("cpu", kubepods), constant.CPUCgroupFileName), "0")
+	fake.Set(filepath.Join(cgroup.Path("memory", kubepods), "memory.high"), "max")
+	fake.Set("/sys/fs/resctrl/schemata", "    MB:0=100;1=100\n")
+
+	assert.True(t, Supported(CPUIdle))
+	assert.Nil(t, Report())
+	Probe(c)
+	for name, want := range map[string]bool{
+		CgroupV2:             false,
+		CPUQosLevel:          true,
+		MemoryQosLevel:       false,
+		CPUIdle:              false,
+		CfsBurst:             true,
+		MemoryHigh:           true,
+		MemoryHighAsyncRatio: false,
+		MemoryLow:            false,
+		MemoryReclaim:        false,
+		ResctrlL3:            false,
+		ResctrlMB:            true,
+		PSI:                  false,
+	} {
+		assert.Equal(t, want, Supported(name), name)
+	}
+	assert.Len(t, Report(), len(probes))
+
+	// cpu.qos_level has no equivalent on cgroup v2, and cpu.cfs_burst_us is cpu.max.burst
+	cgroup.SetMode(cgroup.ModeV2)
+	fake.Set(filepath.Join(cgroup.Path("cpu", kubepods), constant.CPUCgroupFileName), "0")
+	fake.Set(filepath.Join(cgroup.Path("cpu", kubepods), "cpu.max.burst"), "0")
+	fake.Set(filepath.Join(cgroup.Path("memory", kubepods), "memory.low"), "0")
+	Probe(c)
+	assert.True(t, Supported(CgroupV2))
+	assert.False(t, Supported(CPUQosLevel))
+	assert.True(t, Supported(CfsBurst))
+	assert.False(t, Supported(MemoryHigh))
+	assert.True(t, Supported(MemoryLow))
+}
@@ -0,0 +1,170 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-27
+// Description: backends of cgroup filesystem access
+
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"isula.org/rubik/pkg/constant"
+)
+
+// Backend accesses the files under cgroup, resctrl and procfs, the errors returned
+// should be recognized by os.IsNotExist and os.IsExist as the ones of os package
+type Backend interface {
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte) error
+	Mkdir(path string) error
+	Remove(path string) error
+}
+
+var (
+	backendLock sync.RWMutex
+	backend     Backend = sysfs{}
+)
+
+// SetBackend replaces the backend and returns the previous one
+func SetBackend(b Backend) Backend {
+	backendLock.Lock()
+	defer backendLock.Unlock()
+	old := backend
+	backend = b
+	return old
+}
+
+func getBackend() Backend {
+	backendLock.RLock()
+	defer backendLock.RUnlock()
+	return backend
+}
+
+// sysfs is the backend operating the real filesystem
+type sysfs struct{}
+
+// ReadFile reads the whole file
+func (sysfs) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Clean(path))
+}
+
+// WriteFile writes data to the existing file, cgroup files can not be created
+func (sysfs) WriteFile(path string, data []byte) error {
+	return ioutil.WriteFile(path, data, constant.DefaultFileMode)
+}
+
+// Mkdir creates a directory
+func (sysfs) Mkdir(path string) error {
+	return os.Mkdir(path, constant.DefaultDirMode)
+}
+
+// Remove removes a file or an empty directory
+func (sysfs) Remove(path string) error {
+	return os.Remove(path)
+}
+
+// Fake is an in-memory backend for tests, writing a file overwrites the whole content
+type Fake struct {
+	lock  sync.Mutex
+	files map[string]string
+	dirs  map[string]bool
+}
+
+// NewFake creates an empty in-memory backend
+func NewFake() *Fake {
+	return &Fake{files: make(map[string]string), dirs: make(map[string]bool)}
+}
+
+// Set creates or overwrites the file without any check
+func (f *Fake) Set(path, value string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.files[filepath.Clean(path)] = value
+}
+
+// Get returns the content of the file and whether it exists
+func (f *Fake) Get(path string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	v, ok := f.files[filepath.Clean(path)]
+	return v, ok
+}
+
+// Dirs lists the directories created by Mkdir in order
+func (f *Fake) Dirs() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var dirs []string
+	for d := range f.dirs {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// ReadFile returns the content set before
+func (f *Fake) ReadFile(path string) ([]byte, error) {
+	v, ok := f.Get(path)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return []byte(v), nil
+}
+
+// WriteFile overwrites the file set before, as files under cgroup can not be created by writing
+func (f *Fake) WriteFile(path string, data []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path = filepath.Clean(path)
+	if _, ok := f.files[path]; !ok {
+		return &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	f.files[path] = string(data)
+	return nil
+}
+
+// Mkdir records the directory
+func (f *Fake) Mkdir(path string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path = filepath.Clean(path)
+	if f.dirs[path] {
+		return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrExist}
+	}
+	f.dirs[path] = true
+	return nil
+}
+
+// Remove removes the file or the directory together with the files in it
+func (f *Fake) Remove(path string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path = filepath.Clean(path)
+	if _, ok := f.files[path]; ok {
+		delete(f.files, path)
+		return nil
+	}
+	if !f.dirs[path] {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	delete(f.dirs, path)
+	for p := range f.files {
+		if strings.HasPrefix(p, path+"/") {
+			delete(f.files, p)
+		}
+	}
+	return nil
+}
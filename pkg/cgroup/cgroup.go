@@ -0,0 +1,181 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-27
+// Description: cgroup filesystem access shared by modules
+
+// Package cgroup resolves the cgroup paths of pods and containers and reads or writes the files
+// under them through a pluggable backend, which is the real filesystem by default.
+package cgroup
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// Root returns the cgroup mount point
+func Root() string {
+	return config.CgroupRoot
+}
+
+// PodPath returns the cgroup directory of the pod under subsys, subsys is ignored on cgroup v2
+func PodPath(subsys string, pi *typedef.PodInfo) string {
+	root := pi.CgroupRoot
+	if root == "" {
+		root = Root()
+	}
+	if IsV2() {
+		return filepath.Join(root, pi.CgroupPath)
+	}
+	return filepath.Join(root, subsys, pi.CgroupPath)
+}
+
+// ContainerPath returns the cgroup directory of the container under subsys, empty if the container is unknown,
+// subsys is ignored on cgroup v2
+func ContainerPath(subsys string, ci *typedef.ContainerInfo) string {
+	if IsV2() {
+		if ci == nil || ci.Name == "" {
+			return ""
+		}
+		root := ci.CgroupRoot
+		if root == "" {
+			root = Root()
+		}
+		return filepath.Join(root, ci.CgroupAddr)
+	}
+	return ci.CgroupPath(subsys)
+}
+
+// Join joins file to the cgroup directory dir without escaping from it
+func Join(dir, file string) (string, error) {
+	path, err := securejoin.SecureJoin(dir, file)
+	if err != nil {
+		return "", errors.Errorf("join path failed for %s and %s: %v", dir, file, err)
+	}
+	return path, nil
+}
+
+// ReadFile reads the file at path through the backend
+func ReadFile(path string) ([]byte, error) {
+	return getBackend().ReadFile(path)
+}
+
+// WriteFile writes value to the file at path through the backend
+func WriteFile(path, value string) error {
+	return getBackend().WriteFile(path, []byte(value))
+}
+
+// Mkdir creates the directory at path through the backend
+func Mkdir(path string) error {
+	return getBackend().Mkdir(path)
+}
+
+// Remove removes the file or empty directory at path through the backend
+func Remove(path string) error {
+	return getBackend().Remove(path)
+}
+
+// ReadValue reads file under dir and trims the spaces
+func ReadValue(dir, file string) (string, error) {
+	path, err := Join(dir, file)
+	if err != nil {
+		return "", err
+	}
+	b, err := ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// ReadInt64 reads the integer in the first line of file under dir, "max" of cgroup v2 is read as math.MaxInt64
+func ReadInt64(dir, file string) (int64, error) {
+	v, err := ReadValue(dir, file)
+	if err != nil {
+		return 0, err
+	}
+	i, err := parseInt64(strings.Split(v, "\n")[0])
+	if err != nil {
+		return 0, errors.Errorf("parse %s under %s failed: %v", file, dir, err)
+	}
+	return i, nil
+}
+
+// ReadKeyInt64 reads the integer of key in the flat keyed file under dir, such as cpu.stat
+func ReadKeyInt64(dir, file, key string) (int64, error) {
+	lines, err := ReadLines(dir, file)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			i, err := parseInt64(fields[1])
+			if err != nil {
+				return 0, errors.Errorf("parse %s of %s under %s failed: %v", key, file, dir, err)
+			}
+			return i, nil
+		}
+	}
+	return 0, errors.Errorf("%s not found in %s under %s", key, file, dir)
+}
+
+// ReadCPUUsage reads the cpu time consumed by the cgroup under dir in nanoseconds, which is cpuacct.usage
+// on cgroup v1 and usage_usec of cpu.stat in microseconds on cgroup v2
+func ReadCPUUsage(dir string) (int64, error) {
+	if !IsV2() {
+		return ReadInt64(dir, "cpuacct.usage")
+	}
+	usec, err := ReadKeyInt64(dir, "cpu.stat", "usage_usec")
+	if err != nil {
+		return 0, err
+	}
+	return usec * int64(time.Microsecond), nil
+}
+
+func parseInt64(v string) (int64, error) {
+	const base, width = 10, 64
+	if v == "max" {
+		return math.MaxInt64, nil
+	}
+	return strconv.ParseInt(v, base, width)
+}
+
+// ReadLines reads the non-empty lines of file under dir
+func ReadLines(dir, file string) ([]string, error) {
+	v, err := ReadValue(dir, file)
+	if err != nil || v == "" {
+		return nil, err
+	}
+	return strings.Split(v, "\n"), nil
+}
+
+// WriteValue writes value to file under dir
+func WriteValue(dir, file, value string) error {
+	path, err := Join(dir, file)
+	if err != nil {
+		return err
+	}
+	return WriteFile(path, value)
+}
+
+// WriteInt64 writes the integer to file under dir
+func WriteInt64(dir, file string, value int64) error {
+	return WriteValue(dir, file, strconv.FormatInt(value, 10))
+}
@@ -0,0 +1,161 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-27
+// Description: cgroup filesystem access test
+
+package cgroup
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/try"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// TestPath tests the path resolution of pods and containers
+func TestPath(t *testing.T) {
+	pi := &typedef.PodInfo{CgroupPath: "kubepods/podabc"}
+	assert.Equal(t, filepath.Join(config.CgroupRoot, "cpu", "kubepods/podabc"), PodPath("cpu", pi))
+	pi.CgroupRoot = "/tmp/cgroup"
+	assert.Equal(t, "/tmp/cgroup/memory/kubepods/podabc", PodPath("memory", pi))
+
+	ci := &typedef.ContainerInfo{Name: "c1", CgroupRoot: "/tmp/cgroup", CgroupAddr: "kubepods/podabc/id1"}
+	assert.Equal(t, "/tmp/cgroup/cpu/kubepods/podabc/id1", ContainerPath("cpu", ci))
+
+	path, err := Join("/tmp/cgroup/cpu", "../../../etc/passwd")
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/cgroup/cpu/etc/passwd", path)
+}
+
+// TestFake tests the helpers on the in-memory backend
+func TestFake(t *testing.T) {
+	fake := NewFake()
+	old := SetBackend(fake)
+	defer SetBackend(old)
+
+	const dir = "/sys/fs/cgroup/memory/kubepods/podabc"
+	fake.Set(dir+"/memory.limit_in_bytes", "9223372036854771712\n")
+	fake.Set(dir+"/cgroup.procs", "1\n2\n")
+
+	v, err := ReadInt64(dir, "memory.limit_in_bytes")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9223372036854771712), v)
+	lines, err := ReadLines(dir, "cgroup.procs")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, lines)
+
+	assert.NoError(t, WriteInt64(dir, "memory.limit_in_bytes", 1024))
+	got, _ := fake.Get(dir + "/memory.limit_in_bytes")
+	assert.Equal(t, "1024", got)
+
+	// files under cgroup can not be created by writing
+	err = WriteValue(dir, "memory.not_exist", "1")
+	assert.True(t, os.IsNotExist(err))
+	_, err = ReadValue(dir, "memory.not_exist")
+	assert.True(t, os.IsNotExist(err))
+
+	fake.Set(dir+"/memory.high", "unlimited")
+	_, err = ReadInt64(dir, "memory.high")
+	assert.Error(t, err)
+
+	const sub = "/sys/fs/resctrl/rubik_low"
+	assert.NoError(t, Mkdir(sub))
+	assert.True(t, os.IsExist(Mkdir(sub)))
+	fake.Set(sub+"/tasks", "")
+	assert.Equal(t, []string{sub}, fake.Dirs())
+	assert.NoError(t, Remove(sub))
+	_, ok := fake.Get(sub + "/tasks")
+	assert.False(t, ok)
+	assert.True(t, os.IsNotExist(Remove(sub)))
+}
+
+// TestSysfs tests the helpers on the real filesystem
+func TestSysfs(t *testing.T) {
+	dir := try.GenTestDir().String()
+	defer try.DelTestDir()
+
+	file := filepath.Join(dir, "cpu.qos_level")
+	try.WriteFile(file, []byte("0\n"), constant.DefaultFileMode)
+	assert.NoError(t, WriteInt64(dir, "cpu.qos_level", -1))
+	v, err := ReadInt64(dir, "cpu.qos_level")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), v)
+
+	sub := filepath.Join(dir, "sub")
+	assert.NoError(t, Mkdir(sub))
+	assert.True(t, os.IsExist(Mkdir(sub)))
+	assert.NoError(t, Remove(sub))
+}
+
+// TestMode tests the path and file mapping of cgroup v2
+func TestMode(t *testing.T) {
+	dir := try.GenTestDir().String()
+	defer try.DelTestDir()
+	assert.Equal(t, ModeV1, DetectMode(dir))
+	try.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpu io memory"), constant.DefaultFileMode)
+	assert.Equal(t, ModeV2, DetectMode(dir))
+
+	old := SetMode(ModeV2)
+	defer SetMode(old)
+
+	pi := &typedef.PodInfo{CgroupRoot: "/tmp/cgroup", CgroupPath: "kubepods/podabc"}
+	assert.Equal(t, "/tmp/cgroup/kubepods/podabc", PodPath("memory", pi))
+	ci := &typedef.ContainerInfo{Name: "c1", CgroupRoot: "/tmp/cgroup", CgroupAddr: "kubepods/podabc/id1"}
+	assert.Equal(t, "/tmp/cgroup/kubepods/podabc/id1", ContainerPath("cpu", ci))
+	assert.Equal(t, "", ContainerPath("cpu", &typedef.ContainerInfo{}))
+	assert.Equal(t, filepath.Join(Root(), "kubepods"), Path("perf_event", "kubepods"))
+
+	name, ok := FileName("cpu.cfs_burst_us")
+	assert.True(t, ok)
+	assert.Equal(t, "cpu.max.burst", name)
+	_, ok = FileName("cpu.qos_level")
+	assert.False(t, ok)
+	_, ok = FileName("cpu.qos_level")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"cpu.qos_level"}, ListUnsupported())
+
+	fake := NewFake()
+	oldBackend := SetBackend(fake)
+	defer SetBackend(oldBackend)
+	fake.Set("/tmp/cgroup/kubepods/memory.max", "max\n")
+	fake.Set("/tmp/cgroup/kubepods/cpu.stat", "usage_usec 1200\nuser_usec 1000\nsystem_usec 200\n")
+	v, err := ReadInt64("/tmp/cgroup/kubepods", "memory.max")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), v)
+	v, err = ReadKeyInt64("/tmp/cgroup/kubepods", "cpu.stat", "usage_usec")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1200), v)
+	_, err = ReadKeyInt64("/tmp/cgroup/kubepods", "cpu.stat", "nr_periods")
+	assert.Error(t, err)
+	v, err = ReadCPUUsage("/tmp/cgroup/kubepods")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1200000), v)
+	// memory.high throttles instead of only reclaiming under pressure as the soft limit
+	_, ok = FileName("memory.soft_limit_in_bytes")
+	assert.False(t, ok)
+
+	SetMode(ModeV1)
+	name, ok = FileName("cpu.qos_level")
+	assert.True(t, ok)
+	assert.Equal(t, "cpu.qos_level", name)
+	assert.Empty(t, ListUnsupported())
+	fake.Set("/tmp/cgroup/kubepods/cpuacct.usage", "1200\n")
+	v, err = ReadCPUUsage("/tmp/cgroup/kubepods")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1200), v)
+}
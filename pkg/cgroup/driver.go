@@ -0,0 +1,155 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-10-28
+// Description: pod and container cgroup naming of kubelet cgroup drivers
+
+package cgroup
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/typedef"
+)
+
+const (
+	// DriverCgroupfs names cgroups by path, such as kubepods/burstable/pod<uid>/<id>
+	DriverCgroupfs = "cgroupfs"
+	// DriverSystemd names cgroups by systemd units, such as kubepods.slice/kubepods-burstable.slice/
+	// kubepods-burstable-pod<uid>.slice/cri-containerd-<id>.scope
+	DriverSystemd = "systemd"
+
+	configHashAnnotationKey = "kubernetes.io/config.hash"
+)
+
+var (
+	driverLock sync.RWMutex
+	driver     = DriverCgroupfs
+	// rootNames is the cgroup name of kubelet --cgroup-root split into components
+	rootNames []string
+)
+
+// ValidateDriver checks the cgroup driver
+func ValidateDriver(d string) error {
+	switch d {
+	case DriverCgroupfs, DriverSystemd:
+		return nil
+	default:
+		return errors.Errorf("unsupported cgroup driver %q, expect %s|%s", d, DriverCgroupfs, DriverSystemd)
+	}
+}
+
+// SetDriver sets the cgroup driver and the cgroup root of kubelet, the root is in the format of
+// kubelet --cgroup-root, such as "/", "/custom" for cgroupfs and "/custom.slice" for systemd
+func SetDriver(d, kubeletRoot string) error {
+	if err := ValidateDriver(d); err != nil {
+		return err
+	}
+	driverLock.Lock()
+	defer driverLock.Unlock()
+	driver, rootNames = d, parseRoot(d, kubeletRoot)
+	return nil
+}
+
+// GetDriver returns the cgroup driver
+func GetDriver() string {
+	driverLock.RLock()
+	defer driverLock.RUnlock()
+	return driver
+}
+
+// parseRoot splits the cgroup root into cgroup names, a systemd slice like
+// "a.slice/a-b.slice" is named by its last component as kubelet does
+func parseRoot(d, root string) []string {
+	var names []string
+	for _, part := range strings.Split(root, "/") {
+		if part == "" {
+			continue
+		}
+		if d == DriverSystemd && strings.HasSuffix(part, ".slice") {
+			part = strings.TrimSuffix(part, ".slice")
+			part = part[strings.LastIndex(part, "-")+1:]
+		}
+		names = append(names, part)
+	}
+	return names
+}
+
+// PodCgroupPath returns the cgroup path of the pod relative to the cgroup root of subsystems
+func PodCgroupPath(pod *corev1.Pod) string {
+	id := string(pod.UID)
+	if configHash := pod.Annotations[configHashAnnotationKey]; configHash != "" {
+		id = configHash
+	}
+	names := qosNames(pod.Status.QOSClass)
+	if names == nil {
+		return ""
+	}
+	return namesPath(append(names, constant.PodCgroupNamePrefix+id))
+}
+
+// KubepodsCgroupPath returns the cgroup path which the pods of qos class are under,
+// the path of guaranteed pods is the kubepods cgroup itself
+func KubepodsCgroupPath(qos corev1.PodQOSClass) string {
+	names := qosNames(qos)
+	if names == nil {
+		return ""
+	}
+	return namesPath(names)
+}
+
+// ContainerCgroupPath returns the cgroup path of the container relative to the cgroup root of subsystems
+func ContainerCgroupPath(podPath string, ref typedef.ContainerRef) string {
+	if GetDriver() != DriverSystemd {
+		return filepath.Join(podPath, ref.DirName())
+	}
+	return filepath.Join(podPath, ref.ScopeName())
+}
+
+func qosNames(qos corev1.PodQOSClass) []string {
+	driverLock.RLock()
+	names := append([]string{}, rootNames...)
+	driverLock.RUnlock()
+
+	names = append(names, constant.KubepodsCgroup)
+	switch qos {
+	case corev1.PodQOSGuaranteed:
+		return names
+	case corev1.PodQOSBurstable, corev1.PodQOSBestEffort:
+		return append(names, strings.ToLower(string(qos)))
+	default:
+		return nil
+	}
+}
+
+// namesPath converts cgroup names to the path named by the cgroup driver
+func namesPath(names []string) string {
+	if GetDriver() != DriverSystemd {
+		return filepath.Join(names...)
+	}
+	// kubelet escapes "-" in names as it separates the parent slices
+	var parts []string
+	prefix := ""
+	for _, name := range names {
+		name = strings.Replace(name, "-", "_", -1)
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+		prefix = name
+		parts = append(parts, name+".slice")
+	}
+	return filepath.Join(parts...)
+}
@@ -7,11 +7,11 @@
 // IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
 // PURPOSE.
 // See the Mulan PSL v2 for more details.
-// Author: Jingxiao Lu
-// Create: 2022-05-25
-// Description: tests for pod.go
+// Author: Song Yanting
+// Create: 2022-10-28
+// Description: cgroup driver test
 
-package util
+package cgroup
 
 import (
 	"path/filepath"
@@ -22,79 +22,10 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/typedef"
 )
 
-const (
-	trueStr = "true"
-)
-
-func TestIsOffline(t *testing.T) {
-	var pod = &corev1.Pod{}
-	pod.Annotations = make(map[string]string)
-	pod.Annotations[constant.PriorityAnnotationKey] = trueStr
-	if !IsOffline(pod) {
-		t.Fatalf("%s failed for Annotations is %s", t.Name(), trueStr)
-	}
-
-	delete(pod.Annotations, constant.PriorityAnnotationKey)
-	if IsOffline(pod) {
-		t.Fatalf("%s failed for Annotations no such key", t.Name())
-	}
-}
-
-// TestGetQuotaBurst is testcase for GetQuotaBurst
-func TestGetQuotaBurst(t *testing.T) {
-	pod := &corev1.Pod{}
-	pod.Annotations = make(map[string]string)
-	maxInt64PlusOne := "9223372036854775808"
-	tests := []struct {
-		name       string
-		quotaBurst string
-		want       int64
-	}{
-		{
-			name:       "TC1-valid quota burst",
-			quotaBurst: "1",
-			want:       1,
-		},
-		{
-			name:       "TC2-empty quota burst",
-			quotaBurst: "",
-			want:       -1,
-		},
-		{
-			name:       "TC3-zero quota burst",
-			quotaBurst: "0",
-			want:       0,
-		},
-		{
-			name:       "TC4-negative quota burst",
-			quotaBurst: "-100",
-			want:       -1,
-		},
-		{
-			name:       "TC5-float quota burst",
-			quotaBurst: "100.34",
-			want:       -1,
-		},
-		{
-			name:       "TC6-nonnumerical quota burst",
-			quotaBurst: "nonnumerical",
-			want:       -1,
-		},
-		{
-			name:       "TC7-exceed max int64",
-			quotaBurst: maxInt64PlusOne,
-			want:       -1,
-		},
-	}
-	for _, tt := range tests {
-		pod.Annotations[constant.QuotaBurstAnnotationKey] = tt.quotaBurst
-		assert.Equal(t, GetQuotaBurst(pod), tt.want)
-	}
-}
-
-func TestGetPodCgroupPath(t *testing.T) {
+func TestPodCgroupPath(t *testing.T) {
 	var pod = &corev1.Pod{}
 	pod.UID = "AAA"
 	var guaranteedPath = filepath.Join(constant.KubepodsCgroup, constant.PodCgroupNamePrefix+string(pod.UID))
@@ -104,19 +35,19 @@ func TestGetPodCgroupPath(t *testing.T) {
 
 	// no pod.Annotations[configHashAnnotationKey]
 	pod.Status.QOSClass = corev1.PodQOSGuaranteed
-	if !assert.Equal(t, GetPodCgroupPath(pod), guaranteedPath) {
+	if !assert.Equal(t, PodCgroupPath(pod), guaranteedPath) {
 		t.Fatalf("%s failed for PodQOSGuaranteed without configHash", t.Name())
 	}
 	pod.Status.QOSClass = corev1.PodQOSBurstable
-	if !assert.Equal(t, GetPodCgroupPath(pod), burstablePath) {
+	if !assert.Equal(t, PodCgroupPath(pod), burstablePath) {
 		t.Fatalf("%s failed for PodQOSBurstable without configHash", t.Name())
 	}
 	pod.Status.QOSClass = corev1.PodQOSBestEffort
-	if !assert.Equal(t, GetPodCgroupPath(pod), besteffortPath) {
+	if !assert.Equal(t, PodCgroupPath(pod), besteffortPath) {
 		t.Fatalf("%s failed for PodQOSBestEffort without configHash", t.Name())
 	}
 	pod.Status.QOSClass = ""
-	if !assert.Equal(t, GetPodCgroupPath(pod), "") {
+	if !assert.Equal(t, PodCgroupPath(pod), "") {
 		t.Fatalf("%s failed for not setting QOSClass without configHash", t.Name())
 	}
 
@@ -127,19 +58,45 @@ func TestGetPodCgroupPath(t *testing.T) {
 	burstablePath = filepath.Join(constant.KubepodsCgroup, strings.ToLower(string(corev1.PodQOSBurstable)), constant.PodCgroupNamePrefix+id)
 	besteffortPath = filepath.Join(constant.KubepodsCgroup, strings.ToLower(string(corev1.PodQOSBestEffort)), constant.PodCgroupNamePrefix+id)
 	pod.Status.QOSClass = corev1.PodQOSGuaranteed
-	if !assert.Equal(t, GetPodCgroupPath(pod), guaranteedPath) {
+	if !assert.Equal(t, PodCgroupPath(pod), guaranteedPath) {
 		t.Fatalf("%s failed for PodQOSGuaranteed with configHash", t.Name())
 	}
 	pod.Status.QOSClass = corev1.PodQOSBurstable
-	if !assert.Equal(t, GetPodCgroupPath(pod), burstablePath) {
+	if !assert.Equal(t, PodCgroupPath(pod), burstablePath) {
 		t.Fatalf("%s failed for PodQOSBurstable with configHash", t.Name())
 	}
 	pod.Status.QOSClass = corev1.PodQOSBestEffort
-	if !assert.Equal(t, GetPodCgroupPath(pod), besteffortPath) {
+	if !assert.Equal(t, PodCgroupPath(pod), besteffortPath) {
 		t.Fatalf("%s failed for PodQOSBestEffort with configHash", t.Name())
 	}
 	pod.Status.QOSClass = ""
-	if !assert.Equal(t, GetPodCgroupPath(pod), "") {
+	if !assert.Equal(t, PodCgroupPath(pod), "") {
 		t.Fatalf("%s failed for not setting QOSClass with configHash", t.Name())
 	}
 }
+
+// TestSystemd tests the pod and container paths of systemd cgroup driver
+func TestSystemd(t *testing.T) {
+	assert.Error(t, SetDriver("unknown", ""))
+	assert.NoError(t, SetDriver(DriverSystemd, "/"))
+	defer SetDriver(DriverCgroupfs, "")
+
+	pod := &corev1.Pod{}
+	pod.UID = "1a2b-3c4d"
+	pod.Status.QOSClass = corev1.PodQOSBurstable
+	podPath := "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1a2b_3c4d.slice"
+	assert.Equal(t, podPath, PodCgroupPath(pod))
+	assert.Equal(t, "kubepods.slice", KubepodsCgroupPath(corev1.PodQOSGuaranteed))
+	assert.Equal(t, podPath+"/cri-containerd-abc.scope", ContainerCgroupPath(podPath, typedef.ParseContainerID("containerd://abc")))
+	assert.Equal(t, podPath+"/docker-abc.scope", ContainerCgroupPath(podPath, typedef.ParseContainerID("docker://abc")))
+
+	assert.NoError(t, SetDriver(DriverSystemd, "/custom.slice/custom-node.slice"))
+	pod.Status.QOSClass = corev1.PodQOSGuaranteed
+	assert.Equal(t, "custom.slice/custom-node.slice/custom-node-kubepods.slice/custom-node-kubepods-pod1a2b_3c4d.slice",
+		PodCgroupPath(pod))
+
+	assert.NoError(t, SetDriver(DriverCgroupfs, "/custom"))
+	assert.Equal(t, "custom/kubepods/pod1a2b-3c4d", PodCgroupPath(pod))
+	assert.Equal(t, "custom/kubepods/pod1a2b-3c4d/abc", ContainerCgroupPath("custom/kubepods/pod1a2b-3c4d",
+		typedef.ParseContainerID("containerd://abc")))
+}
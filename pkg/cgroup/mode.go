@@ -0,0 +1,146 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-28
+// Description: cgroup v1 and v2 hierarchy modes
+
+package cgroup
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"isula.org/rubik/pkg/config"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/util"
+)
+
+// Mode is the cgroup hierarchy mode
+type Mode string
+
+const (
+	// ModeV1 means controllers are mounted separately at <root>/<subsys>, hybrid mode included
+	ModeV1 Mode = "v1"
+	// ModeV2 means all controllers share the unified hierarchy mounted at <root>
+	ModeV2 Mode = "v2"
+)
+
+// v2Files maps the cgroup v1 files rubik uses to the cgroup v2 equivalents,
+// the files not listed here have no equivalent on cgroup v2
+var v2Files = map[string]string{
+	"cgroup.procs":                     "cgroup.procs",
+	"cpu.cfs_burst_us":                 "cpu.max.burst",
+	"cpu.idle":                         "cpu.idle",
+	"cpu.weight":                       "cpu.weight",
+	"memory.limit_in_bytes":            "memory.max",
+	"memory.high":                      "memory.high",
+	"memory.usage_in_bytes":            "memory.current",
+	"memory.low":                       "memory.low",
+	"memory.min":                       "memory.min",
+	"memory.reclaim":                   "memory.reclaim",
+	"blkio.throttle.read_bps_device":   "io.max",
+	"blkio.throttle.write_bps_device":  "io.max",
+	"blkio.throttle.read_iops_device":  "io.max",
+	"blkio.throttle.write_iops_device": "io.max",
+}
+
+var (
+	// mode keeps v1 until Init is called, so that the modules work the same as before in tests
+	mode        = ModeV1
+	unsupported = make(map[string]bool)
+	modeLock    sync.RWMutex
+)
+
+// DetectMode tells the hierarchy mode of cgroup mounted at root, only the unified hierarchy
+// has cgroup.controllers at its root
+func DetectMode(root string) Mode {
+	if util.PathExist(filepath.Join(root, "cgroup.controllers")) {
+		return ModeV2
+	}
+	return ModeV1
+}
+
+// Init detects the hierarchy mode of cgroup mounted at the cgroup root and sets the cgroup driver in config
+func Init(cfg *config.Config) error {
+	if err := SetDriver(cfg.CgroupDriver, cfg.KubeletCgroupRoot); err != nil {
+		return err
+	}
+	m := DetectMode(cfg.CgroupRoot)
+	SetMode(m)
+	log.Infof("cgroup %s mounted at %s, cgroup driver %s", m, cfg.CgroupRoot, cfg.CgroupDriver)
+	return nil
+}
+
+// SetMode sets the hierarchy mode and returns the previous one
+func SetMode(m Mode) Mode {
+	modeLock.Lock()
+	defer modeLock.Unlock()
+	old := mode
+	mode = m
+	unsupported = make(map[string]bool)
+	return old
+}
+
+// GetMode returns the hierarchy mode
+func GetMode() Mode {
+	modeLock.RLock()
+	defer modeLock.RUnlock()
+	return mode
+}
+
+// IsV2 tells whether the unified hierarchy is used
+func IsV2() bool {
+	return GetMode() == ModeV2
+}
+
+// Path returns the cgroup directory of subsys with elem joined, subsys is ignored on cgroup v2
+func Path(subsys string, elem ...string) string {
+	if IsV2() {
+		subsys = ""
+	}
+	return filepath.Join(append([]string{Root(), subsys}, elem...)...)
+}
+
+// FileName maps the cgroup v1 file name to the one of the current mode, false is returned and
+// the feature is reported as unsupported if there is no equivalent
+func FileName(name string) (string, bool) {
+	if !IsV2() {
+		return name, true
+	}
+	if v2, ok := v2Files[name]; ok {
+		return v2, true
+	}
+	Unsupported(name)
+	return "", false
+}
+
+// Unsupported reports the cgroup v1 file which can not be mapped to cgroup v2, only once for each file
+func Unsupported(name string) {
+	modeLock.Lock()
+	defer modeLock.Unlock()
+	if unsupported[name] {
+		return
+	}
+	unsupported[name] = true
+	log.Infof("%s has no equivalent on cgroup %s, the feature relying on it is skipped", name, mode)
+}
+
+// ListUnsupported returns the files reported as unsupported
+func ListUnsupported() []string {
+	modeLock.RLock()
+	defer modeLock.RUnlock()
+	names := make([]string, 0, len(unsupported))
+	for name := range unsupported {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -15,22 +15,24 @@
 package checkpoint
 
 import (
-	"path/filepath"
-	"strings"
+	"encoding/json"
 	"sync"
 
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/podpolicy"
 	log "isula.org/rubik/pkg/tinylog"
 	"isula.org/rubik/pkg/typedef"
-	"isula.org/rubik/pkg/util"
 )
 
 // Checkpoint stores the binding between the CPU and pod container.
 type Checkpoint struct {
 	Pods map[string]*typedef.PodInfo `json:"pods,omitempty"`
+	// Modules keeps the states of modules to be resumed after restart
+	Modules map[string]json.RawMessage `json:"modules,omitempty"`
 }
 
 // Manager manage checkpoint
@@ -38,6 +40,8 @@ type Manager struct {
 	Checkpoint *Checkpoint
 	CgroupRoot string
 	sync.Mutex
+	// file is where the checkpoint persists, empty means in memory only
+	file string
 }
 
 // NewManager create manager
@@ -65,6 +69,7 @@ func (cm *Manager) AddPod(pod *corev1.Pod) {
 	}
 	log.Debugf("add pod %v", string(pod.UID))
 	cm.Checkpoint.Pods[string(pod.UID)] = NewPodInfo(pod, cm.CgroupRoot)
+	cm.saveNoLock()
 }
 
 // GetPod returns pod info from pod ID
@@ -103,6 +108,7 @@ func (cm *Manager) DelPod(podID types.UID) {
 	}
 	log.Debugf("delete pod %v", podID)
 	delete(cm.Checkpoint.Pods, string(podID))
+	cm.saveNoLock()
 }
 
 // UpdatePod updates pod information based on pods
@@ -115,20 +121,43 @@ func (cm *Manager) UpdatePod(pod *corev1.Pod) {
 		return
 	}
 	log.Debugf("update pod %v", string(pod.UID))
+	before := old.Clone()
 	updatePodInfoNoLock(old, pod)
+	// the periodic resync of informer changes nothing mostly, which is not saved again
+	if !old.SameSettings(before) {
+		cm.saveNoLock()
+	}
 }
 
-// SyncFromCluster synchronizing data from the kubernetes cluster using the list mechanism at the beginning
-func (cm *Manager) SyncFromCluster(items []corev1.Pod) {
+// SyncFromCluster synchronizing data from the kubernetes cluster using the list mechanism at the beginning,
+// the pods loaded from the previous checkpoint but no longer in the cluster are removed and returned
+func (cm *Manager) SyncFromCluster(items []corev1.Pod) []*typedef.PodInfo {
 	cm.Lock()
 	defer cm.Unlock()
+	listed := make(map[string]struct{}, len(items))
 	for _, pod := range items {
 		if string(pod.UID) == "" {
 			continue
 		}
-		log.Debugf("add pod %v", string(pod.UID))
+		if _, ok := cm.Checkpoint.Pods[string(pod.UID)]; ok {
+			log.Debugf("resume pod %v", string(pod.UID))
+		} else {
+			log.Debugf("add pod %v", string(pod.UID))
+		}
+		listed[string(pod.UID)] = struct{}{}
 		cm.Checkpoint.Pods[string(pod.UID)] = NewPodInfo(&pod, cm.CgroupRoot)
 	}
+
+	var stale []*typedef.PodInfo
+	for id, pi := range cm.Checkpoint.Pods {
+		if _, ok := listed[id]; !ok {
+			log.Debugf("delete stale pod %v", id)
+			stale = append(stale, pi)
+			delete(cm.Checkpoint.Pods, id)
+		}
+	}
+	cm.saveNoLock()
+	return stale
 }
 
 // filter filtering for list functions
@@ -213,7 +242,7 @@ func NewPodInfo(pod *corev1.Pod, cgroupRoot string) *typedef.PodInfo {
 		Name:       pod.Name,
 		UID:        string(pod.UID),
 		Containers: make(map[string]*typedef.ContainerInfo, 0),
-		CgroupPath: util.GetPodCgroupPath(pod),
+		CgroupPath: cgroup.PodCgroupPath(pod),
 		Namespace:  pod.Namespace,
 		CgroupRoot: cgroupRoot,
 	}
@@ -221,32 +250,38 @@ func NewPodInfo(pod *corev1.Pod, cgroupRoot string) *typedef.PodInfo {
 	return pi
 }
 
+// memoryRequest returns the sum of the memory requests of the containers
+func memoryRequest(pod *corev1.Pod) int64 {
+	var sum int64
+	for _, c := range pod.Spec.Containers {
+		sum += c.Resources.Requests.Memory().Value()
+	}
+	return sum
+}
+
 // updatePodInfoNoLock updates PodInfo from the pod of Kubernetes.
 // UpdatePodInfoNoLock does not lock pods during the modification.
 // Therefore, ensure that the pod is being used only by this function.
 // Currently, the checkpoint manager variable is locked when this function is invoked.
 func updatePodInfoNoLock(pi *typedef.PodInfo, pod *corev1.Pod) {
-	const (
-		dockerPrefix     = "docker://"
-		containerdPrefix = "containerd://"
-	)
 	pi.Name = pod.Name
-	pi.Offline = util.IsOffline(pod)
-	pi.CacheLimitLevel = util.GetPodCacheLimit(pod)
-	pi.QuotaBurst = util.GetQuotaBurst(pod)
+	pi.Offline = podpolicy.IsOffline(pod)
+	pi.QosLevel = podpolicy.GetQosLevel(pod, pi.Offline)
+	pi.CacheLimitLevel = podpolicy.GetPodCacheLimit(pod)
+	pi.BlkioLimit = podpolicy.GetPodBlkioLimit(pod)
+	pi.QuotaBurst = podpolicy.GetQuotaBurst(pod)
+	pi.MemoryRequest = memoryRequest(pod)
+	pi.MemoryProtection = podpolicy.GetMemoryProtection(pod)
 
-	nameID := make(map[string]string, len(pod.Status.ContainerStatuses))
+	nameRef := make(map[string]typedef.ContainerRef, len(pod.Status.ContainerStatuses))
 	for _, c := range pod.Status.ContainerStatuses {
-		// Rubik is compatible with dockerd and containerd container engines.
-		cid := strings.TrimPrefix(c.ContainerID, dockerPrefix)
-		cid = strings.TrimPrefix(cid, containerdPrefix)
-
+		ref := typedef.ParseContainerID(c.ContainerID)
 		// the container may be in the creation or deletion phase.
-		if len(cid) == 0 {
+		if len(ref.ID) == 0 {
 			log.Debugf("no container id found of container %v", c.Name)
 			continue
 		}
-		nameID[c.Name] = cid
+		nameRef[c.Name] = ref
 	}
 	// update ContainerInfo in a PodInfo
 	for _, c := range pod.Spec.Containers {
@@ -254,17 +289,19 @@ func updatePodInfoNoLock(pi *typedef.PodInfo, pod *corev1.Pod) {
 		// add a container
 		if !ok {
 			log.Debugf("add new container %v", c.Name)
-			pi.AddContainerInfo(typedef.NewContainerInfo(c, string(pod.UID), nameID[c.Name],
-				pi.CgroupRoot, pi.CgroupPath))
+			ref := nameRef[c.Name]
+			ci = typedef.NewContainerInfo(c, string(pod.UID), ref.ID, pi.CgroupRoot, pi.CgroupPath)
+			ci.CgroupAddr = cgroup.ContainerCgroupPath(pi.CgroupPath, ref)
+			pi.AddContainerInfo(ci)
 			continue
 		}
 		// The container name remains unchanged, and other information about the container is updated.
-		ci.ID = nameID[c.Name]
-		ci.CgroupAddr = filepath.Join(pi.CgroupPath, ci.ID)
+		ci.ID = nameRef[c.Name].ID
+		ci.CgroupAddr = cgroup.ContainerCgroupPath(pi.CgroupPath, nameRef[c.Name])
 	}
 	// delete a container that does not exist
 	for name := range pi.Containers {
-		if _, ok := nameID[name]; !ok {
+		if _, ok := nameRef[name]; !ok {
 			log.Debugf("delete container %v", name)
 			delete(pi.Containers, name)
 		}
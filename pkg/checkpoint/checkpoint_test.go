@@ -22,6 +22,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/constant"
 	"isula.org/rubik/pkg/typedef"
 )
@@ -395,3 +396,52 @@ func TestMangerPodExist(t *testing.T) {
 		})
 	}
 }
+
+// TestNewPodInfoSystemd tests the cgroup paths of pods and containers with systemd cgroup driver
+func TestNewPodInfoSystemd(t *testing.T) {
+	assert.NoError(t, cgroup.SetDriver(cgroup.DriverSystemd, "/"))
+	defer cgroup.SetDriver(cgroup.DriverCgroupfs, "")
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: "1a2b-3c4d", Name: "foo"},
+		Status: corev1.PodStatus{
+			QOSClass: corev1.PodQOSBestEffort,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "foo", ContainerID: "containerd://abc"},
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "foo"}}},
+	}
+	pi := NewPodInfo(pod, constant.DefaultCgroupRoot)
+	podPath := "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1a2b_3c4d.slice"
+	assert.Equal(t, podPath, pi.CgroupPath)
+	assert.Equal(t, podPath+"/cri-containerd-abc.scope", pi.Containers["foo"].CgroupAddr)
+
+	pod.Status.ContainerStatuses[0].ContainerID = "containerd://def"
+	updatePodInfoNoLock(pi, pod)
+	assert.Equal(t, podPath+"/cri-containerd-def.scope", pi.Containers["foo"].CgroupAddr)
+}
+
+// TestNewPodInfoMemory tests the memory requests of the containers are summed up, and the protection
+// annotation is read
+func TestNewPodInfoMemory(t *testing.T) {
+	request := func(q string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{"memory": resource.MustParse(q)}}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: "1a2b-3c4d", Name: "foo",
+			Annotations: map[string]string{constant.MemoryProtectionAnnotationKey: "1Gi"}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "foo", Resources: request("1Gi")},
+			{Name: "bar", Resources: request("512Mi")},
+			{Name: "baz"},
+		}},
+	}
+	pi := NewPodInfo(pod, constant.DefaultCgroupRoot)
+	assert.Equal(t, int64(1536<<20), pi.MemoryRequest)
+	assert.Equal(t, int64(1<<30), pi.MemoryProtection)
+
+	delete(pod.Annotations, constant.MemoryProtectionAnnotationKey)
+	updatePodInfoNoLock(pi, pod)
+	assert.Equal(t, int64(constant.InvalidMemoryProtection), pi.MemoryProtection)
+}
@@ -0,0 +1,196 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-11-02
+// Description: persistence of the checkpoint
+
+package checkpoint
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/constant"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+	"isula.org/rubik/pkg/util"
+)
+
+// checkpointFile is the layout of the file, the checksum guards the data against corruption
+type checkpointFile struct {
+	Data     json.RawMessage `json:"data"`
+	Checksum uint32          `json:"checksum"`
+}
+
+func checksum(data []byte) uint32 {
+	h := fnv.New32a()
+	// the writes to hash never fail
+	_, _ = h.Write(data)
+	return h.Sum32()
+}
+
+// Load restores the checkpoint saved in file by the previous rubik, the checkpoint is saved
+// to file on every change afterwards. A missing file leaves the checkpoint empty, while a
+// corrupted one is reported and dropped
+func (cm *Manager) Load(file string) error {
+	cm.Lock()
+	defer cm.Unlock()
+	cm.file = file
+
+	unlock, err := lockFile(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if !util.PathExist(file) {
+		return nil
+	}
+	b, err := util.ReadSmallFile(filepath.Clean(file))
+	if err != nil {
+		return errors.Errorf("read checkpoint %s failed: %v", file, err)
+	}
+	var cf checkpointFile
+	if err := json.Unmarshal(b, &cf); err != nil {
+		return errors.Errorf("parse checkpoint %s failed: %v", file, err)
+	}
+	if sum := checksum(cf.Data); sum != cf.Checksum {
+		return errors.Errorf("checkpoint %s is corrupted, checksum %d mismatches %d", file, sum, cf.Checksum)
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(cf.Data, cp); err != nil {
+		return errors.Errorf("parse checkpoint %s data failed: %v", file, err)
+	}
+	if cp.Pods == nil {
+		cp.Pods = make(map[string]*typedef.PodInfo, 0)
+	}
+	cm.Checkpoint = cp
+	log.Infof("%d pods loaded from checkpoint %s", len(cp.Pods), file)
+	return nil
+}
+
+// saveNoLock persists the checkpoint if it is loaded from file, the caller must hold the lock
+func (cm *Manager) saveNoLock() {
+	if cm.file == "" {
+		return
+	}
+	if err := cm.writeNoLock(); err != nil {
+		log.Errorf("save checkpoint failed: %v", err)
+	}
+}
+
+func (cm *Manager) writeNoLock() error {
+	data, err := json.Marshal(cm.Checkpoint)
+	if err != nil {
+		return errors.Errorf("marshal checkpoint failed: %v", err)
+	}
+	b, err := json.Marshal(&checkpointFile{Data: data, Checksum: checksum(data)})
+	if err != nil {
+		return errors.Errorf("marshal checkpoint failed: %v", err)
+	}
+
+	unlock, err := lockFile(cm.file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return writeFileAtomic(cm.file, b)
+}
+
+// writeFileAtomic writes b to a temporary file synced to disk and renames it to file,
+// so that file is either the old one or the new one after a crash
+func writeFileAtomic(file string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if util.PathExist(tmpName) {
+			log.DropError(os.Remove(tmpName))
+		}
+	}()
+
+	if _, err := tmp.Write(b); err != nil {
+		log.DropError(tmp.Close())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		log.DropError(tmp.Close())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, constant.DefaultFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, file)
+}
+
+// lockFile takes the exclusive lock on the lock file beside file, blocking until it is released
+// by other processes, such as the rubik cleanup running along
+func lockFile(file string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(file), constant.DefaultDirMode); err != nil {
+		return nil, errors.Errorf("create checkpoint directory failed: %v", err)
+	}
+	path := filepath.Clean(file + ".lock")
+	lock, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, constant.DefaultFileMode)
+	if err != nil {
+		return nil, errors.Errorf("open checkpoint lock %s failed: %v", path, err)
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		log.DropError(lock.Close())
+		return nil, errors.Errorf("lock checkpoint %s failed: %v", path, err)
+	}
+	return func() {
+		log.DropError(syscall.Flock(int(lock.Fd()), syscall.LOCK_UN))
+		log.DropError(lock.Close())
+	}, nil
+}
+
+// SetModuleState records the state of module, which is resumed from the checkpoint after restart
+func (cm *Manager) SetModuleState(module string, state interface{}) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		log.Errorf("marshal state of %s failed: %v", module, err)
+		return
+	}
+	cm.Lock()
+	defer cm.Unlock()
+	if string(cm.Checkpoint.Modules[module]) == string(b) {
+		return
+	}
+	if cm.Checkpoint.Modules == nil {
+		cm.Checkpoint.Modules = make(map[string]json.RawMessage)
+	}
+	cm.Checkpoint.Modules[module] = b
+	cm.saveNoLock()
+}
+
+// ModuleState parses the state of module recorded into state, it returns false if there is none
+func (cm *Manager) ModuleState(module string, state interface{}) bool {
+	cm.Lock()
+	defer cm.Unlock()
+	b, ok := cm.Checkpoint.Modules[module]
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		log.Errorf("parse state of %s failed: %v", module, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-11-02
+// Description: persistence of the checkpoint test
+
+package checkpoint
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/try"
+)
+
+type testState struct {
+	Value int `json:"value"`
+}
+
+// TestSaveLoad tests the checkpoint saved on changes is restored by a new manager
+func TestSaveLoad(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	file := filepath.Join(constant.TmpTestDir, "checkpoint", "checkpoint.json")
+
+	cpm := NewManager(constant.DefaultCgroupRoot)
+	assert.NoError(t, cpm.Load(file))
+	cpm.AddPod(&coreV1Pods[1])
+	cpm.AddPod(&coreV1Pods[2])
+	cpm.DelPod(coreV1Pods[2].UID)
+	cpm.SetModuleState("test", &testState{Value: 1})
+
+	loaded := NewManager(constant.DefaultCgroupRoot)
+	assert.NoError(t, loaded.Load(file))
+	assert.True(t, loaded.PodExist(coreV1Pods[1].UID))
+	assert.False(t, loaded.PodExist(coreV1Pods[2].UID))
+	assert.Equal(t, cpm.GetPod(coreV1Pods[1].UID), loaded.GetPod(coreV1Pods[1].UID))
+	var st testState
+	assert.True(t, loaded.ModuleState("test", &st))
+	assert.Equal(t, 1, st.Value)
+	assert.False(t, loaded.ModuleState("none", &st))
+
+	// no temporary files are left
+	files, err := ioutil.ReadDir(filepath.Dir(file))
+	assert.NoError(t, err)
+	for _, f := range files {
+		assert.False(t, strings.Contains(f.Name(), ".tmp"), f.Name())
+	}
+}
+
+// TestLoadCorrupted tests the checkpoint mismatching the checksum is rejected
+func TestLoadCorrupted(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	file := filepath.Join(constant.TmpTestDir, "checkpoint.json")
+
+	cpm := NewManager("")
+	assert.NoError(t, cpm.Load(file))
+	cpm.AddPod(&coreV1Pods[1])
+	b, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	corrupted := strings.Replace(string(b), coreV1Pods[1].Name, "Corrupted", 1)
+	try.WriteFile(file, []byte(corrupted), constant.DefaultFileMode).OrDie()
+
+	loaded := NewManager("")
+	assert.Error(t, loaded.Load(file))
+	assert.Empty(t, loaded.ListAllPods())
+
+	try.WriteFile(file, []byte("{"), constant.DefaultFileMode).OrDie()
+	assert.Error(t, loaded.Load(file))
+}
+
+// TestSyncFromClusterStale tests the pods loaded but not listed are returned as stale ones
+func TestSyncFromClusterStale(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	file := filepath.Join(constant.TmpTestDir, "checkpoint.json")
+
+	cpm := NewManager("")
+	assert.NoError(t, cpm.Load(file))
+	cpm.AddPod(&coreV1Pods[1])
+	cpm.AddPod(&coreV1Pods[2])
+
+	loaded := NewManager("")
+	assert.NoError(t, loaded.Load(file))
+	stale := loaded.SyncFromCluster(coreV1Pods[2:])
+	assert.Len(t, stale, 1)
+	assert.Equal(t, string(coreV1Pods[1].UID), stale[0].UID)
+	assert.False(t, loaded.PodExist(coreV1Pods[1].UID))
+	assert.Len(t, loaded.ListAllPods(), len(coreV1Pods)-2)
+
+	// the merged checkpoint is saved
+	again := NewManager("")
+	assert.NoError(t, again.Load(file))
+	assert.Equal(t, loaded.ListAllPods(), again.ListAllPods())
+}
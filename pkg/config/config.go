@@ -33,15 +33,59 @@ var (
 
 // Config defines the configuration for rubik
 type Config struct {
-	AutoCheck  bool         `json:"autoCheck,omitempty"`
-	LogDriver  string       `json:"logDriver,omitempty"`
-	LogDir     string       `json:"logDir,omitempty"`
-	LogSize    int          `json:"logSize,omitempty"`
-	LogLevel   string       `json:"logLevel,omitempty"`
-	CgroupRoot string       `json:"cgroupRoot,omitempty"`
-	CacheCfg   CacheConfig  `json:"cacheConfig,omitempty"`
-	BlkioCfg   BlkioConfig  `json:"blkioConfig,omitempty"`
-	MemCfg     MemoryConfig `json:"memoryConfig,omitempty"`
+	AutoCheck         bool            `json:"autoCheck,omitempty"`
+	DryRun            bool            `json:"dryRun,omitempty"`
+	LogDriver         string          `json:"logDriver,omitempty"`
+	LogDir            string          `json:"logDir,omitempty"`
+	LogSize           int             `json:"logSize,omitempty"`
+	LogLevel          string          `json:"logLevel,omitempty"`
+	CgroupRoot        string          `json:"cgroupRoot,omitempty"`
+	CgroupDriver      string          `json:"cgroupDriver,omitempty"`
+	KubeletCgroupRoot string          `json:"kubeletCgroupRoot,omitempty"`
+	WorkerNum         int             `json:"workerNum,omitempty"`
+	MetricsAddr       string          `json:"metricsAddr,omitempty"`
+	ReconcileInterval int             `json:"reconcileInterval"`
+	FullCheckInterval int             `json:"fullCheckInterval"`
+	PodPolicy         PolicyConfig    `json:"podPolicy,omitempty"`
+	PodSource         PodSourceConfig `json:"podSource,omitempty"`
+	CacheCfg          CacheConfig     `json:"cacheConfig,omitempty"`
+	BlkioCfg          BlkioConfig     `json:"blkioConfig,omitempty"`
+	MemCfg            MemoryConfig    `json:"memoryConfig,omitempty"`
+}
+
+// PolicyConfig defines where the offline classification and the feature settings of pods are read from
+type PolicyConfig struct {
+	// OfflineAnnotation and OfflineLabel mark the pod offline if their values are in OfflineValues
+	OfflineAnnotation string   `json:"offlineAnnotation,omitempty"`
+	OfflineLabel      string   `json:"offlineLabel,omitempty"`
+	OfflineValues     []string `json:"offlineValues,omitempty"`
+	// the pods of the priority classes, in the namespaces or matching the label selector are offline
+	OfflinePriorityClasses []string `json:"offlinePriorityClasses,omitempty"`
+	OfflineNamespaces      []string `json:"offlineNamespaces,omitempty"`
+	OfflineSelector        string   `json:"offlineSelector,omitempty"`
+	// the keys of the feature settings, which are looked up in annotations and then labels
+	QosLevelKey   string `json:"qosLevelKey,omitempty"`
+	CacheLimitKey string `json:"cacheLimitKey,omitempty"`
+	QuotaBurstKey string `json:"quotaBurstKey,omitempty"`
+	BlkioKey      string `json:"blkioKey,omitempty"`
+	// MemoryProtectionKey sets the memory protected for the online pod, such as 2Gi
+	MemoryProtectionKey string `json:"memoryProtectionKey,omitempty"`
+}
+
+// PodSourceConfig defines where the pods of the node are got from
+type PodSourceConfig struct {
+	// Sources are used in order of preference, the first available one watches the pods
+	Sources []string `json:"sources,omitempty"`
+	// Kubeconfig is used to access the apiserver out of cluster, the in-cluster config is used if empty
+	Kubeconfig       string `json:"kubeconfig,omitempty"`
+	KubeletURL       string `json:"kubeletURL,omitempty"`
+	KubeletTokenFile string `json:"kubeletTokenFile,omitempty"`
+	// KubeletCAFile verifies the kubelet serving certificate
+	KubeletCAFile string `json:"kubeletCAFile,omitempty"`
+	// KubeletInsecureSkipVerify skips verifying the kubelet serving certificate, such as a self-signed one
+	KubeletInsecureSkipVerify bool `json:"kubeletInsecureSkipVerify,omitempty"`
+	PollInterval              int  `json:"pollInterval,omitempty"`
+	CheckInterval             int  `json:"checkInterval,omitempty"`
 }
 
 // CacheConfig define cache limit related config
@@ -68,13 +112,148 @@ type MultiLvlPercent struct {
 }
 
 type MemoryConfig struct {
-	Enable        bool   `json:"enable,omitempty"`
-	Strategy      string `json:"strategy,omitempty"`
-	CheckInterval int    `json:"checkInterval,omitempty"`
+	Enable        bool             `json:"enable,omitempty"`
+	Strategy      string           `json:"strategy,omitempty"`
+	CheckInterval int              `json:"checkInterval,omitempty"`
+	DynLevel      DynLevelConfig   `json:"dynlevel,omitempty"`
+	Fssr          FssrConfig       `json:"fssr,omitempty"`
+	PSI           PSIConfig        `json:"psi,omitempty"`
+	Protection    ProtectionConfig `json:"protection,omitempty"`
 }
 
-// NewConfig returns new config load from config file
+// ProtectionConfig defines the memory of online pods protected from the reclaim under pressure
+type ProtectionConfig struct {
+	// Mode is none, low for memory.low which protects the best effort, or min for memory.min which
+	// protects the memory unconditionally
+	Mode string `json:"mode,omitempty"`
+	// RequestPercent of the memory requests is protected for the pods without the protection annotation
+	RequestPercent float64 `json:"requestPercent"`
+}
+
+// PSIConfig defines how the pressure stall information of memory is read
+type PSIConfig struct {
+	// Kind is "some" for the stall of any task, or "full" for the stall of all tasks
+	Kind string `json:"kind,omitempty"`
+	// the strategy using psi is woken up once the tasks stall for TriggerStall ms within TriggerWindow ms,
+	// 0 TriggerStall disables the trigger
+	TriggerStall  int `json:"triggerStall"`
+	TriggerWindow int `json:"triggerWindow,omitempty"`
+}
+
+// DynLevelConfig defines the thresholds of the dynlevel strategy, the percentages are of the total memory
+type DynLevelConfig struct {
+	// Signal is the ratio of free or available memory, or the psi stall the pressure is judged by
+	Signal string `json:"signal,omitempty"`
+	// the pressure levels are entered when the free memory falls to the percentages
+	LowPressure      float64 `json:"lowPressure,omitempty"`
+	MidPressure      float64 `json:"midPressure,omitempty"`
+	HighPressure     float64 `json:"highPressure,omitempty"`
+	CriticalPressure float64 `json:"criticalPressure,omitempty"`
+	// RelieveCount is the number of checks the limits are raised in before they are removed
+	RelieveCount int `json:"relieveCount,omitempty"`
+	// ExtraFreePercent of the free memory is added to the usage of offline containers as their limits
+	ExtraFreePercent float64 `json:"extraFreePercent,omitempty"`
+	// the pressure levels are entered when the psi stall rises to the percentages with the psi signal
+	PSILow      float64 `json:"psiLow,omitempty"`
+	PSIMid      float64 `json:"psiMid,omitempty"`
+	PSIHigh     float64 `json:"psiHigh,omitempty"`
+	PSICritical float64 `json:"psiCritical,omitempty"`
+	// ReclaimMode is global to force empty the offline containers and drop the caches of the system, or
+	// cgroup to reclaim the offline pods only by the gap of the free memory to the low pressure level
+	ReclaimMode string `json:"reclaimMode,omitempty"`
+}
+
+// FssrConfig defines the thresholds of the fssr strategy, the percentages are of the total memory
+type FssrConfig struct {
+	// Signal is the ratio of free or available memory, or the psi stall the pressure is judged by
+	Signal string `json:"signal,omitempty"`
+	// ReservePercent is the free memory below which the offline containers are reclaimed
+	ReservePercent float64 `json:"reservePercent,omitempty"`
+	// RelieveRatio times the reserved memory is the free memory above which the limit is relieved
+	RelieveRatio float64 `json:"relieveRatio,omitempty"`
+	// WaterlinePercent is the initial and the max memory.high of offline containers
+	WaterlinePercent float64 `json:"waterlinePercent,omitempty"`
+	ReclaimPercent   float64 `json:"reclaimPercent,omitempty"`
+	RelievePercent   float64 `json:"relievePercent,omitempty"`
+	// PrerelieveInterval is how long the memory keeps rich before the limit is relieved, such as "30m"
+	PrerelieveInterval string `json:"prerelieveInterval,omitempty"`
+	HighAsyncRatio     int    `json:"highAsyncRatio,omitempty"`
+	// the offline containers are reclaimed once the psi stall rises to PSIReclaim, and relieved
+	// once it falls to PSIRelieve with the psi signal
+	PSIReclaim float64 `json:"psiReclaim,omitempty"`
+	PSIRelieve float64 `json:"psiRelieve"`
+}
+
+// DefaultPolicyConfig returns the pod policy of volcano annotations
+func DefaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		OfflineAnnotation: constant.PriorityAnnotationKey,
+		OfflineValues:     []string{"true"},
+		QosLevelKey:       constant.QosLevelAnnotationKey,
+		CacheLimitKey:     constant.CacheLimitAnnotationKey,
+		QuotaBurstKey:     constant.QuotaBurstAnnotationKey,
+		BlkioKey:          constant.BlkioKey,
+
+		MemoryProtectionKey: constant.MemoryProtectionAnnotationKey,
+	}
+}
+
+// DefaultDynLevelConfig returns the default thresholds of the dynlevel strategy
+func DefaultDynLevelConfig() DynLevelConfig {
+	return DynLevelConfig{
+		Signal:           "free",
+		LowPressure:      30,
+		MidPressure:      15,
+		HighPressure:     10,
+		CriticalPressure: 5,
+		RelieveCount:     5,
+		ExtraFreePercent: 2,
+		PSILow:           5,
+		PSIMid:           10,
+		PSIHigh:          20,
+		PSICritical:      40,
+		ReclaimMode:      "global",
+	}
+}
+
+// DefaultFssrConfig returns the default thresholds of the fssr strategy
+func DefaultFssrConfig() FssrConfig {
+	return FssrConfig{
+		Signal:             "free",
+		ReservePercent:     5,
+		RelieveRatio:       3,
+		WaterlinePercent:   80,
+		ReclaimPercent:     10,
+		RelievePercent:     2,
+		PrerelieveInterval: "30m",
+		HighAsyncRatio:     90,
+		PSIReclaim:         10,
+		PSIRelieve:         1,
+	}
+}
+
+// DefaultPSIConfig returns the psi config waking up the strategy when some tasks stall for 200ms in 2s,
+// the window of 2s is also accepted from the unprivileged process
+func DefaultPSIConfig() PSIConfig {
+	return PSIConfig{
+		Kind:          "some",
+		TriggerStall:  200,
+		TriggerWindow: 2000,
+	}
+}
+
+// NewConfig returns new config load from config file, and sets the global cgroup root
 func NewConfig(path string) (*Config, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	CgroupRoot = cfg.CgroupRoot
+	return cfg, nil
+}
+
+// LoadConfig returns new config load from config file without changing the global settings
+func LoadConfig(path string) (*Config, error) {
 	if path == "" {
 		path = constant.ConfigFile
 	}
@@ -82,11 +261,24 @@ func NewConfig(path string) (*Config, error) {
 	defaultLogSize, defaultAdInt, defaultPerfDur := 1024, 1000, 1000
 	defaultLowL3, defaultMidL3, defaultHighL3, defaultLowMB, defaultMidMB, defaultHighMB := 20, 30, 50, 10, 30, 50
 	cfg := Config{
-		LogDriver:  "stdio",
-		LogDir:     constant.DefaultLogDir,
-		LogSize:    defaultLogSize,
-		LogLevel:   "info",
-		CgroupRoot: constant.DefaultCgroupRoot,
+		LogDriver:         "stdio",
+		LogDir:            constant.DefaultLogDir,
+		LogSize:           defaultLogSize,
+		LogLevel:          "info",
+		CgroupRoot:        constant.DefaultCgroupRoot,
+		CgroupDriver:      constant.DefaultCgroupDriver,
+		WorkerNum:         constant.WorkerNum,
+		ReconcileInterval: constant.DefaultReconcileInterval,
+		FullCheckInterval: constant.DefaultFullCheckInterval,
+		PodPolicy:         DefaultPolicyConfig(),
+		PodSource: PodSourceConfig{
+			// the kubelet source is enabled explicitly along with the ca verifying its serving certificate
+			Sources:          []string{"apiserver"},
+			KubeletURL:       constant.DefaultKubeletURL,
+			KubeletTokenFile: constant.DefaultTokenFile,
+			PollInterval:     constant.DefaultPodPollInterval,
+			CheckInterval:    constant.DefaultSourceCheckInterval,
+		},
 		CacheCfg: CacheConfig{
 			Enable:            false,
 			DefaultLimitMode:  "static",
@@ -111,13 +303,16 @@ func NewConfig(path string) (*Config, error) {
 			Enable:        false,
 			Strategy:      constant.DefaultMemStrategy,
 			CheckInterval: constant.DefaultMemCheckInterval,
+			DynLevel:      DefaultDynLevelConfig(),
+			Fssr:          DefaultFssrConfig(),
+			PSI:           DefaultPSIConfig(),
+			Protection: ProtectionConfig{
+				Mode:           "none",
+				RequestPercent: 100,
+			},
 		},
 	}
 
-	defer func() {
-		CgroupRoot = cfg.CgroupRoot
-	}()
-
 	if !util.PathExist(path) {
 		return &cfg, nil
 	}
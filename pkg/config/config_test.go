@@ -18,10 +18,12 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/try"
 )
 
 var (
@@ -70,6 +72,14 @@ func TestNewConfig(t *testing.T) {
 	assert.Equal(t, cfg.LogSize, logSize)
 	assert.Equal(t, cfg.LogLevel, "debug")
 	assert.Equal(t, cfg.CgroupRoot, "/tmp/rubik-test/cgroup")
+	assert.Equal(t, CgroupRoot, "/tmp/rubik-test/cgroup")
+
+	// LoadConfig leaves the global cgroup root unchanged
+	CgroupRoot = constant.DefaultCgroupRoot
+	cfg, err = LoadConfig(tmpConfigFile)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.CgroupRoot, "/tmp/rubik-test/cgroup")
+	assert.Equal(t, CgroupRoot, constant.DefaultCgroupRoot)
 
 	// test_rubik_load_config_file_0002
 	err = ioutil.WriteFile(tmpConfigFile, []byte("abc"), constant.DefaultFileMode)
@@ -103,6 +113,30 @@ func TestConfig_String(t *testing.T) {
     "logSize": 1024,
     "logLevel": "info",
     "cgroupRoot": "/sys/fs/cgroup",
+    "cgroupDriver": "cgroupfs",
+    "workerNum": 4,
+    "reconcileInterval": 60,
+    "fullCheckInterval": 600,
+    "podPolicy": {
+        "offlineAnnotation": "volcano.sh/preemptable",
+        "offlineValues": [
+            "true"
+        ],
+        "qosLevelKey": "rubik.isula.org/qos-level",
+        "cacheLimitKey": "volcano.sh/cache-limit",
+        "quotaBurstKey": "volcano.sh/quota-burst-time",
+        "blkioKey": "volcano.sh/blkio-limit",
+        "memoryProtectionKey": "rubik.isula.org/memory-protection"
+    },
+    "podSource": {
+        "sources": [
+            "apiserver"
+        ],
+        "kubeletURL": "https://127.0.0.1:10250",
+        "kubeletTokenFile": "/var/run/secrets/kubernetes.io/serviceaccount/token",
+        "pollInterval": 10,
+        "checkInterval": 30
+    },
     "cacheConfig": {
         "defaultLimitMode": "static",
         "adjustInterval": 1000,
@@ -121,7 +155,67 @@ func TestConfig_String(t *testing.T) {
     "blkioConfig": {},
     "memoryConfig": {
         "strategy": "none",
-        "checkInterval": 5
+        "checkInterval": 5,
+        "dynlevel": {
+            "signal": "free",
+            "lowPressure": 30,
+            "midPressure": 15,
+            "highPressure": 10,
+            "criticalPressure": 5,
+            "relieveCount": 5,
+            "extraFreePercent": 2,
+            "psiLow": 5,
+            "psiMid": 10,
+            "psiHigh": 20,
+            "psiCritical": 40,
+            "reclaimMode": "global"
+        },
+        "fssr": {
+            "signal": "free",
+            "reservePercent": 5,
+            "relieveRatio": 3,
+            "waterlinePercent": 80,
+            "reclaimPercent": 10,
+            "relievePercent": 2,
+            "prerelieveInterval": "30m",
+            "highAsyncRatio": 90,
+            "psiReclaim": 10,
+            "psiRelieve": 1
+        },
+        "psi": {
+            "kind": "some",
+            "triggerStall": 200,
+            "triggerWindow": 2000
+        },
+        "protection": {
+            "mode": "none",
+            "requestPercent": 100
+        }
     }
 }`)
 }
+
+// TestWatch tests config file change is notified
+func TestWatch(t *testing.T) {
+	dir := filepath.Join(constant.TmpTestDir, "watch")
+	try.MkdirAll(dir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	cfgFile := filepath.Join(dir, "config.json")
+
+	_, err := Watch(filepath.Join(dir, "missing", "config.json"), nil)
+	assert.Error(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	notify, err := Watch(cfgFile, stop)
+	assert.NoError(t, err)
+
+	const timeout = 3 * time.Second
+	try.WriteFile(filepath.Join(dir, "other.json"), []byte("{}"), constant.DefaultFileMode).OrDie()
+	try.WriteFile(cfgFile, []byte("{}"), constant.DefaultFileMode).OrDie()
+	select {
+	case <-notify:
+	case <-time.After(timeout):
+		t.Fatalf("config change not notified")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-24
+// Description: config file watch
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	watchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_DELETE
+	// configMapDataDir is the symlink kubelet swaps when a mounted config map changes
+	configMapDataDir = "..data"
+	inotifyBufSize   = 4096
+)
+
+// Watch watches the directory of the config file with inotify, a notification is sent
+// when the config file changes, notifications not received yet are merged into one
+func Watch(path string, stop <-chan struct{}) (<-chan struct{}, error) {
+	dir, name := filepath.Split(filepath.Clean(path))
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Errorf("inotify init failed: %v", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, watchMask); err != nil {
+		unix.Close(fd)
+		return nil, errors.Errorf("watch %s failed: %v", dir, err)
+	}
+
+	// the non-blocking fd is handled by the runtime poller, so Close unblocks Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	notify := make(chan struct{}, 1)
+	go func() {
+		<-stop
+		log.DropError(f.Close())
+	}()
+	go func() {
+		buf := make([]byte, inotifyBufSize)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				log.Infof("stop watching config file %s: %v", path, err)
+				return
+			}
+			if changed(buf[:n], name) {
+				select {
+				case notify <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return notify, nil
+}
+
+// changed tells whether any inotify event in buf is about the config file
+func changed(buf []byte, name string) bool {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		start := offset + unix.SizeofInotifyEvent
+		end := start + int(event.Len)
+		if end > len(buf) {
+			return false
+		}
+		fname := strings.TrimRight(string(buf[start:end]), "\x00")
+		if fname == name || fname == configMapDataDir {
+			return true
+		}
+		offset = end
+	}
+	return false
+}
@@ -25,6 +25,12 @@ const (
 	RubikSock = "/run/rubik/rubik.sock"
 	// ConfigFile is rubik config file
 	ConfigFile = "/var/lib/rubik/config.json"
+	// DataDir keeps the files written by rubik, apart from the config directory mounted read-only
+	DataDir = "/var/lib/rubik-data"
+	// JournalFile records the original settings overwritten by rubik
+	JournalFile = DataDir + "/journal.json"
+	// CheckpointFile persists the pods and module states of rubik across restarts
+	CheckpointFile = DataDir + "/checkpoint.json"
 	// DefaultLogDir is default log dir
 	DefaultLogDir = "/var/log/rubik"
 	// LockFile is rubik lock file
@@ -53,42 +59,76 @@ const (
 	MaxPodIDLen = 256
 	// MaxPodsPerRequest is max pods number per http request
 	MaxPodsPerRequest = 100
+	// MaxRequestQPS is max http requests number per second
+	MaxRequestQPS = 1000
+	// MaxRequestBodySize is max http request body size
+	MaxRequestBodySize = 1024 * 1024
 	// TmpTestDir is tmp directory for test
 	TmpTestDir = "/tmp/rubik-test"
-	// TaskChanCapacity is capacity for task chan
+	// TaskChanCapacity is the pending jobs number of event queue to warn about
 	TaskChanCapacity = 1024
-	// WorkerNum is number of workers
-	WorkerNum = 1
+	// WorkerNum is default number of event queue workers
+	WorkerNum = 4
+	// DefaultCgroupDriver is the default cgroup driver of kubelet
+	DefaultCgroupDriver = "cgroupfs"
 	// KubepodsCgroup is kubepods root cgroup
 	KubepodsCgroup = "kubepods"
 	// PodCgroupNamePrefix is pod cgroup name prefix
 	PodCgroupNamePrefix = "pod"
 	// NodeNameEnvKey is node name environment variable key
 	NodeNameEnvKey = "RUBIK_NODE_NAME"
-	// PriorityAnnotationKey is annotation key to mark offline pod
+	// PriorityAnnotationKey is the default annotation key to mark offline pod
 	PriorityAnnotationKey = "volcano.sh/preemptable"
-	// CacheLimitAnnotationKey is annotation key to set L3/Mb resctrl group
+	// CacheLimitAnnotationKey is the default annotation key to set L3/Mb resctrl group
 	CacheLimitAnnotationKey = "volcano.sh/cache-limit"
-	// QuotaBurstAnnotationKey is annotation key to set cpu.cfs_burst_ns
+	// QuotaBurstAnnotationKey is the default annotation key to set cpu.cfs_burst_ns
 	QuotaBurstAnnotationKey = "volcano.sh/quota-burst-time"
-	// BlkioKey is annotation key to set blkio limit
+	// QosLevelAnnotationKey is the default annotation key to set qos level
+	QosLevelAnnotationKey = "rubik.isula.org/qos-level"
+	// BlkioKey is the default annotation key to set blkio limit
 	BlkioKey = "volcano.sh/blkio-limit"
+	// MemoryProtectionAnnotationKey is the default annotation key to set the memory protected for online pod
+	MemoryProtectionAnnotationKey = "rubik.isula.org/memory-protection"
 	// DefaultMemCheckInterval indicates the default memory check interval 5s.
 	DefaultMemCheckInterval = 5
 	// DefaultMaxMemCheckInterval indicates the default max memory check interval 30s.
 	DefaultMaxMemCheckInterval = 30
 	// DefaultMemStrategy indicates the default memory strategy.
 	DefaultMemStrategy = "none"
+	// DefaultReconcileInterval indicates the default reconcile interval 60s.
+	DefaultReconcileInterval = 60
+	// MinReconcileInterval indicates the min reconcile interval 10s.
+	MinReconcileInterval = 10
+	// MaxReconcileInterval indicates the max reconcile interval 1 day.
+	MaxReconcileInterval = 86400
+	// DefaultFullCheckInterval indicates the default interval 600s to check unchanged pods in full.
+	DefaultFullCheckInterval = 600
+	// MinFullCheckInterval indicates the min full check interval 60s.
+	MinFullCheckInterval = 60
+	// MaxFullCheckInterval indicates the max full check interval 1 day.
+	MaxFullCheckInterval = 86400
+	// DefaultKubeletURL indicates the default address of the kubelet serving pods.
+	DefaultKubeletURL = "https://127.0.0.1:10250"
+	// DefaultTokenFile indicates the default service account token used to access kubelet.
+	DefaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// DefaultPodPollInterval indicates the default interval 10s to poll pods from kubelet.
+	DefaultPodPollInterval = 10
+	// DefaultSourceCheckInterval indicates the default interval 30s to check the pod sources.
+	DefaultSourceCheckInterval = 30
 )
 
 // LevelType is type definition of qos level
 type LevelType int32
 
 const (
-	// MinLevel is min level for qos level
+	// MinLevel is the qos level of offline pods
 	MinLevel LevelType = -1
-	// MaxLevel is max level for qos level
+	// MaxLevel is the qos level of online pods by default
 	MaxLevel LevelType = 0
+	// LowestLevel and HighestLevel bound the qos levels probed, kernels supporting multiple levels
+	// take the negative ones for offline pods and the positive ones for latency sensitive online pods
+	LowestLevel  LevelType = -2
+	HighestLevel LevelType = 2
 )
 
 // Int is type casting for type LevelType
@@ -110,4 +150,6 @@ var (
 const (
 	// InvalidBurst for invalid quota burst
 	InvalidBurst = -1
+	// InvalidMemoryProtection means the memory protection is not set by annotation
+	InvalidMemoryProtection = -1
 )
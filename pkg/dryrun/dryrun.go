@@ -0,0 +1,91 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-26
+// Description: dry-run mode of system settings
+
+// Package dryrun records the writes rubik intends to do instead of performing them when dry-run is enabled
+package dryrun
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"isula.org/rubik/api"
+	"isula.org/rubik/pkg/cgroup"
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+// maxRecords is the number of latest records kept for listing
+const maxRecords = 1024
+
+var (
+	enabled int32
+	lock    sync.Mutex
+	records []api.DryRunRecord
+)
+
+// Enable turns dry-run mode on or off
+func Enable(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	if atomic.SwapInt32(&enabled, v) != v {
+		log.Infof("dry-run mode is set to %v", on)
+	}
+}
+
+// Enabled tells whether dry-run mode is on
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// WriteFile writes value to path, or records the intended write only in dry-run mode
+func WriteFile(module, path, value, reason string) error {
+	if Enabled() {
+		Record(module, path, value, reason)
+		return nil
+	}
+	return cgroup.WriteFile(path, value)
+}
+
+// Record logs and keeps the intended write with the current value of path
+func Record(module, path, value, reason string) {
+	old := ""
+	if b, err := cgroup.ReadFile(path); err == nil {
+		old = strings.TrimSpace(string(b))
+	}
+	r := api.DryRunRecord{
+		Time:   time.Now().Format(time.RFC3339),
+		Module: module,
+		File:   path,
+		Old:    old,
+		New:    strings.TrimSpace(value),
+		Reason: reason,
+	}
+	log.Infof("dry-run: module=%s file=%s old=%q new=%q reason=%q", r.Module, r.File, r.Old, r.New, r.Reason)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(records) >= maxRecords {
+		records = records[1:]
+	}
+	records = append(records, r)
+}
+
+// List returns the latest records in order
+func List() []api.DryRunRecord {
+	lock.Lock()
+	defer lock.Unlock()
+	return append([]api.DryRunRecord{}, records...)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-26
+// Description: dry-run test
+
+package dryrun
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/try"
+)
+
+// TestWriteFile tests files are not touched in dry-run mode
+func TestWriteFile(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	defer Enable(false)
+	f := filepath.Join(constant.TmpTestDir, "memory.limit_in_bytes")
+	try.WriteFile(f, []byte("100\n"), constant.DefaultFileMode).OrDie()
+
+	Enable(true)
+	assert.True(t, Enabled())
+	assert.NoError(t, WriteFile("memory", f, "50", "reclaim"))
+	b, err := ioutil.ReadFile(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "100\n", string(b))
+
+	recs := List()
+	assert.NotEmpty(t, recs)
+	last := recs[len(recs)-1]
+	assert.Equal(t, "memory", last.Module)
+	assert.Equal(t, f, last.File)
+	assert.Equal(t, "100", last.Old)
+	assert.Equal(t, "50", last.New)
+	assert.Equal(t, "reclaim", last.Reason)
+
+	Enable(false)
+	assert.NoError(t, WriteFile("memory", f, "50", "reclaim"))
+	b, err = ioutil.ReadFile(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "50", string(b))
+	assert.Equal(t, len(recs), len(List()))
+}
+
+// TestMaxRecords tests only the latest records are kept
+func TestMaxRecords(t *testing.T) {
+	for i := 0; i < maxRecords+10; i++ {
+		Record("qos", "/not/exist", "-1", "set qos level")
+	}
+	recs := List()
+	assert.Len(t, recs, maxRecords)
+	assert.Equal(t, "", recs[0].Old)
+}
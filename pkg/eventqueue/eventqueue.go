@@ -0,0 +1,275 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-20
+// Description: asynchronous pod event queue
+
+// Package eventqueue provides a rate-limited queue that handles jobs of pods asynchronously.
+// Jobs of the same pod are handled in the order they are added, a failed job is retried with
+// backoff and blocks the later jobs of its pod only, jobs of other pods are not affected.
+package eventqueue
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/util/workqueue"
+
+	"isula.org/rubik/pkg/constant"
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+	maxRetry       = 5
+	maxWorkerNum   = 64
+)
+
+// Job is a unit of work of a pod
+type Job struct {
+	// Desc describes the job in logs
+	Desc string
+	// Owner is the service the job belongs to, the jobs of an owner are dropped together
+	Owner string
+	// Do does the work, it is retried if error returned
+	Do func() error
+	// dropped tells the job in progress is dropped, which is not retried if it fails
+	dropped bool
+}
+
+// Queue dispatches jobs to workers by pod UID
+type Queue struct {
+	queue workqueue.RateLimitingInterface
+	lock  sync.Mutex
+	jobs  map[string][]*Job
+	// current maps the pods to their jobs in progress
+	current map[string]*Job
+	// backoff holds the pods whose failed jobs wait for the rate-limited retry
+	backoff map[string]bool
+	// idle is broadcast when a job is no longer in progress
+	idle    *sync.Cond
+	depth   int
+	workers int
+	// running counts the workers not exited yet
+	running sync.WaitGroup
+}
+
+// ValidateWorkers checks the workers number
+func ValidateWorkers(workers int) error {
+	if workers <= 0 || workers > maxWorkerNum {
+		return errors.Errorf("worker number %d out of range [1,%d]", workers, maxWorkerNum)
+	}
+	return nil
+}
+
+// New creates a queue with workers number
+func New(workers int) (*Queue, error) {
+	if err := ValidateWorkers(workers); err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		queue: workqueue.NewRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay)),
+		jobs:    make(map[string][]*Job),
+		current: make(map[string]*Job),
+		backoff: make(map[string]bool),
+		workers: workers,
+	}
+	q.idle = sync.NewCond(&q.lock)
+	return q, nil
+}
+
+// Add appends a job of the pod to the queue, which is handled along with the retry if the pod is backing off
+func (q *Queue) Add(podID string, job *Job) {
+	q.lock.Lock()
+	q.jobs[podID] = append(q.jobs[podID], job)
+	q.depth++
+	depth, backoff := q.depth, q.backoff[podID]
+	q.lock.Unlock()
+
+	if depth > constant.TaskChanCapacity {
+		log.Errorf("event queue depth %d exceeds %d, workers may be too slow", depth, constant.TaskChanCapacity)
+	}
+	if !backoff {
+		q.queue.Add(podID)
+	}
+}
+
+// Len returns the number of jobs not finished
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.depth
+}
+
+// Run starts workers, the queue shuts down when stop is closed
+func (q *Queue) Run(stop <-chan struct{}) {
+	for i := 0; i < q.workers; i++ {
+		q.running.Add(1)
+		// the worker returns only when the queue is shut down and drained
+		go func() {
+			defer q.running.Done()
+			q.worker()
+		}()
+	}
+	go func() {
+		<-stop
+		q.queue.ShutDown()
+	}()
+	log.Infof("event queue is running with %d workers", q.workers)
+}
+
+// Wait blocks until all workers exit after the queue is stopped, the pods queued are drained before
+// the workers exit while the retries waiting for backoff are dropped
+func (q *Queue) Wait() {
+	q.running.Wait()
+}
+
+func (q *Queue) worker() {
+	for q.processNextPod() {
+	}
+}
+
+// processNextPod handles jobs of the next pod in order until all done or one fails
+func (q *Queue) processNextPod() bool {
+	item, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(item)
+
+	podID, ok := item.(string)
+	if !ok {
+		q.queue.Forget(item)
+		return true
+	}
+	q.resume(podID)
+
+	for job := q.start(podID); job != nil; job = q.start(podID) {
+		if err := job.Do(); err != nil {
+			if q.isDropped(job) {
+				log.Errorf("pod %s job %s failed: %v, it is dropped already", podID, job.Desc, err)
+			} else if q.queue.NumRequeues(item) < maxRetry {
+				log.Errorf("pod %s job %s failed: %v, retry later", podID, job.Desc, err)
+				q.retry(podID)
+				q.queue.AddRateLimited(item)
+				return true
+			} else {
+				log.Errorf("pod %s job %s failed after %d retries: %v, drop it", podID, job.Desc, maxRetry, err)
+			}
+		}
+		q.queue.Forget(item)
+		q.finish(podID)
+	}
+
+	return true
+}
+
+// start returns the first job of the pod and marks it in progress
+func (q *Queue) start(podID string) *Job {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.jobs[podID]) == 0 {
+		return nil
+	}
+	job := q.jobs[podID][0]
+	q.current[podID] = job
+	return job
+}
+
+// finish marks the job of the pod in progress done and removes it
+func (q *Queue) finish(podID string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.current, podID)
+	q.pop(podID)
+	q.idle.Broadcast()
+}
+
+// retry marks the job of the pod in progress done and the pod backing off until the retry
+func (q *Queue) retry(podID string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.current, podID)
+	q.backoff[podID] = true
+	q.idle.Broadcast()
+}
+
+// resume clears the backoff of the pod when it is handled again
+func (q *Queue) resume(podID string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.backoff, podID)
+}
+
+func (q *Queue) isDropped(job *Job) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return job.dropped
+}
+
+// Drop removes the jobs of the owner not started yet, and waits for the ones in progress to finish,
+// which are not retried if they fail. No job of the owner is done after Drop returns.
+func (q *Queue) Drop(owner string) {
+	if owner == "" {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for podID, jobs := range q.jobs {
+		var kept []*Job
+		for _, job := range jobs {
+			if job.Owner != owner {
+				kept = append(kept, job)
+				continue
+			}
+			if job == q.current[podID] {
+				job.dropped = true
+				kept = append(kept, job)
+				continue
+			}
+			q.depth--
+		}
+		if len(kept) == 0 {
+			delete(q.jobs, podID)
+			continue
+		}
+		q.jobs[podID] = kept
+	}
+	for q.inProgress(owner) {
+		q.idle.Wait()
+	}
+}
+
+// inProgress tells whether any job of the owner is in progress, the caller must hold the lock
+func (q *Queue) inProgress(owner string) bool {
+	for _, job := range q.current {
+		if job.Owner == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// pop removes the first job of the pod, the caller must hold the lock
+func (q *Queue) pop(podID string) {
+	jobs := q.jobs[podID]
+	if len(jobs) == 0 {
+		return
+	}
+	q.depth--
+	if len(jobs) == 1 {
+		delete(q.jobs, podID)
+		return
+	}
+	q.jobs[podID] = jobs[1:]
+}
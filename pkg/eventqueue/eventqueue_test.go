@@ -0,0 +1,270 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-20
+// Description: event queue test
+
+package eventqueue
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const waitTimeout = 5 * time.Second
+
+func waitEmpty(t *testing.T, q *Queue) {
+	deadline := time.Now().Add(waitTimeout)
+	for q.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue is not drained, %d jobs left", q.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestNew tests New
+func TestNew(t *testing.T) {
+	_, err := New(0)
+	assert.Error(t, err)
+	_, err = New(maxWorkerNum + 1)
+	assert.Error(t, err)
+	q, err := New(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, q.Len())
+}
+
+// TestOrderPerPod tests jobs of the same pod are handled in order
+func TestOrderPerPod(t *testing.T) {
+	q, err := New(4)
+	assert.NoError(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	q.Run(stop)
+
+	var lock sync.Mutex
+	got := make(map[string][]int)
+	const jobNum = 50
+	for i := 0; i < jobNum; i++ {
+		for _, pod := range []string{"pod1", "pod2"} {
+			pod, i := pod, i
+			q.Add(pod, &Job{Desc: fmt.Sprint(i), Do: func() error {
+				lock.Lock()
+				got[pod] = append(got[pod], i)
+				lock.Unlock()
+				return nil
+			}})
+		}
+	}
+	waitEmpty(t, q)
+
+	for _, pod := range []string{"pod1", "pod2"} {
+		assert.Len(t, got[pod], jobNum)
+		for i, v := range got[pod] {
+			assert.Equal(t, i, v)
+		}
+	}
+}
+
+// TestRetry tests failed job is retried and blocks its own pod only
+func TestRetry(t *testing.T) {
+	q, err := New(2)
+	assert.NoError(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	q.Run(stop)
+
+	var lock sync.Mutex
+	var order []string
+	record := func(s string) {
+		lock.Lock()
+		order = append(order, s)
+		lock.Unlock()
+	}
+
+	failures := 2
+	q.Add("bad", &Job{Desc: "flaky", Do: func() error {
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("transient error")
+		}
+		record("flaky")
+		return nil
+	}})
+	q.Add("bad", &Job{Desc: "next", Do: func() error {
+		record("next")
+		return nil
+	}})
+	q.Add("good", &Job{Desc: "good", Do: func() error {
+		record("good")
+		return nil
+	}})
+	waitEmpty(t, q)
+
+	assert.Equal(t, []string{"good", "flaky", "next"}, order)
+}
+
+// TestAddWhileBackoff tests the job added while the pod backs off does not retry the failed job early
+func TestAddWhileBackoff(t *testing.T) {
+	q, err := New(1)
+	assert.NoError(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	q.Run(stop)
+
+	var lock sync.Mutex
+	var tries []time.Time
+	failed := make(chan struct{})
+	q.Add("bad", &Job{Desc: "flaky", Do: func() error {
+		lock.Lock()
+		defer lock.Unlock()
+		tries = append(tries, time.Now())
+		if len(tries) == 1 {
+			close(failed)
+			return fmt.Errorf("transient error")
+		}
+		return nil
+	}})
+	<-failed
+	// wait for the retry to be scheduled
+	for {
+		q.lock.Lock()
+		backoff := q.backoff["bad"]
+		q.lock.Unlock()
+		if backoff {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	q.Add("bad", &Job{Desc: "next", Do: func() error { return nil }})
+	waitEmpty(t, q)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, tries, 2)
+	assert.True(t, tries[1].Sub(tries[0]) >= retryBaseDelay)
+}
+
+// TestDrop tests job always failing is dropped after max retries
+func TestDrop(t *testing.T) {
+	q, err := New(1)
+	assert.NoError(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	q.Run(stop)
+
+	count := 0
+	done := false
+	q.Add("pod", &Job{Desc: "fail", Do: func() error {
+		count++
+		return fmt.Errorf("permanent error")
+	}})
+	q.Add("pod", &Job{Desc: "after", Do: func() error {
+		done = true
+		return nil
+	}})
+	waitEmpty(t, q)
+
+	assert.Equal(t, maxRetry+1, count)
+	assert.True(t, done)
+}
+
+// TestWait tests the workers exit after the jobs queued are done when the queue is stopped
+func TestWait(t *testing.T) {
+	q, err := New(2)
+	assert.NoError(t, err)
+	stop := make(chan struct{})
+	q.Run(stop)
+
+	var lock sync.Mutex
+	done := 0
+	for _, pod := range []string{"pod1", "pod2", "pod3"} {
+		q.Add(pod, &Job{Desc: "slow", Do: func() error {
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			done++
+			lock.Unlock()
+			return nil
+		}})
+	}
+	close(stop)
+
+	exited := make(chan struct{})
+	go func() {
+		q.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(waitTimeout):
+		t.Fatal("workers do not exit")
+	}
+	assert.Equal(t, 3, done)
+}
+
+// TestDropOwner tests the jobs of an owner are dropped and the one in progress is waited for
+func TestDropOwner(t *testing.T) {
+	q, err := New(1)
+	assert.NoError(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	q.Run(stop)
+
+	var lock sync.Mutex
+	var order []string
+	record := func(desc string) {
+		lock.Lock()
+		order = append(order, desc)
+		lock.Unlock()
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	q.Add("pod", &Job{Desc: "running", Owner: "svc", Do: func() error {
+		close(started)
+		<-release
+		record("running")
+		return fmt.Errorf("failed after dropped")
+	}})
+	q.Add("pod", &Job{Desc: "pending", Owner: "svc", Do: func() error {
+		record("pending")
+		return nil
+	}})
+	q.Add("pod", &Job{Desc: "other", Owner: "other", Do: func() error {
+		record("other")
+		return nil
+	}})
+	<-started
+
+	dropped := make(chan struct{})
+	go func() {
+		q.Drop("svc")
+		close(dropped)
+	}()
+	select {
+	case <-dropped:
+		t.Fatal("Drop returns before the job in progress finishes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-dropped:
+	case <-time.After(waitTimeout):
+		t.Fatal("Drop does not return")
+	}
+	waitEmpty(t, q)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"running", "other"}, order)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-31
+// Description: kubernetes events posted on pods
+
+// Package events posts kubernetes events on the pods affected by rubik, so that the failures
+// and the throttling actions are visible to the owners of the pods
+package events
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+const (
+	// ReasonQosLevelApplyFailed means the qos level of the pod failed to be set
+	ReasonQosLevelApplyFailed = "QosLevelApplyFailed"
+	// ReasonInvalidQosLevelAnnotation means the qos level annotation of the pod is not an integer of its class
+	ReasonInvalidQosLevelAnnotation = "InvalidQosLevelAnnotation"
+	// ReasonInvalidBlkioAnnotation means the blkio limit annotation of the pod is malformed
+	ReasonInvalidBlkioAnnotation = "InvalidBlkioAnnotation"
+	// ReasonInvalidQuotaBurstAnnotation means the quota burst annotation of the pod is not a non-negative integer
+	ReasonInvalidQuotaBurstAnnotation = "InvalidQuotaBurstAnnotation"
+	// ReasonInvalidMemoryProtectionAnnotation means the memory protection annotation of the pod is not
+	// a non-negative quantity
+	ReasonInvalidMemoryProtectionAnnotation = "InvalidMemoryProtectionAnnotation"
+	// ReasonMemoryProtectionCapped means the memory protection annotation of the pod exceeds its memory limit,
+	// or its requests if it has no limit, and is capped
+	ReasonMemoryProtectionCapped = "MemoryProtectionCapped"
+	// ReasonMemoryLimitedByRubik means the memory of the offline pod is limited to relieve memory pressure
+	ReasonMemoryLimitedByRubik = "MemoryLimitedByRubik"
+
+	component = "rubik"
+	// burstSize and qps limit the events of each pod, events exceeding the limit are dropped
+	burstSize = 10
+	qps       = 1.0 / 60
+)
+
+var (
+	lock        sync.RWMutex
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+)
+
+// Init starts posting events to the cluster through the client, identical events are merged
+// into one with the count increased, and similar events are aggregated by the reason
+func Init(client kubernetes.Interface, nodeName string) {
+	b := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: burstSize,
+		QPS:       qps,
+	})
+	b.StartRecordingToSink(&sink{client: client})
+	r := b.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component, Host: nodeName})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if broadcaster != nil {
+		broadcaster.Shutdown()
+	}
+	broadcaster, recorder = b, r
+	log.Infof("the event recorder is initialized successfully")
+}
+
+// sink posts events through the client of the namespace of each event
+type sink struct {
+	client kubernetes.Interface
+}
+
+// Create creates the event
+func (s *sink) Create(event *corev1.Event) (*corev1.Event, error) {
+	return s.client.CoreV1().Events(event.Namespace).CreateWithEventNamespace(event)
+}
+
+// Update updates the event existing
+func (s *sink) Update(event *corev1.Event) (*corev1.Event, error) {
+	return s.client.CoreV1().Events(event.Namespace).UpdateWithEventNamespace(event)
+}
+
+// Patch patches the event existing with data
+func (s *sink) Patch(event *corev1.Event, data []byte) (*corev1.Event, error) {
+	return s.client.CoreV1().Events(event.Namespace).PatchWithEventNamespace(event, data)
+}
+
+// Shutdown stops posting events, the events not posted yet are dropped
+func Shutdown() {
+	lock.Lock()
+	defer lock.Unlock()
+	if broadcaster != nil {
+		broadcaster.Shutdown()
+	}
+	broadcaster, recorder = nil, nil
+}
+
+// PodRef returns the reference to the pod which events are posted on
+func PodRef(pod *typedef.PodInfo) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        types.UID(pod.UID),
+	}
+}
+
+// Warningf posts a warning event on the object, which does nothing before Init
+func Warningf(obj runtime.Object, reason, messageFmt string, args ...interface{}) {
+	eventf(obj, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
+
+// Normalf posts a normal event on the object, which does nothing before Init
+func Normalf(obj runtime.Object, reason, messageFmt string, args ...interface{}) {
+	eventf(obj, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+func eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if recorder == nil {
+		return
+	}
+	// the events of the pods whose namespace is unknown can not be posted
+	if ref, ok := obj.(*corev1.ObjectReference); ok && ref.Namespace == "" {
+		log.Debugf("skip event %s on %s without namespace", reason, ref.Name)
+		return
+	}
+	recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
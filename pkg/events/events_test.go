@@ -0,0 +1,116 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-31
+// Description: kubernetes events test
+
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"isula.org/rubik/pkg/typedef"
+)
+
+const (
+	waitTimeout  = 5 * time.Second
+	waitInterval = 10 * time.Millisecond
+)
+
+func listEvents(t *testing.T, client *fake.Clientset, ns string) []corev1.Event {
+	list, err := client.CoreV1().Events(ns).List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	return list.Items
+}
+
+// TestNoRecorder tests events are dropped before Init
+func TestNoRecorder(t *testing.T) {
+	Shutdown()
+	pod := &typedef.PodInfo{Name: "pod1", Namespace: "default", UID: "uid1"}
+	Warningf(PodRef(pod), ReasonQosLevelApplyFailed, "set qos failed")
+}
+
+// TestEventf tests events are posted on pods and the identical ones are merged
+func TestEventf(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	Init(client, "node1")
+	defer Shutdown()
+
+	pod := &typedef.PodInfo{Name: "pod1", Namespace: "default", UID: "uid1"}
+	for i := 0; i < 3; i++ {
+		Warningf(PodRef(pod), ReasonQosLevelApplyFailed, "Set qos level failed: %v", "permission denied")
+	}
+	assert.Eventually(t, func() bool {
+		evs := listEvents(t, client, "default")
+		return len(evs) == 1 && evs[0].Count == 3
+	}, waitTimeout, waitInterval)
+
+	ev := listEvents(t, client, "default")[0]
+	assert.Equal(t, corev1.EventTypeWarning, ev.Type)
+	assert.Equal(t, ReasonQosLevelApplyFailed, ev.Reason)
+	assert.Equal(t, "Set qos level failed: permission denied", ev.Message)
+	assert.Equal(t, "Pod", ev.InvolvedObject.Kind)
+	assert.Equal(t, "pod1", ev.InvolvedObject.Name)
+	assert.Equal(t, "uid1", string(ev.InvolvedObject.UID))
+	assert.Equal(t, corev1.EventSource{Component: component, Host: "node1"}, ev.Source)
+
+	// the pod without namespace is skipped
+	Warningf(PodRef(&typedef.PodInfo{Name: "uid3", UID: "uid3"}), ReasonQosLevelApplyFailed, "set qos failed")
+	// events can be posted on the pod object directly
+	k8sPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod2", Namespace: "kube-system", UID: "uid2"}}
+	Normalf(k8sPod, ReasonMemoryLimitedByRubik, "Memory of container %s is limited", "c1")
+	assert.Eventually(t, func() bool {
+		evs := listEvents(t, client, "kube-system")
+		return len(evs) == 1 && evs[0].Type == corev1.EventTypeNormal && evs[0].InvolvedObject.Name == "pod2"
+	}, waitTimeout, waitInterval)
+	for _, ev := range listEvents(t, client, "") {
+		assert.NotEqual(t, "uid3", ev.InvolvedObject.Name)
+	}
+}
+
+// TestRateLimit tests the events of a pod exceeding the burst are dropped
+func TestRateLimit(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	Init(client, "node1")
+	defer Shutdown()
+
+	pod := &typedef.PodInfo{Name: "pod1", Namespace: "default", UID: "uid1"}
+	other := &typedef.PodInfo{Name: "pod2", Namespace: "default", UID: "uid2"}
+	const total = burstSize + 5
+	for i := 0; i < total; i++ {
+		Warningf(PodRef(pod), ReasonInvalidBlkioAnnotation, "Invalid blkio limit annotation %d", i)
+	}
+	Warningf(PodRef(other), ReasonInvalidBlkioAnnotation, "Invalid blkio limit annotation")
+
+	// the events of other pods are not limited
+	assert.Eventually(t, func() bool {
+		for _, ev := range listEvents(t, client, "default") {
+			if ev.InvolvedObject.Name == other.Name {
+				return true
+			}
+		}
+		return false
+	}, waitTimeout, waitInterval)
+
+	var count int32
+	for _, ev := range listEvents(t, client, "default") {
+		if ev.InvolvedObject.Name == pod.Name {
+			count += ev.Count
+		}
+	}
+	assert.True(t, count > 0 && count <= burstSize, "%d events posted", count)
+}
@@ -0,0 +1,267 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2021. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2021-04-17
+// Description: http server serving rubik unix socket
+
+// Package httpserver is for http server of rubik
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+
+	"isula.org/rubik/api"
+	"isula.org/rubik/pkg/cachelimit"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/qos"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+	"isula.org/rubik/pkg/version"
+)
+
+const (
+	setQosFailedMsg = "set qos failed"
+	setQosOKMsg     = "set qos success"
+	tooManyReqMsg   = "too many requests"
+	pingOKMsg       = "ok"
+)
+
+// StatusProvider provides the status of the running daemon
+type StatusProvider interface {
+	Status() *api.StatusResponse
+}
+
+// PodGetter looks up the pods known to the running daemon
+type PodGetter interface {
+	GetPod(podID types.UID) *typedef.PodInfo
+}
+
+// handler serves the requests with the status and the pods of the running daemon
+type handler struct {
+	status StatusProvider
+	// pods is the checkpoint of the pods, the pods set through the socket are named after their IDs if it is nil
+	pods PodGetter
+}
+
+var limiter = rate.NewLimiter(rate.Limit(constant.MaxRequestQPS), constant.MaxRequestQPS)
+
+// NewServer creates the http server and the unix socket listener on path it serves on, the status
+// and the pods are provided by the running daemon
+func NewServer(path string, status StatusProvider, pods PodGetter) (*http.Server, net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), constant.DefaultDirMode); err != nil {
+		return nil, nil, errors.Errorf("create socket dir failed: %v", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, nil, errors.Errorf("remove stale socket failed: %v", err)
+	}
+
+	sock, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, errors.Errorf("listen on %s failed: %v", path, err)
+	}
+	if err := os.Chmod(path, constant.DefaultFileMode); err != nil {
+		log.DropError(sock.Close())
+		return nil, nil, errors.Errorf("chmod %s failed: %v", path, err)
+	}
+
+	server := &http.Server{
+		Handler:      setupHandler(status, pods),
+		ReadTimeout:  constant.ReadTimeout,
+		WriteTimeout: constant.WriteTimeout,
+	}
+
+	return server, sock, nil
+}
+
+// Shutdown stops the http server gracefully and removes the socket file on path
+func Shutdown(server *http.Server, path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), constant.WriteTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Errorf("shutdown http server failed: %v", err)
+	}
+	log.DropError(os.Remove(path))
+}
+
+func setupHandler(status StatusProvider, pods PodGetter) http.Handler {
+	h := &handler{status: status, pods: pods}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.rootHandler)
+	mux.HandleFunc("/ping", pingHandler)
+	mux.HandleFunc("/version", versionHandler)
+	mux.HandleFunc("/status", h.statusHandler)
+	mux.HandleFunc("/dryrun", dryRunHandler)
+
+	return limitHandler(mux)
+}
+
+// limitHandler rejects requests exceeding the qps limit
+func limitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			http.Error(w, tooManyReqMsg, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) rootHandler(w http.ResponseWriter, r *http.Request) {
+	// "/" matches all paths not registered, which should not be served
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), log.CtxKey(log.UUID), uuid.New().String())
+	if r.Method != http.MethodPost {
+		log.WithCtx(ctx).Errorf("method %s not allowed", r.Method)
+		reply(ctx, w, constant.ErrCodeFailed, setQosFailedMsg)
+		return
+	}
+
+	var req api.SetQosRequest
+	r.Body = http.MaxBytesReader(w, r.Body, constant.MaxRequestBodySize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.WithCtx(ctx).Errorf("decode request body failed: %v", err)
+		reply(ctx, w, constant.ErrCodeFailed, setQosFailedMsg)
+		return
+	}
+
+	if err := h.setQos(ctx, &req); err != nil {
+		log.WithCtx(ctx).Errorf("set qos level error: %v", err)
+		reply(ctx, w, constant.ErrCodeFailed, setQosFailedMsg)
+		return
+	}
+	reply(ctx, w, constant.DefaultSucceedCode, setQosOKMsg)
+}
+
+func (h *handler) setQos(ctx context.Context, req *api.SetQosRequest) error {
+	if len(req.Pods) == 0 {
+		return errors.Errorf("no pods in request")
+	}
+	if len(req.Pods) > constant.MaxPodsPerRequest {
+		return errors.Errorf("pods number %d exceeds max limit %d", len(req.Pods), constant.MaxPodsPerRequest)
+	}
+
+	var failed int
+	for podID, podQos := range req.Pods {
+		if err := h.setPodQos(podID, podQos); err != nil {
+			log.WithCtx(ctx).Errorf("set pod %s error: %v", podID, err)
+			failed++
+			continue
+		}
+		log.WithCtx(ctx).Logf("set pod %s qos level %d ok", podID, podQos.QosLevel)
+	}
+	if failed != 0 {
+		return errors.Errorf("%d of %d pods failed", failed, len(req.Pods))
+	}
+
+	return nil
+}
+
+func (h *handler) setPodQos(podID string, podQos api.PodQoS) error {
+	if err := qos.ValidateLevel(podQos.QosLevel); err != nil {
+		return errors.Errorf("Invalid qos level number: %v", err)
+	}
+
+	pi := &typedef.PodInfo{
+		Name:            podID,
+		UID:             podID,
+		CgroupPath:      podQos.CgroupPath,
+		CgroupRoot:      config.CgroupRoot,
+		Offline:         podQos.QosLevel < constant.MaxLevel.Int(),
+		QosLevel:        podQos.QosLevel,
+		CacheLimitLevel: podQos.CacheLimitLevel,
+	}
+	// the events are posted on the pod known in the checkpoint, and skipped for the unknown one
+	if h.pods != nil {
+		if known := h.pods.GetPod(types.UID(podID)); known != nil {
+			pi.Name, pi.Namespace = known.Name, known.Namespace
+		}
+	}
+	if err := qos.SetQosLevel(pi); err != nil {
+		return err
+	}
+
+	if !pi.Offline || !cachelimit.ClEnabled() {
+		return nil
+	}
+	if err := cachelimit.SyncLevel(pi); err != nil {
+		return err
+	}
+	return cachelimit.SetCacheLimit(pi)
+}
+
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	log.DropError(w.Write([]byte(pingOKMsg)))
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	ver := api.VersionResponse{
+		Version:   version.Version,
+		Release:   version.Release,
+		GitCommit: version.GitCommit,
+		BuildTime: version.BuildTime,
+	}
+	writeJSON(r.Context(), w, &ver)
+}
+
+func (h *handler) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if h.status == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(r.Context(), w, h.status.Status())
+}
+
+func dryRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(r.Context(), w, dryrun.List())
+}
+
+func reply(ctx context.Context, w http.ResponseWriter, code int, msg string) {
+	writeJSON(ctx, w, &api.SetQosResponse{ErrCode: code, Message: msg})
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithCtx(ctx).Errorf("encode response failed: %v", err)
+	}
+}
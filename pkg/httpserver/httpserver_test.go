@@ -0,0 +1,206 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2021. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2021-04-17
+// Description: http server testing
+
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+
+	"isula.org/rubik/api"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/try"
+	"isula.org/rubik/pkg/typedef"
+)
+
+func doRequest(method, url string, body []byte) *httptest.ResponseRecorder {
+	return doRequestTo(setupHandler(nil, nil), method, url, body)
+}
+
+func doRequestTo(h http.Handler, method, url string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func decodeResp(t *testing.T, w *httptest.ResponseRecorder) api.SetQosResponse {
+	var resp api.SetQosResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	return resp
+}
+
+// TestPingHandler is testcase for ping handler
+func TestPingHandler(t *testing.T) {
+	w := doRequest(http.MethodGet, "/ping", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, pingOKMsg, w.Body.String())
+
+	w = doRequest(http.MethodPost, "/ping", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+// TestVersionHandler is testcase for version handler
+func TestVersionHandler(t *testing.T) {
+	w := doRequest(http.MethodGet, "/version", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var ver api.VersionResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&ver))
+}
+
+type fakeStatus struct{}
+
+func (f *fakeStatus) Status() *api.StatusResponse {
+	return &api.StatusResponse{Services: []string{"qos"}, Pods: 1}
+}
+
+// TestStatusHandler is testcase for status handler
+func TestStatusHandler(t *testing.T) {
+	w := doRequest(http.MethodGet, "/status", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	h := setupHandler(&fakeStatus{}, nil)
+	w = doRequestTo(h, http.MethodGet, "/status", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var st api.StatusResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&st))
+	assert.Equal(t, []string{"qos"}, st.Services)
+	assert.Equal(t, 1, st.Pods)
+
+	w = doRequest(http.MethodPost, "/status", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+// TestDryRunHandler is testcase for dry-run records listing
+func TestDryRunHandler(t *testing.T) {
+	dryrun.Record("qos", "/not/exist", "-1", "set qos level")
+	w := doRequest(http.MethodGet, "/dryrun", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var recs []api.DryRunRecord
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&recs))
+	assert.NotEmpty(t, recs)
+	assert.Equal(t, "qos", recs[len(recs)-1].Module)
+
+	w = doRequest(http.MethodPost, "/dryrun", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+// TestNotFound is testcase for paths not registered
+func TestNotFound(t *testing.T) {
+	for _, url := range []string{"/not_exist", "/debug/pprof/", "/debug/pprof/profile"} {
+		w := doRequest(http.MethodPost, url, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+}
+
+// TestRootHandlerInvalid is testcase for invalid set qos requests
+func TestRootHandlerInvalid(t *testing.T) {
+	tooMany := api.SetQosRequest{Pods: make(map[string]api.PodQoS)}
+	for i := 0; i <= constant.MaxPodsPerRequest; i++ {
+		tooMany.Pods[fmt.Sprintf("pod%d", i)] = api.PodQoS{CgroupPath: "kubepods/pod", QosLevel: -1}
+	}
+	tooManyData, err := json.Marshal(tooMany)
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		name   string
+		method string
+		body   string
+	}{
+		{name: "TC1-method not allowed", method: http.MethodGet, body: ""},
+		{name: "TC2-invalid json", method: http.MethodPost, body: "invalid"},
+		{name: "TC3-empty pods", method: http.MethodPost, body: `{"Pods": {}}`},
+		{name: "TC4-invalid qos level", method: http.MethodPost,
+			body: `{"Pods": {"podaaa": {"CgroupPath": "kubepods/podaaa", "QosLevel": -999}}}`},
+		{name: "TC5-invalid cgroup path", method: http.MethodPost,
+			body: `{"Pods": {"podaaa": {"CgroupPath": "kubepods", "QosLevel": -1}}}`},
+		{name: "TC6-too many pods", method: http.MethodPost, body: string(tooManyData)},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			w := doRequest(tc.method, "/", []byte(tc.body))
+			assert.Equal(t, http.StatusOK, w.Code)
+			resp := decodeResp(t, w)
+			assert.Equal(t, constant.ErrCodeFailed, resp.ErrCode)
+			assert.Equal(t, setQosFailedMsg, resp.Message)
+		})
+	}
+}
+
+// fakePods records the pods looked up
+type fakePods struct {
+	got []types.UID
+}
+
+func (f *fakePods) GetPod(podID types.UID) *typedef.PodInfo {
+	f.got = append(f.got, podID)
+	return &typedef.PodInfo{Name: "pod1", Namespace: "default", UID: string(podID)}
+}
+
+// TestRootHandler is testcase for setting qos level through root handler
+func TestRootHandler(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	cgRoot, err := ioutil.TempDir(constant.TmpTestDir, "httpserver")
+	assert.NoError(t, err)
+
+	oldRoot := config.CgroupRoot
+	config.CgroupRoot = cgRoot
+	defer func() { config.CgroupRoot = oldRoot }()
+
+	podPath := "kubepods/podaaa"
+	for _, kind := range []string{"cpu", "memory"} {
+		dir := filepath.Join(cgRoot, kind, podPath)
+		try.MkdirAll(dir, constant.DefaultDirMode).OrDie()
+		for _, file := range []string{constant.CPUCgroupFileName, constant.MemoryCgroupFileName} {
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte("0"), constant.DefaultFileMode))
+		}
+	}
+
+	pods := &fakePods{}
+	body := fmt.Sprintf(`{"Pods": {"podaaa": {"CgroupPath": "%s", "QosLevel": -1}}}`, podPath)
+	w := doRequestTo(setupHandler(nil, pods), http.MethodPost, "/", []byte(body))
+	resp := decodeResp(t, w)
+	assert.Equal(t, constant.DefaultSucceedCode, resp.ErrCode)
+	assert.Equal(t, []types.UID{"podaaa"}, pods.got)
+
+	data, err := ioutil.ReadFile(filepath.Join(cgRoot, "cpu", podPath, constant.CPUCgroupFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, "-1", string(data))
+}
+
+// TestNewServer is testcase for NewServer and Shutdown
+func TestNewServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rubik.sock")
+	server, sock, err := NewServer(path, &fakeStatus{}, nil)
+	assert.NoError(t, err)
+	go server.Serve(sock)
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+
+	Shutdown(server, path)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
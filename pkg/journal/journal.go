@@ -0,0 +1,325 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-21
+// Description: journal of the system settings overwritten by rubik
+
+// Package journal records the original values of the files rubik overwrites and the
+// directories rubik creates, so that they can be rolled back when rubik stops.
+package journal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/util"
+)
+
+const (
+	kindFile = "file"
+	kindDir  = "dir"
+	// saveDelay coalesces the saves of the entries recorded in a burst, e.g. when pods are synced
+	saveDelay = time.Second
+)
+
+// Entry is a setting to be restored
+type Entry struct {
+	Module string `json:"module"`
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Path   string `json:"path"`
+	Origin string `json:"origin,omitempty"`
+}
+
+// entryKey identifies an entry, only the first record of a key by a module counts
+type entryKey struct {
+	module string
+	key    string
+}
+
+type journal struct {
+	sync.Mutex
+	// file is where the journal persists, empty means in memory only
+	file    string
+	entries []*Entry
+	index   map[entryKey]*Entry
+	// pending tells a save is scheduled
+	pending bool
+}
+
+var defaultJournal = &journal{index: make(map[entryKey]*Entry)}
+
+// Init loads the journal left by the previous rubik from file, the entries loaded are kept
+// so that the values recorded before are not overwritten by the values rubik wrote itself
+func Init(file string) error {
+	defaultJournal.Lock()
+	defer defaultJournal.Unlock()
+
+	defaultJournal.file = file
+	defaultJournal.entries = nil
+	defaultJournal.pending = false
+	defer defaultJournal.reindex()
+	if !util.PathExist(file) {
+		return nil
+	}
+
+	b, err := util.ReadSmallFile(filepath.Clean(file))
+	if err != nil {
+		return errors.Errorf("read journal %s failed: %v", file, err)
+	}
+	if err := json.Unmarshal(b, &defaultJournal.entries); err != nil {
+		return errors.Errorf("parse journal %s failed: %v", file, err)
+	}
+	log.Infof("%d entries loaded from journal %s", len(defaultJournal.entries), file)
+	return nil
+}
+
+// WriteFile writes value to path for reason, the content of path is recorded before the first write
+func WriteFile(module, path, value, reason string) error {
+	if dryrun.Enabled() {
+		dryrun.Record(module, path, value, reason)
+		return nil
+	}
+	if !defaultJournal.recorded(module, path) {
+		origin, err := cgroup.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		Record(module, path, path, strings.TrimSpace(string(origin)))
+	}
+
+	return cgroup.WriteFile(path, value)
+}
+
+// Record records origin to be written back to path on restore, only the first record of key by module counts
+func Record(module, key, path, origin string) {
+	defaultJournal.add(&Entry{Module: module, Kind: kindFile, Key: key, Path: path, Origin: origin})
+}
+
+// Recorded tells whether key is recorded by module already
+func Recorded(module, key string) bool {
+	return defaultJournal.recorded(module, key)
+}
+
+// Mkdir creates the directory path, which is removed on restore if it does not exist before
+func Mkdir(module, path string) error {
+	if dryrun.Enabled() {
+		if !util.PathExist(path) {
+			dryrun.Record(module, path, "", "create directory")
+		}
+		return nil
+	}
+	if err := cgroup.Mkdir(path); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	defaultJournal.add(&Entry{Module: module, Kind: kindDir, Key: path, Path: path})
+	return nil
+}
+
+// Forget drops the entries of the cgroup dir and its children, dir is relative to the cgroup root of
+// subsystems, used when the cgroups are removed along with pods
+func Forget(dir string) {
+	dir = strings.TrimPrefix(filepath.Clean("/"+dir), "/")
+	if dir == "" {
+		return
+	}
+	defaultJournal.Lock()
+	defer defaultJournal.Unlock()
+
+	kept := defaultJournal.entries[:0]
+	for _, e := range defaultJournal.entries {
+		rel, ok := cgroupPath(e.Path)
+		if !ok || (rel != dir && !strings.HasPrefix(rel, dir+"/")) {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) != len(defaultJournal.entries) {
+		defaultJournal.entries = kept
+		defaultJournal.reindex()
+		defaultJournal.save()
+	}
+}
+
+// Restore rolls back the settings of module in reverse order, all modules if module is empty
+func Restore(module string) error {
+	defaultJournal.Lock()
+	defer defaultJournal.Unlock()
+
+	if dryrun.Enabled() {
+		for i := len(defaultJournal.entries) - 1; i >= 0; i-- {
+			if e := defaultJournal.entries[i]; module == "" || e.Module == module {
+				dryrun.Record(e.Module, e.Path, e.Origin, "restore "+e.Kind)
+			}
+		}
+		return nil
+	}
+
+	var (
+		kept   []*Entry
+		failed int
+	)
+	for i := len(defaultJournal.entries) - 1; i >= 0; i-- {
+		e := defaultJournal.entries[i]
+		if module != "" && e.Module != module {
+			kept = append([]*Entry{e}, kept...)
+			continue
+		}
+		if err := e.restore(); err != nil {
+			// the entry failed is kept so that the origin is restored by the next restore or cleanup
+			log.Errorf("restore %s %s of %s failed: %v", e.Kind, e.Path, e.Module, err)
+			kept = append([]*Entry{e}, kept...)
+			failed++
+		}
+	}
+	defaultJournal.entries = kept
+	defaultJournal.reindex()
+	defaultJournal.save()
+
+	if failed != 0 {
+		return errors.Errorf("%d settings failed to restore", failed)
+	}
+	return nil
+}
+
+// Len returns the number of entries to be restored
+func Len() int {
+	defaultJournal.Lock()
+	defer defaultJournal.Unlock()
+	return len(defaultJournal.entries)
+}
+
+// cgroupPath returns path relative to the cgroup root of its subsystem, false if path is not a cgroup file
+func cgroupPath(path string) (string, bool) {
+	rel, err := filepath.Rel(cgroup.Root(), filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	if cgroup.IsV2() {
+		return rel, true
+	}
+	// the first element is the subsystem on cgroup v1
+	parts := strings.SplitN(rel, "/", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func (e *Entry) restore() error {
+	var err error
+	switch e.Kind {
+	case kindFile:
+		err = cgroup.WriteFile(e.Path, e.Origin)
+	case kindDir:
+		err = cgroup.Remove(e.Path)
+	default:
+		return errors.Errorf("unknown kind %s", e.Kind)
+	}
+	// the cgroup may be removed along with the pod, nothing to restore
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (j *journal) recorded(module, key string) bool {
+	j.Lock()
+	defer j.Unlock()
+	_, ok := j.index[entryKey{module: module, key: key}]
+	return ok
+}
+
+func (j *journal) add(e *Entry) {
+	j.Lock()
+	defer j.Unlock()
+	k := entryKey{module: e.Module, key: e.Key}
+	if _, ok := j.index[k]; ok {
+		return
+	}
+	j.entries = append(j.entries, e)
+	j.index[k] = e
+	j.saveLater()
+}
+
+// reindex rebuilds the index after the entries are replaced, the caller must hold the lock
+func (j *journal) reindex() {
+	j.index = make(map[entryKey]*Entry, len(j.entries))
+	for _, e := range j.entries {
+		k := entryKey{module: e.Module, key: e.Key}
+		if _, ok := j.index[k]; !ok {
+			j.index[k] = e
+		}
+	}
+}
+
+// saveLater schedules a save so that the entries added in a burst are persisted at once,
+// the caller must hold the lock
+func (j *journal) saveLater() {
+	if j.file == "" || j.pending {
+		return
+	}
+	j.pending = true
+	time.AfterFunc(saveDelay, j.flush)
+}
+
+// flush persists the journal if a save is pending
+func (j *journal) flush() {
+	j.Lock()
+	defer j.Unlock()
+	if j.pending {
+		j.save()
+	}
+}
+
+// save persists the journal, the caller must hold the lock
+func (j *journal) save() {
+	j.pending = false
+	if j.file == "" {
+		return
+	}
+	if len(j.entries) == 0 {
+		if err := os.Remove(j.file); err != nil && !os.IsNotExist(err) {
+			log.Errorf("remove journal %s failed: %v", j.file, err)
+		}
+		return
+	}
+
+	b, err := json.Marshal(j.entries)
+	if err != nil {
+		log.Errorf("marshal journal failed: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(j.file), constant.DefaultDirMode); err != nil {
+		log.Errorf("create journal directory failed: %v", err)
+		return
+	}
+	tmp := j.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, constant.DefaultFileMode); err != nil {
+		log.Errorf("write journal %s failed: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, j.file); err != nil {
+		log.Errorf("rename journal %s failed: %v", tmp, err)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-21
+// Description: journal test
+
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/try"
+)
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+// TestWriteFileRestore tests the first origin value is restored
+func TestWriteFileRestore(t *testing.T) {
+	dir := filepath.Join(constant.TmpTestDir, "journal")
+	try.MkdirAll(dir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	assert.NoError(t, Init(""))
+
+	fa, fb := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	assert.NoError(t, ioutil.WriteFile(fa, []byte("100\n"), constant.DefaultFileMode))
+	assert.NoError(t, ioutil.WriteFile(fb, []byte("0"), constant.DefaultFileMode))
+
+	assert.NoError(t, WriteFile("m1", fa, "50", "test"))
+	assert.NoError(t, WriteFile("m1", fa, "20", "test"))
+	assert.NoError(t, WriteFile("m2", fb, "1", "test"))
+	assert.Error(t, WriteFile("m1", filepath.Join(dir, "missing"), "1", "test"))
+	assert.Equal(t, "20", readFile(t, fa))
+	assert.Equal(t, 2, Len())
+
+	assert.NoError(t, Restore("m1"))
+	assert.Equal(t, "100", readFile(t, fa))
+	assert.Equal(t, "1", readFile(t, fb))
+	assert.Equal(t, 1, Len())
+
+	assert.NoError(t, Restore(""))
+	assert.Equal(t, "0", readFile(t, fb))
+	assert.Equal(t, 0, Len())
+}
+
+// busyFake fails the writes while busy is set
+type busyFake struct {
+	*cgroup.Fake
+	busy *bool
+}
+
+func (f busyFake) WriteFile(path string, data []byte) error {
+	if *f.busy {
+		return errors.Errorf("device or resource busy")
+	}
+	return f.Fake.WriteFile(path, data)
+}
+
+// TestRestoreFailed tests the entries failed to restore are kept for the next restore
+func TestRestoreFailed(t *testing.T) {
+	busy := false
+	fake := busyFake{Fake: cgroup.NewFake(), busy: &busy}
+	defer cgroup.SetBackend(cgroup.SetBackend(fake))
+	assert.NoError(t, Init(""))
+
+	const path = "/sys/fs/cgroup/cpu/kubepods/cpu.shares"
+	fake.Set(path, "1024")
+	assert.NoError(t, WriteFile("m1", path, "2", "test"))
+
+	busy = true
+	assert.Error(t, Restore("m1"))
+	assert.Equal(t, 1, Len())
+
+	busy = false
+	assert.NoError(t, Restore(""))
+	assert.Equal(t, 0, Len())
+	v, _ := fake.Get(path)
+	assert.Equal(t, "1024", v)
+}
+
+// TestMkdirForget tests created directories are removed and forgotten entries are not restored
+func TestMkdirForget(t *testing.T) {
+	dir := filepath.Join(constant.TmpTestDir, "journal")
+	try.MkdirAll(dir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	assert.NoError(t, Init(""))
+
+	exist, created := filepath.Join(dir, "exist"), filepath.Join(dir, "created")
+	try.MkdirAll(exist, constant.DefaultDirMode).OrDie()
+	assert.NoError(t, Mkdir("m", exist))
+	assert.NoError(t, Mkdir("m", created))
+	assert.Equal(t, 1, Len())
+
+	oldRoot := config.CgroupRoot
+	config.CgroupRoot = dir
+	defer func() { config.CgroupRoot = oldRoot }()
+	pod := filepath.Join(dir, "memory", "kubepods", "pod1")
+	try.MkdirAll(pod, constant.DefaultDirMode).OrDie()
+	f := filepath.Join(pod, "file")
+	assert.NoError(t, ioutil.WriteFile(f, []byte("1"), constant.DefaultFileMode))
+	assert.NoError(t, WriteFile("m", f, "2", "test"))
+	Forget("pod1")
+	Forget("kubepods/pod")
+	assert.Equal(t, 2, Len())
+	Forget("/kubepods/pod1/")
+	assert.Equal(t, 1, Len())
+
+	assert.NoError(t, Restore(""))
+	assert.True(t, dirExist(exist))
+	assert.False(t, dirExist(created))
+	assert.Equal(t, "2", readFile(t, f))
+}
+
+// TestForget tests only the entries of the cgroup and its children are forgotten
+func TestForget(t *testing.T) {
+	oldRoot, oldMode := config.CgroupRoot, cgroup.SetMode(cgroup.ModeV1)
+	config.CgroupRoot = "/sys/fs/cgroup"
+	defer func() { config.CgroupRoot, _ = oldRoot, cgroup.SetMode(oldMode) }()
+	assert.NoError(t, Init(""))
+
+	Record("m", "a", "/sys/fs/cgroup/cpu/kubepods/podabc/cpu.shares", "1024")
+	Record("m", "b", "/sys/fs/cgroup/memory/kubepods/podabc/con/memory.high", "max")
+	Record("m", "c", "/sys/fs/cgroup/cpu/kubepods/podabc2/cpu.shares", "1024")
+	Record("m", "d", "/proc/sys/kernel/podabc", "0")
+	Forget("kubepods/podabc")
+	assert.Equal(t, 2, Len())
+	assert.True(t, Recorded("m", "c"))
+	assert.True(t, Recorded("m", "d"))
+
+	cgroup.SetMode(cgroup.ModeV2)
+	Record("m", "e", "/sys/fs/cgroup/kubepods/podabc2/cpu.weight", "100")
+	Forget("kubepods/podabc2")
+	assert.Equal(t, 2, Len())
+	assert.False(t, Recorded("m", "e"))
+	assert.NoError(t, Init(""))
+}
+
+// TestPersist tests journal is loaded from file and the origin value is kept
+func TestPersist(t *testing.T) {
+	dir := filepath.Join(constant.TmpTestDir, "journal")
+	try.MkdirAll(dir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	jf := filepath.Join(dir, "journal.json")
+	assert.NoError(t, Init(jf))
+
+	f := filepath.Join(dir, "file")
+	assert.NoError(t, ioutil.WriteFile(f, []byte("origin"), constant.DefaultFileMode))
+	assert.NoError(t, WriteFile("m", f, "v1", "test"))
+	_, err := os.Stat(jf)
+	assert.True(t, os.IsNotExist(err))
+	defaultJournal.flush()
+
+	// rubik restarts without cleanup
+	assert.NoError(t, Init(jf))
+	assert.Equal(t, 1, Len())
+	assert.NoError(t, WriteFile("m", f, "v2", "test"))
+
+	assert.NoError(t, Restore(""))
+	assert.Equal(t, "origin", readFile(t, f))
+	_, err = os.Stat(jf)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, ioutil.WriteFile(jf, []byte("invalid"), constant.DefaultFileMode))
+	assert.Error(t, Init(jf))
+}
+
+func dirExist(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// TestDryRun tests nothing is written or recorded in dry-run mode
+func TestDryRun(t *testing.T) {
+	dir := filepath.Join(constant.TmpTestDir, "journal")
+	try.MkdirAll(dir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	assert.NoError(t, Init(""))
+
+	f := filepath.Join(dir, "file")
+	assert.NoError(t, ioutil.WriteFile(f, []byte("origin"), constant.DefaultFileMode))
+	assert.NoError(t, WriteFile("m", f, "v1", "test"))
+
+	dryrun.Enable(true)
+	defer dryrun.Enable(false)
+	assert.NoError(t, WriteFile("m", f, "v2", "test"))
+	assert.NoError(t, Mkdir("m", filepath.Join(dir, "created")))
+	assert.False(t, dirExist(filepath.Join(dir, "created")))
+	assert.NoError(t, Restore(""))
+	assert.Equal(t, "v1", readFile(t, f))
+	assert.Equal(t, 1, Len())
+
+	dryrun.Enable(false)
+	assert.NoError(t, Restore(""))
+	assert.Equal(t, "origin", readFile(t, f))
+}
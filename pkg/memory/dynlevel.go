@@ -14,13 +14,11 @@
 package memory
 
 import (
-	"io/ioutil"
-	"path/filepath"
-	"time"
+	"sync"
 
-	"k8s.io/apimachinery/pkg/util/wait"
-
-	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/dryrun"
 	log "isula.org/rubik/pkg/tinylog"
 	"isula.org/rubik/pkg/typedef"
 )
@@ -32,20 +30,24 @@ type memoryInfo struct {
 }
 
 type dynLevel struct {
+	// lock protects the thresholds changed by reload from the running checks
+	lock    sync.Mutex
 	m       *MemoryManager
+	cfg     config.DynLevelConfig
 	memInfo memoryInfo
 	st      status
 }
 
-func newDynLevel(m *MemoryManager) (f *dynLevel) {
+func newDynLevel(m *MemoryManager, cfg config.DynLevelConfig) (f *dynLevel) {
 	return &dynLevel{
-		st: newStatus(),
-		m:  m,
+		st:  newStatus(),
+		m:   m,
+		cfg: cfg,
 	}
 }
 
 func (f *dynLevel) Run() {
-	go wait.Until(f.timerProc, time.Duration(f.m.checkInterval)*time.Second, f.m.stop)
+	go f.m.runLoop(f.timerProc)
 }
 
 // UpdateConfig is used to update memory config
@@ -53,21 +55,36 @@ func (f *dynLevel) UpdateConfig(pod *typedef.PodInfo) {
 	// there is no config need to update
 }
 
+// SetConfig applies the new thresholds from the next check
+func (f *dynLevel) SetConfig(memConfig config.MemoryConfig) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.cfg = memConfig.DynLevel
+	log.Infof("dynlevel thresholds are updated to %+v", f.cfg)
+}
+
 func (f *dynLevel) timerProc() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	f.updateStatus()
 	log.Logf("memory manager updates status with memory free: %v, memory total: %v", f.memInfo.free, f.memInfo.total)
 	f.reclaim()
+	f.updateMetrics()
 	log.Logf("memory manager reclaims done and pressure level is %s", &f.st)
 }
 
 func (f *dynLevel) updateStatus() {
-	memInfo, err := getMemoryInfo()
+	s, err := f.m.getSample(f.cfg.Signal)
 	if err != nil {
-		log.Errorf("getMemoryInfo failed with error: %v, it should not happen", err)
+		log.Errorf("get memory pressure failed with error: %v, it should not happen", err)
+		return
+	}
+	f.memInfo = s.mem
+	if f.cfg.Signal == signalPSI {
+		f.st.transition(getLevelInStall(s.stall, f.cfg), f.cfg.RelieveCount)
 		return
 	}
-	f.memInfo = memInfo
-	f.st.transitionStatus(float64(memInfo.free) / float64(memInfo.total))
+	f.st.transitionStatus(s.ratio(f.cfg.Signal), f.cfg)
 }
 
 func (f *dynLevel) limitOfflineContainers(ft fileType) {
@@ -80,15 +97,18 @@ func (f *dynLevel) limitOfflineContainers(ft fileType) {
 }
 
 func (f *dynLevel) limitContainer(c *typedef.ContainerInfo, ft fileType) error {
-	path := c.CgroupPath("memory")
-	limit, err := readMemoryFile(filepath.Join(path, memoryUsageFile))
+	path := cgroup.ContainerPath("memory", c)
+	usageFile, _ := cgroup.FileName(memoryUsageFile)
+	limit, err := cgroup.ReadInt64(path, usageFile)
 	if err != nil {
 		return err
 	}
 
+	reason := "dynlevel reclaim at " + f.st.String()
 	for i := 0; i < maxRetry; i++ {
-		limit += int64(float64(f.memInfo.free) * extraFreePercentage)
-		if err = writeMemoryLimit(path, typedef.FormatInt64(limit), ft); err == nil {
+		limit += int64(float64(f.memInfo.free) * f.cfg.ExtraFreePercent / percent)
+		if err = writeMemoryLimit(path, typedef.FormatInt64(limit), ft, reason); err == nil {
+			f.m.reportLimited(c, limit, reason+" memory pressure")
 			break
 		}
 		log.Errorf("failed to write memory limit from path: %v, will retry now, retry num: %v", path, i)
@@ -101,7 +121,7 @@ func (f *dynLevel) limitContainer(c *typedef.ContainerInfo, ft fileType) error {
 func (f *dynLevel) dropCaches() {
 	var err error
 	for i := 0; i < maxRetry; i++ {
-		if err = ioutil.WriteFile(dropCachesFilePath, []byte("3"), constant.DefaultFileMode); err == nil {
+		if err = dryrun.WriteFile(moduleName, dropCachesFilePath, "3", "dynlevel reclaim at "+f.st.String()); err == nil {
 			log.Logf("drop caches success")
 			return
 		}
@@ -112,7 +132,7 @@ func (f *dynLevel) dropCaches() {
 func (f *dynLevel) forceEmptyOfflineContainers() {
 	containers := f.m.cpm.ListOfflineContainers()
 	for _, c := range containers {
-		if err := writeForceEmpty(c.CgroupPath("memory")); err != nil {
+		if err := writeForceEmpty(cgroup.ContainerPath("memory", c)); err != nil {
 			log.Errorf("force empty for container: %v failed, err: %v", c.ID, err)
 		}
 
@@ -125,13 +145,21 @@ func (f *dynLevel) reclaimInPressure() {
 		// do soft limit
 		f.limitOfflineContainers(msoftLimit)
 	case mid:
+		if f.cfg.ReclaimMode == reclaimCgroup {
+			f.reclaimOfflinePods()
+			return
+		}
 		f.forceEmptyOfflineContainers()
 	case high:
 		// do hard limit
 		f.limitOfflineContainers(mlimit)
 	case critical:
-		// drop caches and do hard limit
-		f.dropCaches()
+		// drop caches, or reclaim the offline pods only, and do hard limit
+		if f.cfg.ReclaimMode == reclaimCgroup {
+			f.reclaimOfflinePods()
+		} else {
+			f.dropCaches()
+		}
 		f.limitOfflineContainers(mlimit)
 	}
 }
@@ -140,7 +168,7 @@ func (f *dynLevel) reclaimInRelieve() {
 	f.st.relieveCnt++
 	containers := f.m.cpm.ListOfflineContainers()
 	for _, c := range containers {
-		recoverContainerMemoryLimit(c, f.st.relieveCnt == relieveMaxCnt)
+		recoverContainerMemoryLimit(c, f.st.relieveCnt >= f.cfg.RelieveCount)
 	}
 }
 
@@ -160,7 +188,7 @@ func (f *dynLevel) reclaim() {
 func writeForceEmpty(cgroupPath string) error {
 	var err error
 	for i := 0; i < maxRetry; i++ {
-		if err = writeMemoryFile(cgroupPath, memoryForceEmptyFile, "0"); err == nil {
+		if err = writeMemoryFile(cgroupPath, memoryForceEmptyFile, "0", "dynlevel force empty"); err == nil {
 			log.Logf("force cgroup memory %v empty success", cgroupPath)
 			return nil
 		}
@@ -174,20 +202,21 @@ func recoverContainerMemoryLimit(c *typedef.ContainerInfo, reachMax bool) {
 	// ratio 0.1 means, newLimit = oldLimit * 1.1
 	const ratio = 0.1
 	var memLimit int64
-	path := c.CgroupPath("memory")
+	path := cgroup.ContainerPath("memory", c)
 	if reachMax {
 		memLimit = maxSysMemLimit
-		if err := writeMemoryLimit(path, typedef.FormatInt64(memLimit), mlimit); err != nil {
+		if err := writeMemoryLimit(path, typedef.FormatInt64(memLimit), mlimit, "dynlevel relieve"); err != nil {
 			log.Errorf("failed to write memory limit from path:%v container:%v", path, c.ID)
 		}
 
-		if err := writeMemoryLimit(path, typedef.FormatInt64(memLimit), msoftLimit); err != nil {
+		if err := writeMemoryLimit(path, typedef.FormatInt64(memLimit), msoftLimit, "dynlevel relieve"); err != nil {
 			log.Errorf("failed to write memory soft limit from path:%v container:%v", path, c.ID)
 		}
 		return
 	}
 
-	memLimit, err := readMemoryFile(filepath.Join(path, memoryLimitFile))
+	limitFile, _ := cgroup.FileName(memoryLimitFile)
+	memLimit, err := cgroup.ReadInt64(path, limitFile)
 	if err != nil {
 		log.Errorf("failed to read from path:%v container:%v", path, c.ID)
 		return
@@ -199,7 +228,7 @@ func recoverContainerMemoryLimit(c *typedef.ContainerInfo, reachMax bool) {
 		return
 	}
 
-	if err := writeMemoryLimit(path, typedef.FormatInt64(memLimit), mlimit); err != nil {
+	if err := writeMemoryLimit(path, typedef.FormatInt64(memLimit), mlimit, "dynlevel relieve"); err != nil {
 		log.Errorf("failed to write memory limit from path:%v container:%v", path, c.ID)
 	}
 }
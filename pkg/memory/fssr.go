@@ -16,31 +16,24 @@
 // 3. When memory is rich over a period of time: freeMemory > 3 * reservedMemory, In this case, 1% of
 // the totalMemory is reserved for offline applications. High=memory.high+totalMemory * 1% until memory
 // free is between reservedMemory and 3 * reservedMemory.
+// The percentages above are the defaults, which are configured in the fssr section of memoryConfig.
 
 // Package memory provide memory reclaim strategy for offline tasks.
 package memory
 
 import (
+	"sync"
 	"time"
 
-	"k8s.io/apimachinery/pkg/util/wait"
-
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
 	log "isula.org/rubik/pkg/tinylog"
 	"isula.org/rubik/pkg/typedef"
 )
 
 type fssrStatus int
 
-const (
-	reservePercentage   = 0.05
-	waterlinePercentage = 0.8
-	relievePercentage   = 0.02
-	reclaimPercentage   = 0.1
-	prerelieveInterval  = "30m"
-	reserveRatio        = 3
-	highAsyncRatio      = 90
-)
-
 const (
 	fssrNormal fssrStatus = iota
 	fssrReclaim
@@ -48,8 +41,27 @@ const (
 	fssrRelieve
 )
 
+func (st fssrStatus) String() string {
+	switch st {
+	case fssrNormal:
+		return "normal"
+	case fssrReclaim:
+		return "reclaim"
+	case fssrPreRelieve:
+		return "prerelieve"
+	case fssrRelieve:
+		return "relieve"
+	default:
+		return "unknown"
+	}
+}
+
 type fssr struct {
+	// lock protects the thresholds changed by reload and the limit from the running checks
+	lock                sync.Mutex
 	mmgr                *MemoryManager
+	cfg                 config.FssrConfig
+	prerelieveInterval  time.Duration
 	preRelieveStartDate time.Time
 	st                  fssrStatus
 	total               int64
@@ -58,13 +70,13 @@ type fssr struct {
 	highAsyncRatio      int64
 }
 
-func newFssr(m *MemoryManager) (f *fssr) {
+func newFssr(m *MemoryManager, cfg config.FssrConfig) (f *fssr) {
 	f = new(fssr)
-	f.init(m)
+	f.init(m, cfg)
 	return f
 }
 
-func (f *fssr) init(m *MemoryManager) {
+func (f *fssr) init(m *MemoryManager, cfg config.FssrConfig) {
 	memInfo, err := getMemoryInfo()
 	if err != nil {
 		log.Infof("initialization of fssr failed")
@@ -73,32 +85,104 @@ func (f *fssr) init(m *MemoryManager) {
 
 	f.mmgr = m
 	f.total = memInfo.total
-	f.reservedMemory = int64(reservePercentage * float64(f.total))
-	f.limit = int64(waterlinePercentage * float64(f.total))
+	f.setThresholds(cfg)
+	f.limit = f.waterline()
+	f.resumeLimit()
 	f.st = fssrNormal
-	f.highAsyncRatio = highAsyncRatio
 	f.initOfflineContainerLimit()
 
 	log.Infof("total: %v, reserved Memory: %v, limit memory: %v", f.total, f.reservedMemory, f.limit)
 }
 
+// fssrCheckpoint is the state of fssr kept in the checkpoint
+type fssrCheckpoint struct {
+	Limit int64 `json:"limit"`
+}
+
+// resumeLimit resumes the limit adjusted before restart, which is ignored if it is out of
+// the range fssr adjusts in, such as the memory of the host is changed
+func (f *fssr) resumeLimit() {
+	if f.mmgr.cpm == nil {
+		return
+	}
+	var st fssrCheckpoint
+	if !f.mmgr.cpm.ModuleState(moduleName, &st) {
+		return
+	}
+	if st.Limit < f.reservedMemory || st.Limit > f.waterline() {
+		log.Infof("ignore fssr limit %v out of range [%v, %v]", st.Limit, f.reservedMemory, f.limit)
+		return
+	}
+	log.Infof("resume fssr limit %v", st.Limit)
+	f.limit = st.Limit
+}
+
+func (f *fssr) saveLimit() {
+	if f.mmgr.cpm != nil {
+		f.mmgr.cpm.SetModuleState(moduleName, &fssrCheckpoint{Limit: f.limit})
+	}
+}
+
 func (f *fssr) Run() {
-	go wait.Until(f.timerProc, time.Duration(f.mmgr.checkInterval)*time.Second, f.mmgr.stop)
+	go f.mmgr.runLoop(f.timerProc)
+}
+
+// setThresholds applies the config validated
+func (f *fssr) setThresholds(cfg config.FssrConfig) {
+	f.cfg = cfg
+	// the interval is validated to be parsed
+	f.prerelieveInterval, _ = time.ParseDuration(cfg.PrerelieveInterval)
+	f.reservedMemory = f.percentOfTotal(cfg.ReservePercent)
+	f.highAsyncRatio = int64(cfg.HighAsyncRatio)
+}
+
+func (f *fssr) percentOfTotal(pct float64) int64 {
+	return int64(pct / percent * float64(f.total))
+}
+
+// waterline is the initial and the max limit of offline containers
+func (f *fssr) waterline() int64 {
+	return f.percentOfTotal(f.cfg.WaterlinePercent)
 }
 
 // UpdateConfig is used to update memory config
 func (f *fssr) UpdateConfig(pod *typedef.PodInfo) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	for _, c := range pod.Containers {
 		f.initContainerMemoryLimit(c)
 	}
 }
 
+// SetConfig applies the new thresholds, the current limit is kept within the new range
+func (f *fssr) SetConfig(memConfig config.MemoryConfig) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.mmgr == nil {
+		return
+	}
+	f.setThresholds(memConfig.Fssr)
+	if f.limit > f.waterline() {
+		f.limit = f.waterline()
+	}
+	if f.limit < f.reservedMemory {
+		f.limit = f.reservedMemory
+	}
+	f.initOfflineContainerLimit()
+	f.saveLimit()
+	log.Infof("fssr thresholds are updated to %+v, limit memory: %v", f.cfg, f.limit)
+}
+
 func (f *fssr) timerProc() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	f.updateStatus()
 	if f.needAdjust() {
 		newLimit := f.calculateNewLimit()
 		f.adjustOfflineContainerMemory(newLimit)
+		f.saveLimit()
 	}
+	f.updateMetrics()
 }
 
 func (f *fssr) initOfflineContainerLimit() {
@@ -121,24 +205,23 @@ func (f *fssr) needAdjust() bool {
 }
 
 func (f *fssr) updateStatus() {
-	curMemInfo, err := getMemoryInfo()
+	s, err := f.mmgr.getSample(f.cfg.Signal)
 	if err != nil {
-		log.Errorf("get memory info failed, err:%v", err)
+		log.Errorf("get memory pressure failed, err:%v", err)
 		return
 	}
 	oldStatus := f.st
 
-	// Use free instead of Available
-	if curMemInfo.free < f.reservedMemory {
+	tight, rich := f.judge(s)
+	if tight {
 		f.st = fssrReclaim
-	} else if curMemInfo.free > reserveRatio*f.reservedMemory {
+	} else if rich {
 		switch f.st {
 		case fssrNormal:
 			f.st = fssrPreRelieve
 			f.preRelieveStartDate = time.Now()
 		case fssrPreRelieve, fssrRelieve:
-			t, _ := time.ParseDuration(prerelieveInterval)
-			if f.preRelieveStartDate.Add(t).Before(time.Now()) {
+			if f.preRelieveStartDate.Add(f.prerelieveInterval).Before(time.Now()) {
 				f.st = fssrRelieve
 			}
 		case fssrReclaim:
@@ -148,37 +231,51 @@ func (f *fssr) updateStatus() {
 		}
 	}
 
-	log.Infof("update change status from %v to %v, cur available %v, cur free %v",
-		oldStatus, f.st, curMemInfo.available, curMemInfo.free)
+	log.Infof("update change status from %v to %v, cur available %v, cur free %v, cur stall %v",
+		oldStatus, f.st, s.mem.available, s.mem.free, s.stall)
+}
+
+// judge tells whether the memory is tight enough to reclaim, or rich enough to relieve by the signal,
+// free memory is used by default as the page cache is counted in the available memory
+func (f *fssr) judge(s sample) (bool, bool) {
+	if f.cfg.Signal == signalPSI {
+		return s.stall >= f.cfg.PSIReclaim, s.stall <= f.cfg.PSIRelieve
+	}
+	amount := s.amount(f.cfg.Signal)
+	return amount < f.reservedMemory, float64(amount) > f.cfg.RelieveRatio*float64(f.reservedMemory)
 }
 
 func (f *fssr) calculateNewLimit() int64 {
 	newLimit := f.limit
 	if f.st == fssrReclaim {
-		newLimit = f.limit - int64(reclaimPercentage*float64(f.total))
+		newLimit = f.limit - f.percentOfTotal(f.cfg.ReclaimPercent)
 		if newLimit < 0 || newLimit <= f.reservedMemory {
 			newLimit = f.reservedMemory
 			log.Infof("reclaim offline containers current limit %v is too small, set as reserved memory %v", newLimit, f.reservedMemory)
 		}
 	} else if f.st == fssrRelieve {
-		newLimit = f.limit + int64(relievePercentage*float64(f.total))
-		if newLimit > int64(waterlinePercentage*float64(f.total)) {
-			newLimit = int64(waterlinePercentage * float64(f.total))
-			log.Infof("relieve offline containers limit soft memory exceeds waterline, set limit as waterline %v", waterlinePercentage*float64(f.total))
+		newLimit = f.limit + f.percentOfTotal(f.cfg.RelievePercent)
+		if newLimit > f.waterline() {
+			newLimit = f.waterline()
+			log.Infof("relieve offline containers limit soft memory exceeds waterline, set limit as waterline %v", newLimit)
 		}
 	}
 	return newLimit
 }
 
 func (f *fssr) initContainerMemoryLimit(c *typedef.ContainerInfo) {
-	path := c.CgroupPath("memory")
-	if err := writeMemoryLimit(path, typedef.FormatInt64(f.limit), mhigh); err != nil {
+	path := cgroup.ContainerPath("memory", c)
+	if err := writeMemoryLimit(path, typedef.FormatInt64(f.limit), mhigh, "fssr init"); err != nil {
 		log.Errorf("failed to initialize the limit soft memory of offline container %v: %v", c.ID, err)
 	} else {
 		log.Infof("initialize the limit soft memory of the offline container %v to %v successfully", c.ID, f.limit)
 	}
 
-	if err := writeMemoryLimit(path, typedef.FormatInt64(f.highAsyncRatio), mhighAsyncRatio); err != nil {
+	// the memory of offline containers is reclaimed synchronously without memory.high_async_ratio
+	if !capability.Supported(capability.MemoryHighAsyncRatio) {
+		return
+	}
+	if err := writeMemoryLimit(path, typedef.FormatInt64(f.highAsyncRatio), mhighAsyncRatio, "fssr init"); err != nil {
 		log.Errorf("failed to initialize the async high ration of offline container %v: %v", c.ID, err)
 	} else {
 		log.Infof("initialize the async high ration of the offline container %v:%v success", c.ID, f.highAsyncRatio)
@@ -193,11 +290,14 @@ func (f *fssr) adjustOfflineContainerMemory(limit int64) {
 
 	containers := f.mmgr.cpm.ListOfflineContainers()
 	for _, c := range containers {
-		path := c.CgroupPath("memory")
-		if err := writeMemoryLimit(path, typedef.FormatInt64(limit), mhigh); err != nil {
+		path := cgroup.ContainerPath("memory", c)
+		if err := writeMemoryLimit(path, typedef.FormatInt64(limit), mhigh, "fssr adjust"); err != nil {
 			log.Errorf("relieve offline containers limit soft memory %v failed, err is %v", c.ID, err)
 		} else {
 			f.limit = limit
+			if f.st == fssrReclaim {
+				f.mmgr.reportLimited(c, limit, "fssr reclaim")
+			}
 		}
 	}
 }
@@ -17,18 +17,20 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
+	"time"
 
-	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
 
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/checkpoint"
 	"isula.org/rubik/pkg/config"
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/events"
+	"isula.org/rubik/pkg/journal"
 	log "isula.org/rubik/pkg/tinylog"
 	"isula.org/rubik/pkg/typedef"
 )
@@ -41,6 +43,7 @@ const (
 )
 
 const (
+	moduleName               = "memory"
 	dropCachesFilePath       = "/proc/sys/vm/drop_caches"
 	memoryLimitFile          = "memory.limit_in_bytes"
 	memorySoftLimitFile      = "memory.soft_limit_in_bytes"
@@ -49,10 +52,8 @@ const (
 	memoryUsageFile          = "memory.usage_in_bytes"
 	memoryForceEmptyFile     = "memory.force_empty"
 	// maxSysMemLimit 9223372036854771712 is the default cgroup memory limit value
-	maxSysMemLimit      = 9223372036854771712
-	maxRetry            = 3
-	relieveMaxCnt       = 5
-	extraFreePercentage = 0.02
+	maxSysMemLimit = 9223372036854771712
+	maxRetry       = 3
 )
 
 type fileType int
@@ -60,6 +61,8 @@ type fileType int
 type memDriver interface {
 	Run()
 	UpdateConfig(pod *typedef.PodInfo)
+	// SetConfig applies the new thresholds of the strategy while it is running
+	SetConfig(memConfig config.MemoryConfig)
 }
 
 // MemoryManager manages memory reclaim works.
@@ -68,25 +71,64 @@ type MemoryManager struct {
 	md            memDriver
 	checkInterval int
 	stop          chan struct{}
+	// signal is the signal of the strategy, the psi trigger is registered with psi
+	signal string
+	psi    config.PSIConfig
+	// wake receives when the psi trigger fires, nil if there is no trigger
+	wake chan struct{}
+}
+
+// ValidateConfig checks memory config
+func ValidateConfig(memConfig config.MemoryConfig) error {
+	if err := validateInterval(memConfig.CheckInterval); err != nil {
+		return err
+	}
+	if err := validateDynLevel(memConfig.DynLevel); err != nil {
+		return errors.Errorf("invalid dynlevel config: %v", err)
+	}
+	if err := validateFssr(memConfig.Fssr); err != nil {
+		return errors.Errorf("invalid fssr config: %v", err)
+	}
+	signal := strategySignal(memConfig)
+	if err := validateSignal(signal); err != nil {
+		return errors.Errorf("invalid %s config: %v", memConfig.Strategy, err)
+	}
+	if signal == signalPSI {
+		if err := validatePSI(memConfig.PSI); err != nil {
+			return errors.Errorf("invalid psi config: %v", err)
+		}
+	}
+	switch memConfig.Strategy {
+	case "fssr":
+		return checkFssrSupported()
+	case "dynlevel":
+		return checkReclaimSupported(memConfig.DynLevel.ReclaimMode)
+	case "none":
+		return nil
+	default:
+		return errors.Errorf("unsupported memStrategy, expect dynlevel|fssr|none")
+	}
 }
 
 // NewMemoryManager creates a new memory manager
 func NewMemoryManager(cpm *checkpoint.Manager, memConfig config.MemoryConfig) (*MemoryManager, error) {
 	interval := memConfig.CheckInterval
-	if err := validateInterval(interval); err != nil {
+	if err := ValidateConfig(memConfig); err != nil {
 		return nil, err
 	}
 	log.Logf("new memory manager with interval:%d", interval)
 	mm := MemoryManager{
 		cpm:           cpm,
 		checkInterval: interval,
-		stop:          config.ShutdownChan,
+		stop:          make(chan struct{}),
+		signal:        strategySignal(memConfig),
+		psi:           memConfig.PSI,
 	}
 	switch memConfig.Strategy {
 	case "fssr":
-		mm.md = newFssr(&mm)
+		mm.md = newFssr(&mm, memConfig.Fssr)
 	case "dynlevel":
-		mm.md = newDynLevel(&mm)
+		mm.md = newDynLevel(&mm, memConfig.DynLevel)
 	case "none":
 		log.Infof("strategy is set to none")
 		return nil, nil
@@ -96,6 +138,26 @@ func NewMemoryManager(cpm *checkpoint.Manager, memConfig config.MemoryConfig) (*
 	return &mm, nil
 }
 
+// strategySignal returns the signal of the strategy, empty with strategy none
+func strategySignal(memConfig config.MemoryConfig) string {
+	switch memConfig.Strategy {
+	case "fssr":
+		return memConfig.Fssr.Signal
+	case "dynlevel":
+		return memConfig.DynLevel.Signal
+	default:
+		return ""
+	}
+}
+
+// checkFssrSupported checks memory.high which fssr limits offline containers with
+func checkFssrSupported() error {
+	if !capability.Supported(capability.MemoryHigh) {
+		return errors.Errorf("memStrategy fssr relies on memory.high, which is not supported by the kernel")
+	}
+	return nil
+}
+
 func validateInterval(interval int) error {
 	if interval > 0 && interval <= constant.DefaultMaxMemCheckInterval {
 		return nil
@@ -105,15 +167,72 @@ func validateInterval(interval int) error {
 
 // Run wait every interval and execute run
 func (m *MemoryManager) Run() {
+	m.startTrigger()
 	m.md.Run()
 }
 
+// startTrigger registers the psi trigger with the psi signal, the strategy still checks every interval
+// if it fails
+func (m *MemoryManager) startTrigger() {
+	if m.signal != signalPSI || m.psi.TriggerStall == 0 {
+		return
+	}
+	wake, file := make(chan struct{}, 1), m.triggerFile()
+	if err := startTrigger(file, m.psi.Kind, m.psi.TriggerStall, m.psi.TriggerWindow, wake, m.stop); err != nil {
+		log.Errorf("start psi trigger failed, check every %ds only: %v", m.checkInterval, err)
+		return
+	}
+	m.wake = wake
+	log.Infof("psi trigger is registered on %s: %s %dms in %dms", file, m.psi.Kind, m.psi.TriggerStall,
+		m.psi.TriggerWindow)
+}
+
+// runLoop calls check every check interval until the manager is stopped, and right away when the
+// psi trigger fires
+func (m *MemoryManager) runLoop(check func()) {
+	ticker := time.NewTicker(time.Duration(m.checkInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		check()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+	}
+}
+
+// Stop stops the memory reclaim works
+func (m *MemoryManager) Stop() {
+	close(m.stop)
+	resetMetrics()
+}
+
 // UpdateConfig is used to update memory config
 func (m *MemoryManager) UpdateConfig(pod *typedef.PodInfo) {
 	m.md.UpdateConfig(pod)
 }
 
-func writeMemoryLimit(cgroupPath string, value string, ft fileType) error {
+// SetConfig applies the new thresholds of the running strategy
+func (m *MemoryManager) SetConfig(memConfig config.MemoryConfig) {
+	m.md.SetConfig(memConfig)
+}
+
+// reportLimited posts the event on the pod of the offline container whose memory is limited
+func (m *MemoryManager) reportLimited(c *typedef.ContainerInfo, limit int64, reason string) {
+	if dryrun.Enabled() || m.cpm == nil {
+		return
+	}
+	pod := m.cpm.GetPod(types.UID(c.PodID))
+	if pod == nil {
+		return
+	}
+	events.Normalf(events.PodRef(pod), events.ReasonMemoryLimitedByRubik,
+		"Memory of container %s is limited to %d bytes by %s", c.Name, limit, reason)
+}
+
+func writeMemoryLimit(cgroupPath string, value string, ft fileType, reason string) error {
 	var filename string
 	switch ft {
 	case mlimit:
@@ -127,34 +246,34 @@ func writeMemoryLimit(cgroupPath string, value string, ft fileType) error {
 	default:
 		return errors.Errorf("unsupported file type %v", ft)
 	}
+	// the file without equivalent in the current cgroup mode is skipped
+	filename, ok := cgroup.FileName(filename)
+	if !ok {
+		return nil
+	}
 
-	if err := writeMemoryFile(cgroupPath, filename, value); err != nil {
+	cgFilePath, err := cgroup.Join(cgroupPath, filename)
+	if err != nil {
+		return err
+	}
+	if err := journal.WriteFile(moduleName, cgFilePath, value, reason); err != nil {
 		return errors.Errorf("set memory file:%s/%s=%s failed, err:%v", cgroupPath, filename, value, err)
 	}
 
 	return nil
 }
 
-func writeMemoryFile(cgroupPath, filename, value string) error {
-	cgFilePath, err := securejoin.SecureJoin(cgroupPath, filename)
-	if err != nil {
-		return errors.Errorf("join path failed for %s and %s: %v", cgroupPath, filename, err)
+func writeMemoryFile(cgroupPath, filename, value, reason string) error {
+	filename, ok := cgroup.FileName(filename)
+	if !ok {
+		return nil
 	}
-
-	return ioutil.WriteFile(cgFilePath, []byte(value), constant.DefaultFileMode)
-}
-
-func readMemoryFile(path string) (int64, error) {
-	const (
-		base, width = 10, 64
-	)
-	content, err := ioutil.ReadFile(filepath.Clean(path))
+	cgFilePath, err := cgroup.Join(cgroupPath, filename)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	memBytes := strings.Split(string(content), "\n")[0]
-	return strconv.ParseInt(memBytes, base, width)
+	return dryrun.WriteFile(moduleName, cgFilePath, value, reason)
 }
 
 // getMemoryInfo returns memory info
@@ -0,0 +1,69 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-30
+// Description: memory metrics
+
+package memory
+
+import "isula.org/rubik/pkg/metrics"
+
+const labelState = "state"
+
+var (
+	dynLevelPressure = metrics.NewGauge("rubik_memory_dynlevel_pressure",
+		"Memory pressure level of dynlevel strategy, 1 for the current level and 0 for the others.", labelState)
+	fssrState = metrics.NewGauge("rubik_memory_fssr_state",
+		"State of fssr strategy, 1 for the current state and 0 for the others.", labelState)
+	fssrLimit = metrics.NewGauge("rubik_memory_fssr_limit_bytes",
+		"Memory high limit of offline containers set by fssr strategy.")
+	reclaimedBytes = metrics.NewCounter("rubik_memory_reclaimed_bytes_total",
+		"Memory reclaimed from the offline pods by the proactive reclaim of dynlevel strategy.",
+		metrics.LabelNamespace, metrics.LabelPod)
+	psiStall = metrics.NewGauge("rubik_memory_psi_stall_percent",
+		"Percentage of time the tasks stall on memory in the last 10 seconds read by the psi signal.")
+)
+
+// pressureLevels lists the names of all dynlevel pressure levels
+func pressureLevels() []string {
+	var levels []string
+	for l := normal; l <= critical; l++ {
+		st := status{pressureLevel: l}
+		levels = append(levels, st.String())
+	}
+	return levels
+}
+
+// fssrStates lists the names of all fssr states
+func fssrStates() []string {
+	var states []string
+	for st := fssrNormal; st <= fssrRelieve; st++ {
+		states = append(states, st.String())
+	}
+	return states
+}
+
+func (f *dynLevel) updateMetrics() {
+	dynLevelPressure.SetState(f.st.String(), pressureLevels())
+}
+
+func (f *fssr) updateMetrics() {
+	fssrState.SetState(f.st.String(), fssrStates())
+	fssrLimit.Set(float64(f.limit))
+}
+
+// resetMetrics removes the metrics of the stopped strategy
+func resetMetrics() {
+	dynLevelPressure.Reset()
+	fssrState.Reset()
+	fssrLimit.Reset()
+	reclaimedBytes.Reset()
+	psiStall.Reset()
+}
@@ -0,0 +1,167 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-10
+// Description: memory pressure signals of the strategies
+
+package memory
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	// signalFree judges the pressure by the ratio of free memory
+	signalFree = "free"
+	// signalAvailable judges the pressure by the ratio of available memory, the page cache easy to
+	// reclaim is not taken as pressure
+	signalAvailable = "available"
+	// signalPSI judges the pressure by the percentage of time the tasks stall on memory
+	signalPSI = "psi"
+
+	psiSome = "some"
+	psiFull = "full"
+
+	memoryPressureFile = "memory.pressure"
+)
+
+// psiSystemFile is the system-wide memory pressure
+var psiSystemFile = "/proc/pressure/memory"
+
+// validateSignal checks the signal is known and supported, empty is taken as free
+func validateSignal(signal string) error {
+	switch signal {
+	case "", signalFree, signalAvailable:
+		return nil
+	case signalPSI:
+		if !capability.Supported(capability.PSI) {
+			return errors.Errorf("signal psi relies on the pressure stall information, which is not enabled in the kernel")
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown signal %q, expect %s|%s|%s", signal, signalFree, signalAvailable, signalPSI)
+	}
+}
+
+// sample is the memory state a strategy judges the pressure by
+type sample struct {
+	mem memoryInfo
+	// stall is the percentage of time the tasks stall on memory in the last 10 seconds,
+	// which is only read with the psi signal
+	stall float64
+}
+
+// amount returns the free or available memory with the signal
+func (s *sample) amount(signal string) int64 {
+	if signal == signalAvailable {
+		return s.mem.available
+	}
+	return s.mem.free
+}
+
+// ratio returns the ratio of free or available memory to the total with the signal
+func (s *sample) ratio(signal string) float64 {
+	return float64(s.amount(signal)) / float64(s.mem.total)
+}
+
+// getSample reads the memory info, and the stall of the kind if the signal is psi
+func (m *MemoryManager) getSample(signal string) (sample, error) {
+	var s sample
+	mem, err := getMemoryInfo()
+	if err != nil {
+		return s, err
+	}
+	s.mem = mem
+	if signal != signalPSI {
+		return s, nil
+	}
+	if s.stall, err = m.readStall(); err != nil {
+		return s, err
+	}
+	psiStall.Set(s.stall)
+	return s, nil
+}
+
+// readStall returns the stall of the online pods on cgroup v2, where offline pods throttled by rubik do not
+// count as pressure, or the system-wide stall on cgroup v1
+func (m *MemoryManager) readStall() (float64, error) {
+	kind := m.psi.Kind
+	if !cgroup.IsV2() || m.cpm == nil {
+		b, err := ioutil.ReadFile(psiSystemFile)
+		if err != nil {
+			return 0, errors.Errorf("read %s failed: %v", psiSystemFile, err)
+		}
+		return parsePSI(string(b), kind)
+	}
+
+	var stall float64
+	for _, pod := range m.cpm.ListOnlinePods() {
+		v, err := cgroup.ReadValue(cgroup.PodPath("memory", pod), memoryPressureFile)
+		if err != nil {
+			// the pod may be deleted just now
+			log.Debugf("read memory pressure of pod %s failed: %v", pod.UID, err)
+			continue
+		}
+		podStall, err := parsePSI(v, kind)
+		if err != nil {
+			log.Errorf("parse memory pressure of pod %s failed: %v", pod.UID, err)
+			continue
+		}
+		if podStall > stall {
+			stall = podStall
+		}
+	}
+	return stall, nil
+}
+
+// triggerFile returns the pressure file to register the psi trigger on, which is the one of the kubepods
+// cgroup holding the online pods on cgroup v2 to match readStall, or the system-wide one on cgroup v1.
+// The stall of the offline pods under kubepods only wakes the check, which does not count them.
+func (m *MemoryManager) triggerFile() string {
+	if !cgroup.IsV2() || m.cpm == nil {
+		return psiSystemFile
+	}
+	return filepath.Join(cgroup.Path("memory", cgroup.KubepodsCgroupPath(corev1.PodQOSGuaranteed)),
+		memoryPressureFile)
+}
+
+// parsePSI returns avg10 of the kind from the pressure file content, such as
+// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+// full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parsePSI(content, kind string) (float64, error) {
+	const avg10 = "avg10="
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != kind {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, avg10) {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimPrefix(field, avg10), 64)
+			if err != nil {
+				return 0, errors.Errorf("parse %s failed: %v", field, err)
+			}
+			return v, nil
+		}
+	}
+	return 0, errors.Errorf("no avg10 of %s found", kind)
+}
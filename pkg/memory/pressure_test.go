@@ -0,0 +1,159 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-10
+// Description: memory pressure signals test
+
+package memory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/typedef"
+)
+
+const pressureContent = `some avg10=12.50 avg60=3.00 avg300=1.00 total=123456
+full avg10=4.25 avg60=1.00 avg300=0.50 total=23456
+`
+
+// TestParsePSI tests avg10 of the kind is parsed
+func TestParsePSI(t *testing.T) {
+	v, err := parsePSI(pressureContent, psiSome)
+	assert.NoError(t, err)
+	assert.Equal(t, 12.5, v)
+	v, err = parsePSI(pressureContent, psiFull)
+	assert.NoError(t, err)
+	assert.Equal(t, 4.25, v)
+
+	_, err = parsePSI("some avg10=abc", psiSome)
+	assert.Error(t, err)
+	_, err = parsePSI("some avg60=1.00", psiSome)
+	assert.Error(t, err)
+}
+
+// TestReadStall tests the stall is the max of the online pods on cgroup v2, and system-wide on cgroup v1
+func TestReadStall(t *testing.T) {
+	fake := cgroup.NewFake()
+	defer cgroup.SetBackend(cgroup.SetBackend(fake))
+	defer cgroup.SetMode(cgroup.SetMode(cgroup.ModeV2))
+
+	pods := map[string]*typedef.PodInfo{
+		"online1": {UID: "online1", CgroupPath: "kubepods/burstable/podonline1"},
+		"online2": {UID: "online2", CgroupPath: "kubepods/podonline2"},
+		// the offline pod is throttled by rubik itself
+		"offline": {UID: "offline", CgroupPath: "kubepods/besteffort/podoffline", Offline: true},
+		// the pod being deleted has no cgroup
+		"deleted": {UID: "deleted", CgroupPath: "kubepods/poddeleted"},
+	}
+	m := &MemoryManager{psi: config.DefaultPSIConfig(),
+		cpm: &checkpoint.Manager{Checkpoint: &checkpoint.Checkpoint{Pods: pods}}}
+	write := func(uid, some string) {
+		fake.Set(filepath.Join(cgroup.PodPath("memory", pods[uid]), memoryPressureFile),
+			"some avg10="+some+" avg60=0.00 avg300=0.00 total=0\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	}
+	write("online1", "3.00")
+	write("online2", "7.50")
+	write("offline", "90.00")
+	stall, err := m.readStall()
+	assert.NoError(t, err)
+	assert.Equal(t, 7.5, stall)
+
+	assert.NoError(t, os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode))
+	defer os.RemoveAll(constant.TmpTestDir)
+	defer func(old string) { psiSystemFile = old }(psiSystemFile)
+	psiSystemFile = filepath.Join(constant.TmpTestDir, "memory")
+	assert.NoError(t, ioutil.WriteFile(psiSystemFile, []byte(pressureContent), constant.DefaultFileMode))
+	cgroup.SetMode(cgroup.ModeV1)
+	m.psi.Kind = psiFull
+	stall, err = m.readStall()
+	assert.NoError(t, err)
+	assert.Equal(t, 4.25, stall)
+}
+
+// TestTriggerFile tests the trigger is registered on the kubepods cgroup on cgroup v2
+func TestTriggerFile(t *testing.T) {
+	defer cgroup.SetMode(cgroup.SetMode(cgroup.ModeV2))
+	m := &MemoryManager{cpm: &checkpoint.Manager{Checkpoint: &checkpoint.Checkpoint{}}}
+	assert.Equal(t, filepath.Join(cgroup.Root(), "kubepods", memoryPressureFile), m.triggerFile())
+
+	cgroup.SetMode(cgroup.ModeV1)
+	assert.Equal(t, psiSystemFile, m.triggerFile())
+}
+
+// TestFssrJudge tests fssr judges the pressure by the signal
+func TestFssrJudge(t *testing.T) {
+	const total = 1000
+	f := &fssr{mmgr: &MemoryManager{}, total: total}
+	f.setThresholds(config.DefaultFssrConfig())
+	s := sample{mem: memoryInfo{total: total, free: 40, available: 400}, stall: 5}
+
+	tight, rich := f.judge(s)
+	assert.True(t, tight)
+	assert.False(t, rich)
+
+	f.cfg.Signal = signalAvailable
+	tight, rich = f.judge(s)
+	assert.False(t, tight)
+	assert.True(t, rich)
+
+	f.cfg.Signal = signalPSI
+	tight, rich = f.judge(s)
+	assert.False(t, tight)
+	assert.False(t, rich)
+	s.stall = f.cfg.PSIReclaim
+	tight, _ = f.judge(s)
+	assert.True(t, tight)
+}
+
+// TestRunLoop tests the check runs right away when the trigger wakes up the loop
+func TestRunLoop(t *testing.T) {
+	const hour = 3600
+	m := &MemoryManager{checkInterval: hour, stop: make(chan struct{}), wake: make(chan struct{}, 1)}
+	checked := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		m.runLoop(func() { checked <- struct{}{} })
+		close(done)
+	}()
+	<-checked
+	m.wake <- struct{}{}
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Fatal("check is not woken up")
+	}
+	close(m.stop)
+	<-done
+}
+
+// TestStartTrigger tests the trigger is registered and stopped, it is skipped without psi or privilege
+func TestStartTrigger(t *testing.T) {
+	cfg := config.DefaultPSIConfig()
+	stop := make(chan struct{})
+	err := startTrigger(psiSystemFile, cfg.Kind, cfg.TriggerStall, cfg.TriggerWindow, make(chan struct{}, 1), stop)
+	if err != nil {
+		t.Skipf("psi trigger is not available: %v", err)
+	}
+	close(stop)
+
+	err = startTrigger(filepath.Join(constant.TmpTestDir, "path/not/exist"), cfg.Kind, cfg.TriggerStall,
+		cfg.TriggerWindow, make(chan struct{}, 1), make(chan struct{}))
+	assert.Error(t, err)
+}
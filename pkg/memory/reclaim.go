@@ -0,0 +1,154 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-13
+// Description: proactive reclaim of the offline pod cgroups
+
+package memory
+
+import (
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+const (
+	// reclaimGlobal force empties the offline containers at mid level and drops the caches of the
+	// whole system at critical level
+	reclaimGlobal = "global"
+	// reclaimCgroup reclaims the memory of the offline pod cgroups only by the gap to the low pressure
+	reclaimCgroup = "cgroup"
+
+	memoryReclaimFile = "memory.reclaim"
+	// psiReclaimPercent is the percentage of the total memory reclaimed in a round if the free memory
+	// is above the low pressure level, which happens with the psi signal
+	psiReclaimPercent = 1
+)
+
+// validateReclaimMode checks the reclaim mode is known, empty is taken as global
+func validateReclaimMode(mode string) error {
+	switch mode {
+	case "", reclaimGlobal, reclaimCgroup:
+		return nil
+	default:
+		return errors.Errorf("unknown reclaimMode %q, expect %s|%s", mode, reclaimGlobal, reclaimCgroup)
+	}
+}
+
+// checkReclaimSupported checks the pod cgroups can be reclaimed by memory.reclaim, or memory.high tightened
+func checkReclaimSupported(mode string) error {
+	if mode != reclaimCgroup {
+		return nil
+	}
+	if !capability.Supported(capability.MemoryReclaim) && !capability.Supported(capability.MemoryHigh) {
+		return errors.Errorf("reclaimMode cgroup relies on memory.reclaim or memory.high, " +
+			"neither is supported by the kernel")
+	}
+	return nil
+}
+
+// reclaimTarget returns the bytes to reclaim to bring the free memory back to the low pressure level,
+// the available memory is taken instead with the available signal
+func (f *dynLevel) reclaimTarget() int64 {
+	s := sample{mem: f.memInfo}
+	target := int64(float64(f.memInfo.total)*f.cfg.LowPressure/percent) - s.amount(f.cfg.Signal)
+	if target <= 0 {
+		target = f.memInfo.total * psiReclaimPercent / percent
+	}
+	return target
+}
+
+// reclaimOfflinePods reclaims the target from the offline pods in proportion to their usage
+func (f *dynLevel) reclaimOfflinePods() {
+	target := f.reclaimTarget()
+	reclaimed := reclaimPods(f.m.cpm.ListOfflinePods(), target, "dynlevel reclaim at "+f.st.String())
+	var total int64
+	for _, v := range reclaimed {
+		total += v
+	}
+	log.Logf("reclaim %d of %d bytes from %d offline pods", total, target, len(reclaimed))
+}
+
+// reclaimPods splits the target among the pods by their usage and returns the bytes reclaimed from each pod
+func reclaimPods(pods map[string]*typedef.PodInfo, target int64, reason string) map[string]int64 {
+	usageFile, _ := cgroup.FileName(memoryUsageFile)
+	usages := make(map[string]int64, len(pods))
+	var sum int64
+	for uid, pod := range pods {
+		usage, err := cgroup.ReadInt64(cgroup.PodPath("memory", pod), usageFile)
+		if err != nil {
+			// the pod may be deleted just now
+			log.Debugf("read memory usage of pod %s failed: %v", uid, err)
+			continue
+		}
+		usages[uid] = usage
+		sum += usage
+	}
+
+	reclaimed := make(map[string]int64, len(usages))
+	if sum == 0 {
+		return reclaimed
+	}
+	for uid, usage := range usages {
+		pod := pods[uid]
+		share := int64(float64(target) * float64(usage) / float64(sum))
+		if share > usage {
+			share = usage
+		}
+		if share <= 0 {
+			continue
+		}
+		v, err := reclaimPod(pod, usage, share, reason)
+		if err != nil {
+			log.Errorf("reclaim memory of pod %s failed: %v", pod.UID, err)
+			continue
+		}
+		reclaimed[uid] = v
+		reclaimedBytes.Add(float64(v), pod.Namespace, pod.Name)
+		log.Logf("reclaim %d of %d bytes from pod %s/%s", v, share, pod.Namespace, pod.Name)
+	}
+	return reclaimed
+}
+
+// reclaimPod reclaims the share from the pod with memory.reclaim, or by tightening memory.high below the
+// usage for a moment if memory.reclaim is not supported, and returns the decrease of the usage
+func reclaimPod(pod *typedef.PodInfo, usage, share int64, reason string) (int64, error) {
+	path := cgroup.PodPath("memory", pod)
+	if capability.Supported(capability.MemoryReclaim) {
+		// the write fails with EAGAIN if less than the share is reclaimed, which is not an error here
+		if err := writeMemoryFile(path, memoryReclaimFile, typedef.FormatInt64(share), reason); err != nil {
+			log.Debugf("reclaim %d bytes of %s partially: %v", share, path, err)
+		}
+	} else {
+		origin, err := cgroup.ReadValue(path, memoryHighFile)
+		if err != nil {
+			return 0, err
+		}
+		if err := writeMemoryLimit(path, typedef.FormatInt64(usage-share), mhigh, reason); err != nil {
+			return 0, err
+		}
+		if err := writeMemoryLimit(path, origin, mhigh, reason+" done"); err != nil {
+			return 0, err
+		}
+	}
+
+	usageFile, _ := cgroup.FileName(memoryUsageFile)
+	after, err := cgroup.ReadInt64(path, usageFile)
+	if err != nil {
+		return 0, err
+	}
+	if after >= usage {
+		return 0, nil
+	}
+	return usage - after, nil
+}
@@ -0,0 +1,137 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-13
+// Description: proactive reclaim of the offline pod cgroups test
+
+package memory
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/typedef"
+)
+
+const mi = 1 << 20
+
+// reclaimFake lowers the usage of the cgroup as memory.reclaim or memory.high below the usage is written
+type reclaimFake struct {
+	*cgroup.Fake
+}
+
+func (f reclaimFake) WriteFile(path string, data []byte) error {
+	if err := f.Fake.WriteFile(path, data); err != nil {
+		return err
+	}
+	value, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return nil
+	}
+	usageFile, _ := cgroup.FileName(memoryUsageFile)
+	usagePath := filepath.Join(filepath.Dir(path), usageFile)
+	v, _ := f.Get(usagePath)
+	usage, _ := strconv.ParseInt(v, 10, 64)
+	switch filepath.Base(path) {
+	case memoryReclaimFile:
+		usage -= value
+	case memoryHighFile:
+		if value < usage {
+			usage = value
+		}
+	}
+	if usage < 0 {
+		usage = 0
+	}
+	f.Set(usagePath, strconv.FormatInt(usage, 10))
+	return nil
+}
+
+func newReclaimDynLevel(pods map[string]*typedef.PodInfo) *dynLevel {
+	m := &MemoryManager{cpm: &checkpoint.Manager{Checkpoint: &checkpoint.Checkpoint{Pods: pods}}}
+	cfg := config.DefaultDynLevelConfig()
+	cfg.ReclaimMode = reclaimCgroup
+	f := newDynLevel(m, cfg)
+	f.st.pressureLevel = mid
+	return f
+}
+
+// TestReclaimOfflinePodsV2 tests the gap to the low pressure is reclaimed from the offline pods by their usage
+func TestReclaimOfflinePodsV2(t *testing.T) {
+	fake := reclaimFake{cgroup.NewFake()}
+	defer cgroup.SetBackend(cgroup.SetBackend(fake))
+	defer cgroup.SetMode(cgroup.SetMode(cgroup.ModeV2))
+	defer capability.SetReport(capability.SetReport(map[string]bool{capability.MemoryReclaim: true}))
+
+	pods := map[string]*typedef.PodInfo{
+		"offline1": {UID: "offline1", Name: "offline1", CgroupPath: "kubepods/besteffort/podoffline1", Offline: true},
+		"offline2": {UID: "offline2", Name: "offline2", CgroupPath: "kubepods/besteffort/podoffline2", Offline: true},
+		"online":   {UID: "online", Name: "online", CgroupPath: "kubepods/podonline"},
+	}
+	for uid, usage := range map[string]int64{"offline1": 300 * mi, "offline2": 100 * mi, "online": 500 * mi} {
+		dir := cgroup.PodPath("memory", pods[uid])
+		fake.Set(filepath.Join(dir, "memory.current"), strconv.FormatInt(usage, 10))
+		fake.Set(filepath.Join(dir, memoryReclaimFile), "")
+	}
+
+	f := newReclaimDynLevel(pods)
+	f.cfg.LowPressure = 10
+	// 10% of 1000Mi is the low pressure, 20Mi is to be reclaimed
+	f.memInfo = memoryInfo{total: 1000 * mi, free: 80 * mi, available: 500 * mi}
+	assert.Equal(t, int64(20*mi), f.reclaimTarget())
+	f.reclaim()
+	value := func(uid string) string {
+		v, _ := fake.Get(filepath.Join(cgroup.PodPath("memory", pods[uid]), "memory.current"))
+		return v
+	}
+	assert.Equal(t, strconv.FormatInt(285*mi, 10), value("offline1"))
+	assert.Equal(t, strconv.FormatInt(95*mi, 10), value("offline2"))
+	assert.Equal(t, strconv.FormatInt(500*mi, 10), value("online"))
+
+	// a share of the total is reclaimed if the free memory is above the low pressure with the psi signal
+	f.cfg.Signal = signalPSI
+	f.memInfo.free = 500 * mi
+	assert.Equal(t, int64(10*mi), f.reclaimTarget())
+}
+
+// TestReclaimOfflinePodsV1 tests memory.high is tightened and restored without memory.reclaim
+func TestReclaimOfflinePodsV1(t *testing.T) {
+	fake := reclaimFake{cgroup.NewFake()}
+	defer cgroup.SetBackend(cgroup.SetBackend(fake))
+	defer cgroup.SetMode(cgroup.SetMode(cgroup.ModeV1))
+	defer capability.SetReport(capability.SetReport(map[string]bool{capability.MemoryHigh: true}))
+	assert.NoError(t, journal.Init(""))
+	defer journal.Restore(moduleName)
+
+	pod := &typedef.PodInfo{UID: "offline", Name: "offline", CgroupPath: "kubepods/besteffort/podoffline",
+		Offline: true}
+	dir := cgroup.PodPath("memory", pod)
+	fake.Set(filepath.Join(dir, memoryUsageFile), strconv.FormatInt(400*mi, 10))
+	fake.Set(filepath.Join(dir, memoryHighFile), "9223372036854771712")
+
+	reclaimed := reclaimPods(map[string]*typedef.PodInfo{pod.UID: pod}, 30*mi, "test")
+	assert.Equal(t, map[string]int64{pod.UID: 30 * mi}, reclaimed)
+	v, _ := fake.Get(filepath.Join(dir, memoryUsageFile))
+	assert.Equal(t, strconv.FormatInt(370*mi, 10), v)
+	v, _ = fake.Get(filepath.Join(dir, memoryHighFile))
+	assert.Equal(t, "9223372036854771712", v)
+
+	// nothing is reclaimed from the pods without usage
+	fake.Set(filepath.Join(dir, memoryUsageFile), "0")
+	assert.Empty(t, reclaimPods(map[string]*typedef.PodInfo{pod.UID: pod}, 30*mi, "test"))
+}
@@ -0,0 +1,151 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-10-17
+// Description: memory service registration
+
+package memory
+
+import (
+	"sync"
+
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+type memoryService struct {
+	sync.Mutex
+	mm  *MemoryManager
+	cpm *checkpoint.Manager
+	cfg config.MemoryConfig
+}
+
+func init() {
+	services.Register(&memoryService{})
+}
+
+// Name returns the service name
+func (s *memoryService) Name() string {
+	return moduleName
+}
+
+// Enabled tells whether memory management is enabled in config
+func (s *memoryService) Enabled(cfg *config.Config) bool {
+	return cfg.MemCfg.Enable
+}
+
+// Init creates and runs the memory manager
+func (s *memoryService) Init(cfg *config.Config, cpm *checkpoint.Manager) error {
+	s.Lock()
+	defer s.Unlock()
+	s.cpm = cpm
+	return s.start(cfg.MemCfg)
+}
+
+// Validate checks the new memory config
+func (s *memoryService) Validate(cfg *config.Config) error {
+	return ValidateConfig(cfg.MemCfg)
+}
+
+// Reload applies the new thresholds to the running strategy, or restarts the memory manager if the
+// strategy, check interval, signal or psi trigger changes, the limits written with the old strategy are restored before
+// the new one starts
+func (s *memoryService) Reload(cfg *config.Config) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.cfg == cfg.MemCfg {
+		return nil
+	}
+	if s.mm != nil && sameRunner(s.cfg, cfg.MemCfg) {
+		s.mm.SetConfig(cfg.MemCfg)
+		s.cfg = cfg.MemCfg
+		return nil
+	}
+
+	s.stop()
+	if err := journal.Restore(moduleName); err != nil {
+		log.Errorf("restore memory settings failed: %v", err)
+	}
+	return s.start(cfg.MemCfg)
+}
+
+// sameRunner tells whether the running strategy can take the new config in place, the strategy, check
+// interval and psi trigger are set up when it starts
+func sameRunner(old, new config.MemoryConfig) bool {
+	return old.Strategy == new.Strategy && old.CheckInterval == new.CheckInterval &&
+		strategySignal(old) == strategySignal(new) && old.PSI == new.PSI
+}
+
+// start creates and runs the memory manager, the caller must hold the lock
+func (s *memoryService) start(memCfg config.MemoryConfig) error {
+	mm, err := NewMemoryManager(s.cpm, memCfg)
+	if err != nil {
+		return err
+	}
+	s.cfg = memCfg
+	// memory manager is nil with strategy none
+	if mm == nil {
+		return nil
+	}
+
+	s.mm = mm
+	s.mm.Run()
+	log.Infof("init memory manager ok")
+	return nil
+}
+
+// stop stops the running memory manager, the caller must hold the lock
+func (s *memoryService) stop() {
+	if s.mm != nil {
+		s.mm.Stop()
+		s.mm = nil
+	}
+}
+
+// PodAdded applies memory config to the new offline pod
+func (s *memoryService) PodAdded(pod *typedef.PodInfo) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.mm == nil || !pod.Offline {
+		return nil
+	}
+	s.mm.UpdateConfig(pod)
+	return nil
+}
+
+// PodUpdated applies memory config to the offline pod if its containers change
+func (s *memoryService) PodUpdated(old, new *typedef.PodInfo) error {
+	if old.Offline == new.Offline && old.SameContainers(new) {
+		return nil
+	}
+	return s.PodAdded(new)
+}
+
+// PodDeleted does nothing as the pod cgroups are removed along with the pod
+func (s *memoryService) PodDeleted(pod *typedef.PodInfo) error {
+	return nil
+}
+
+// Sync does nothing as the memory manager checks offline pods periodically
+func (s *memoryService) Sync(pods map[string]*typedef.PodInfo) error {
+	return nil
+}
+
+// Shutdown stops the service
+func (s *memoryService) Shutdown() error {
+	s.Lock()
+	defer s.Unlock()
+	s.stop()
+	return nil
+}
@@ -13,16 +13,14 @@
 
 package memory
 
-import log "isula.org/rubik/pkg/tinylog"
-
-const (
-	// lowPressure means free / total < 30%
-	lowPressure      = 0.3
-	midPressure      = 0.15
-	highPressure     = 0.1
-	criticalPressure = 0.05
+import (
+	"isula.org/rubik/pkg/config"
+	log "isula.org/rubik/pkg/tinylog"
 )
 
+// percent is the unit of the thresholds in config
+const percent = 100
+
 type levelInt int
 
 const (
@@ -58,22 +56,29 @@ func (s *status) isRelieve() bool {
 	return s.pressureLevel == relieve
 }
 
-func (s *status) transitionStatus(freePercentage float64) {
-	if freePercentage > lowPressure {
+func (s *status) transitionStatus(freePercentage float64, cfg config.DynLevelConfig) {
+	s.transition(getLevelInPressure(freePercentage, cfg), cfg.RelieveCount)
+}
+
+// transition moves to the pressure level judged by the signal, or relieves the pressure level
+// for relieveCount checks before it is normal
+func (s *status) transition(level levelInt, relieveCount int) {
+	if level == normal {
 		switch s.pressureLevel {
 		case normal:
 		case low, mid, high, critical:
 			log.Logf("change status from pressure to relieve")
 			s.set(relieve)
 		case relieve:
-			if s.relieveCnt == relieveMaxCnt {
+			// the count may be lowered by reload during the relieve
+			if s.relieveCnt >= relieveCount {
 				s.set(normal)
 				log.Logf("change status from relieve to normal")
 			}
 		}
 		return
 	}
-	s.pressureLevel = getLevelInPressure(freePercentage)
+	s.pressureLevel = level
 }
 
 func (s *status) String() string {
@@ -95,16 +100,34 @@ func (s *status) String() string {
 	}
 }
 
-func getLevelInPressure(freePercentage float64) levelInt {
+func getLevelInPressure(freePercentage float64, cfg config.DynLevelConfig) levelInt {
 	var pressureLevel levelInt
-	if freePercentage <= criticalPressure {
+	if freePercentage > cfg.LowPressure/percent {
+		pressureLevel = normal
+	} else if freePercentage <= cfg.CriticalPressure/percent {
 		pressureLevel = critical
-	} else if freePercentage <= highPressure {
+	} else if freePercentage <= cfg.HighPressure/percent {
 		pressureLevel = high
-	} else if freePercentage <= midPressure {
+	} else if freePercentage <= cfg.MidPressure/percent {
 		pressureLevel = mid
 	} else {
 		pressureLevel = low
 	}
 	return pressureLevel
 }
+
+// getLevelInStall returns the pressure level the stall percentage of the psi signal rises to
+func getLevelInStall(stall float64, cfg config.DynLevelConfig) levelInt {
+	switch {
+	case stall >= cfg.PSICritical:
+		return critical
+	case stall >= cfg.PSIHigh:
+		return high
+	case stall >= cfg.PSIMid:
+		return mid
+	case stall >= cfg.PSILow:
+		return low
+	default:
+		return normal
+	}
+}
@@ -17,6 +17,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/config"
 )
 
 func TestSetStatus(t *testing.T) {
@@ -66,20 +68,48 @@ func TestGetLevelInPressure(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		tmp := getLevelInPressure(tt.freePercentage)
+		tmp := getLevelInPressure(tt.freePercentage, config.DefaultDynLevelConfig())
 		assert.Equal(t, tmp, tt.level)
 	}
 }
 
+// TestGetLevelInStall tests the pressure level rises with the psi stall
+func TestGetLevelInStall(t *testing.T) {
+	cfg := config.DefaultDynLevelConfig()
+	for stall, level := range map[float64]levelInt{0: normal, 4.99: normal, 5: low, 12: mid, 20: high, 75: critical} {
+		assert.Equal(t, level, getLevelInStall(stall, cfg), "stall %v", stall)
+	}
+
+	// the pressure is relieved for relieveCount checks once the stall falls
+	s := newStatus()
+	s.transition(getLevelInStall(30, cfg), cfg.RelieveCount)
+	assert.Equal(t, high, s.pressureLevel)
+	s.transition(getLevelInStall(1, cfg), cfg.RelieveCount)
+	assert.Equal(t, relieve, s.pressureLevel)
+	s.relieveCnt = cfg.RelieveCount
+	s.transition(getLevelInStall(1, cfg), cfg.RelieveCount)
+	assert.Equal(t, normal, s.pressureLevel)
+}
+
 func TestTransitionStatus(t *testing.T) {
+	cfg := config.DefaultDynLevelConfig()
 	s := newStatus()
-	s.transitionStatus(0.04)
+	s.transitionStatus(0.04, cfg)
 	assert.Equal(t, s.pressureLevel, critical)
 
-	s.transitionStatus(0.6)
+	s.transitionStatus(0.6, cfg)
 	assert.Equal(t, s.pressureLevel, relieve)
-	s.relieveCnt = relieveMaxCnt
+	s.relieveCnt = cfg.RelieveCount
+
+	s.transitionStatus(0.6, cfg)
+	assert.Equal(t, s.pressureLevel, normal)
 
-	s.transitionStatus(0.6)
+	// the thresholds for large memory nodes
+	cfg.LowPressure, cfg.MidPressure, cfg.HighPressure, cfg.CriticalPressure = 5, 2, 1, 0.5
+	s.transitionStatus(0.25, cfg)
 	assert.Equal(t, s.pressureLevel, normal)
+	s.transitionStatus(0.015, cfg)
+	assert.Equal(t, s.pressureLevel, mid)
+	s.transitionStatus(0.004, cfg)
+	assert.Equal(t, s.pressureLevel, critical)
 }
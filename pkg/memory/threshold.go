@@ -0,0 +1,141 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-09
+// Description: validation of the memory strategy thresholds
+
+package memory
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/config"
+)
+
+const (
+	maxRelieveCount = 100
+	maxAsyncRatio   = 100
+)
+
+// validateDynLevel checks the pressure levels are in order, 0 < critical < high < mid < low < 100
+func validateDynLevel(cfg config.DynLevelConfig) error {
+	levels := []struct {
+		name  string
+		value float64
+	}{
+		{"criticalPressure", cfg.CriticalPressure},
+		{"highPressure", cfg.HighPressure},
+		{"midPressure", cfg.MidPressure},
+		{"lowPressure", cfg.LowPressure},
+	}
+	lower := 0.0
+	for i, l := range levels {
+		if l.value <= lower {
+			if i == 0 {
+				return errors.Errorf("%s should be larger than 0", l.name)
+			}
+			return errors.Errorf("%s should be larger than %s", l.name, levels[i-1].name)
+		}
+		lower = l.value
+	}
+	if cfg.LowPressure >= percent {
+		return errors.Errorf("lowPressure should be less than %d", percent)
+	}
+	if cfg.RelieveCount < 1 || cfg.RelieveCount > maxRelieveCount {
+		return errors.Errorf("relieveCount should be in range [1, %d]", maxRelieveCount)
+	}
+	if cfg.ExtraFreePercent < 0 || cfg.ExtraFreePercent > percent {
+		return errors.Errorf("extraFreePercent should be in range [0, %d]", percent)
+	}
+	if err := validateReclaimMode(cfg.ReclaimMode); err != nil {
+		return err
+	}
+	if cfg.Signal == signalPSI {
+		return validatePSILevels(cfg)
+	}
+	return nil
+}
+
+// validatePSILevels checks the stall levels are in order, 0 < low < mid < high < critical <= 100
+func validatePSILevels(cfg config.DynLevelConfig) error {
+	levels := []struct {
+		name  string
+		value float64
+	}{
+		{"psiLow", cfg.PSILow},
+		{"psiMid", cfg.PSIMid},
+		{"psiHigh", cfg.PSIHigh},
+		{"psiCritical", cfg.PSICritical},
+	}
+	lower := 0.0
+	for i, l := range levels {
+		if l.value <= lower {
+			if i == 0 {
+				return errors.Errorf("%s should be larger than 0", l.name)
+			}
+			return errors.Errorf("%s should be larger than %s", l.name, levels[i-1].name)
+		}
+		lower = l.value
+	}
+	if cfg.PSICritical > percent {
+		return errors.Errorf("psiCritical should be at most %d", percent)
+	}
+	return nil
+}
+
+// validatePSI checks the kind and the trigger accepted by the kernel
+func validatePSI(cfg config.PSIConfig) error {
+	if cfg.Kind != psiSome && cfg.Kind != psiFull {
+		return errors.Errorf("kind should be %s or %s", psiSome, psiFull)
+	}
+	if cfg.TriggerStall == 0 {
+		return nil
+	}
+	if cfg.TriggerWindow < minTriggerWindow || cfg.TriggerWindow > maxTriggerWindow {
+		return errors.Errorf("triggerWindow should be in range [%d, %d]", minTriggerWindow, maxTriggerWindow)
+	}
+	if cfg.TriggerStall < 0 || cfg.TriggerStall > cfg.TriggerWindow {
+		return errors.Errorf("triggerStall should be in range [0, triggerWindow]")
+	}
+	return nil
+}
+
+// validateFssr checks the reserved memory is less than the waterline, and it is still reserved when relieving
+func validateFssr(cfg config.FssrConfig) error {
+	if cfg.ReservePercent <= 0 || cfg.ReservePercent >= percent {
+		return errors.Errorf("reservePercent should be in range (0, %d)", percent)
+	}
+	if cfg.RelieveRatio < 1 || cfg.ReservePercent*cfg.RelieveRatio >= percent {
+		return errors.Errorf("relieveRatio should be at least 1 and reservePercent * relieveRatio less than %d",
+			percent)
+	}
+	if cfg.WaterlinePercent <= cfg.ReservePercent || cfg.WaterlinePercent > percent {
+		return errors.Errorf("waterlinePercent should be in range (reservePercent, %d]", percent)
+	}
+	if cfg.ReclaimPercent <= 0 || cfg.ReclaimPercent > percent {
+		return errors.Errorf("reclaimPercent should be in range (0, %d]", percent)
+	}
+	if cfg.RelievePercent <= 0 || cfg.RelievePercent > percent {
+		return errors.Errorf("relievePercent should be in range (0, %d]", percent)
+	}
+	d, err := time.ParseDuration(cfg.PrerelieveInterval)
+	if err != nil || d < 0 {
+		return errors.Errorf("invalid prerelieveInterval %q, expect a duration such as 30m", cfg.PrerelieveInterval)
+	}
+	if cfg.HighAsyncRatio < 0 || cfg.HighAsyncRatio > maxAsyncRatio {
+		return errors.Errorf("highAsyncRatio should be in range [0, %d]", maxAsyncRatio)
+	}
+	if cfg.Signal == signalPSI && (cfg.PSIRelieve < 0 || cfg.PSIReclaim <= cfg.PSIRelieve || cfg.PSIReclaim > percent) {
+		return errors.Errorf("psiReclaim and psiRelieve should be 0 <= psiRelieve < psiReclaim <= %d", percent)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-09
+// Description: memory strategy thresholds test
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/config"
+)
+
+// TestValidateConfig tests the thresholds of both strategies are checked in order
+func TestValidateConfig(t *testing.T) {
+	valid := config.MemoryConfig{Strategy: "dynlevel", CheckInterval: 5,
+		DynLevel: config.DefaultDynLevelConfig(), Fssr: config.DefaultFssrConfig(), PSI: config.DefaultPSIConfig()}
+	assert.NoError(t, ValidateConfig(valid))
+	defer capability.SetReport(capability.SetReport(nil))
+
+	for name, modify := range map[string]func(c *config.MemoryConfig){
+		"criticalPressure should be larger than 0": func(c *config.MemoryConfig) { c.DynLevel.CriticalPressure = 0 },
+		"midPressure should be larger than high":   func(c *config.MemoryConfig) { c.DynLevel.MidPressure = 10 },
+		"lowPressure should be larger than mid":    func(c *config.MemoryConfig) { c.DynLevel.LowPressure = 12 },
+		"lowPressure should be less than 100":      func(c *config.MemoryConfig) { c.DynLevel.LowPressure = 100 },
+		"relieveCount":                             func(c *config.MemoryConfig) { c.DynLevel.RelieveCount = 0 },
+		"extraFreePercent":                         func(c *config.MemoryConfig) { c.DynLevel.ExtraFreePercent = -1 },
+		"reservePercent":                           func(c *config.MemoryConfig) { c.Fssr.ReservePercent = 0 },
+		"relieveRatio":                             func(c *config.MemoryConfig) { c.Fssr.RelieveRatio = 40 },
+		"waterlinePercent":                         func(c *config.MemoryConfig) { c.Fssr.WaterlinePercent = 5 },
+		"reclaimPercent":                           func(c *config.MemoryConfig) { c.Fssr.ReclaimPercent = 101 },
+		"relievePercent":                           func(c *config.MemoryConfig) { c.Fssr.RelievePercent = 0 },
+		"prerelieveInterval":                       func(c *config.MemoryConfig) { c.Fssr.PrerelieveInterval = "30" },
+		"highAsyncRatio":                           func(c *config.MemoryConfig) { c.Fssr.HighAsyncRatio = 101 },
+		"unknown signal":                           func(c *config.MemoryConfig) { c.DynLevel.Signal = "used" },
+		"unknown reclaimMode":                      func(c *config.MemoryConfig) { c.DynLevel.ReclaimMode = "drop" },
+		"psiMid should be larger than psiLow": func(c *config.MemoryConfig) {
+			c.DynLevel.Signal, c.DynLevel.PSIMid = signalPSI, 5
+		},
+		"psiCritical should be at most 100": func(c *config.MemoryConfig) {
+			c.DynLevel.Signal, c.DynLevel.PSICritical = signalPSI, 101
+		},
+		"psiRelieve < psiReclaim": func(c *config.MemoryConfig) {
+			c.Fssr.Signal, c.Fssr.PSIRelieve = signalPSI, 10
+		},
+		"kind should be": func(c *config.MemoryConfig) {
+			c.DynLevel.Signal, c.PSI.Kind = signalPSI, "all"
+		},
+		"triggerWindow": func(c *config.MemoryConfig) {
+			c.DynLevel.Signal, c.PSI.TriggerWindow = signalPSI, 100
+		},
+		"triggerStall": func(c *config.MemoryConfig) {
+			c.DynLevel.Signal, c.PSI.TriggerStall = signalPSI, 3000
+		},
+	} {
+		c := valid
+		modify(&c)
+		err := ValidateConfig(c)
+		if assert.Error(t, err, name) {
+			assert.Contains(t, err.Error(), name)
+		}
+	}
+
+	// fractions of a percent fit the nodes of large memory
+	valid.DynLevel = config.DynLevelConfig{LowPressure: 3, MidPressure: 2, HighPressure: 1, CriticalPressure: 0.5,
+		RelieveCount: 3, ExtraFreePercent: 0.1}
+	valid.Fssr.ReservePercent, valid.Fssr.ReclaimPercent, valid.Fssr.RelievePercent = 0.5, 1, 0.2
+	assert.NoError(t, ValidateConfig(valid))
+
+	// the psi signal relies on psi enabled, and the trigger is disabled with 0 stall
+	valid.DynLevel = config.DefaultDynLevelConfig()
+	valid.DynLevel.Signal, valid.PSI.TriggerStall, valid.PSI.TriggerWindow = signalPSI, 0, 0
+	assert.NoError(t, ValidateConfig(valid))
+	capability.SetReport(map[string]bool{capability.PSI: false})
+	err := ValidateConfig(valid)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "pressure stall information")
+	}
+
+	// the cgroup reclaim relies on memory.reclaim or memory.high
+	valid.DynLevel = config.DefaultDynLevelConfig()
+	valid.DynLevel.ReclaimMode = reclaimCgroup
+	capability.SetReport(map[string]bool{capability.MemoryReclaim: false, capability.MemoryHigh: true})
+	assert.NoError(t, ValidateConfig(valid))
+	capability.SetReport(map[string]bool{capability.MemoryReclaim: false, capability.MemoryHigh: false})
+	assert.Error(t, ValidateConfig(valid))
+}
+
+// TestSetConfig tests the new thresholds are applied to the running strategies
+func TestSetConfig(t *testing.T) {
+	cfg := config.MemoryConfig{DynLevel: config.DefaultDynLevelConfig(), Fssr: config.DefaultFssrConfig()}
+	dl := newDynLevel(&MemoryManager{}, cfg.DynLevel)
+	cfg.DynLevel.LowPressure = 5
+	dl.SetConfig(cfg)
+	dl.st.transitionStatus(0.1, dl.cfg)
+	assert.Equal(t, normal, dl.st.pressureLevel)
+
+	const total = 1000
+	f := &fssr{mmgr: &MemoryManager{}, total: total}
+	f.setThresholds(config.DefaultFssrConfig())
+	f.limit = f.waterline()
+	assert.Equal(t, int64(800), f.limit)
+	assert.Equal(t, int64(50), f.reservedMemory)
+	assert.Equal(t, 30*time.Minute, f.prerelieveInterval)
+
+	// the limit is lowered to the new waterline
+	cfg.Fssr.WaterlinePercent, cfg.Fssr.ReservePercent, cfg.Fssr.PrerelieveInterval = 60, 1, "10m"
+	f.SetConfig(cfg)
+	assert.Equal(t, int64(600), f.limit)
+	assert.Equal(t, int64(10), f.reservedMemory)
+	assert.Equal(t, 10*time.Minute, f.prerelieveInterval)
+	f.st = fssrReclaim
+	cfg.Fssr.ReclaimPercent = 55
+	f.SetConfig(cfg)
+	assert.Equal(t, int64(50), f.calculateNewLimit())
+}
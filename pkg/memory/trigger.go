@@ -0,0 +1,80 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-10
+// Description: psi trigger waking up the memory strategy
+
+package memory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	// the window of a psi trigger is limited by the kernel
+	minTriggerWindow = 500
+	maxTriggerWindow = 10000
+	// pollTimeout bounds how long the trigger takes to find it is stopped
+	pollTimeout = time.Second
+	msToUs      = 1000
+)
+
+// startTrigger registers the psi trigger of the stall within the window on file, and sends to wake once the
+// tasks stall on memory longer than that until stop is closed, see Documentation/accounting/psi.rst
+func startTrigger(file, kind string, stallMs, windowMs int, wake chan<- struct{}, stop <-chan struct{}) error {
+	fd, err := unix.Open(file, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return errors.Errorf("open %s failed: %v", file, err)
+	}
+	trigger := fmt.Sprintf("%s %d %d", kind, stallMs*msToUs, windowMs*msToUs)
+	// the trigger is written with the terminating null
+	if _, err := unix.Write(fd, append([]byte(trigger), 0)); err != nil {
+		unix.Close(fd)
+		return errors.Errorf("register psi trigger %q failed: %v", trigger, err)
+	}
+
+	go func() {
+		defer unix.Close(fd)
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLPRI}}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n, err := unix.Poll(fds, int(pollTimeout/time.Millisecond))
+			if err == unix.EINTR || n == 0 {
+				continue
+			}
+			if err != nil {
+				log.Errorf("poll psi trigger failed, fall back to check periodically: %v", err)
+				return
+			}
+			if fds[0].Revents&unix.POLLERR != 0 {
+				log.Errorf("psi trigger is gone, fall back to check periodically")
+				return
+			}
+			if fds[0].Revents&unix.POLLPRI != 0 {
+				log.Debugf("psi trigger %q fires", trigger)
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return nil
+}
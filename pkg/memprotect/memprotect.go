@@ -0,0 +1,250 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-12
+// Description: memory protection of online pods
+
+// Package memprotect protects the memory of online pods from the reclaim under memory pressure with
+// memory.low or memory.min, so that the working sets of online pods are kept while offline pods churn memory
+package memprotect
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+const (
+	moduleName = "memprotect"
+
+	// ModeNone disables the memory protection
+	ModeNone = "none"
+	// ModeLow protects the memory with memory.low, which is reclaimed only if no unprotected memory is left
+	ModeLow = "low"
+	// ModeMin protects the memory with memory.min, which is never reclaimed even if OOM is triggered
+	ModeMin = "min"
+
+	percent = 100
+)
+
+// ValidateConfig checks the memory protection config
+func ValidateConfig(cfg config.ProtectionConfig) error {
+	switch cfg.Mode {
+	case ModeNone:
+		return nil
+	case ModeLow, ModeMin:
+	default:
+		return errors.Errorf("unsupported protection mode %q, expect %s|%s|%s", cfg.Mode, ModeNone, ModeLow, ModeMin)
+	}
+	if cfg.RequestPercent < 0 || cfg.RequestPercent > percent {
+		return errors.Errorf("requestPercent should be in range [0, %d]", percent)
+	}
+	if !capability.Supported(capability.MemoryLow) {
+		return errors.Errorf("protection mode %s relies on memory.%s, which is not supported by the kernel",
+			cfg.Mode, cfg.Mode)
+	}
+	return nil
+}
+
+// protector keeps the protection of the pod cgroups and their parents in line with the pods
+type protector struct {
+	cfg  config.ProtectionConfig
+	pods map[string]*typedef.PodInfo
+	// dirs are the cgroups protected, true for the pod cgroups and false for their parents
+	dirs map[string]bool
+	// targets are the protection of the pod cgroups and their parents, which is kept up to date as the pods
+	// change so that a pod event only touches the cgroups of the pod
+	targets map[string]int64
+	// refs counts the pods under the cgroups of targets
+	refs map[string]int
+	// podOf maps the pod cgroups to the pods
+	podOf map[string]*typedef.PodInfo
+}
+
+func newProtector(cfg config.ProtectionConfig) *protector {
+	p := &protector{cfg: cfg, dirs: make(map[string]bool)}
+	p.setPods(nil)
+	return p
+}
+
+// setPods replaces the pods known with all pods of the node
+func (p *protector) setPods(pods map[string]*typedef.PodInfo) {
+	p.pods = make(map[string]*typedef.PodInfo, len(pods))
+	p.targets = make(map[string]int64)
+	p.refs = make(map[string]int)
+	p.podOf = make(map[string]*typedef.PodInfo, len(pods))
+	for _, pod := range pods {
+		p.setPod(pod)
+	}
+}
+
+// setPod adds or updates the pod, and returns the cgroups whose targets may change
+func (p *protector) setPod(pod *typedef.PodInfo) []string {
+	dirs := p.removePod(pod.UID)
+	p.pods[pod.UID] = pod
+	p.account(pod, 1)
+	return append(dirs, paths(pod)...)
+}
+
+// removePod removes the pod, and returns the cgroups whose targets may change
+func (p *protector) removePod(uid string) []string {
+	old, ok := p.pods[uid]
+	if !ok {
+		return nil
+	}
+	delete(p.pods, uid)
+	p.account(old, -1)
+	return paths(old)
+}
+
+// account adds the protection of the pod to its cgroup and parents, or subtracts it if sign is -1
+func (p *protector) account(pod *typedef.PodInfo, sign int) {
+	value := p.protection(pod)
+	dirs := paths(pod)
+	if sign > 0 {
+		p.podOf[dirs[0]] = pod
+	} else if p.podOf[dirs[0]] == pod {
+		delete(p.podOf, dirs[0])
+	}
+	for _, dir := range dirs {
+		p.targets[dir] += int64(sign) * value
+		p.refs[dir] += sign
+		if p.refs[dir] <= 0 {
+			delete(p.targets, dir)
+			delete(p.refs, dir)
+		}
+	}
+}
+
+// paths returns the cgroup of the pod followed by its parents up to the cgroup root
+func paths(pod *typedef.PodInfo) []string {
+	dir := cgroup.PodPath("memory", pod)
+	dirs := []string{dir}
+	for i := strings.Count(strings.Trim(pod.CgroupPath, "/"), "/"); i > 0; i-- {
+		dir = filepath.Dir(dir)
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func (p *protector) file() string {
+	return "memory." + p.cfg.Mode
+}
+
+// protection returns the memory protected for the pod, which is set by annotation or a percentage of
+// the memory requests for online pods, and 0 for offline pods
+func (p *protector) protection(pod *typedef.PodInfo) int64 {
+	if pod.Offline {
+		return 0
+	}
+	if pod.MemoryProtection != constant.InvalidMemoryProtection {
+		return pod.MemoryProtection
+	}
+	return int64(float64(pod.MemoryRequest) * p.cfg.RequestPercent / percent)
+}
+
+// apply checks all cgroups protected or to protect, which is done when all pods are synced or the
+// config changes, the corrections of the pod cgroups are returned
+func (p *protector) apply() []services.Correction {
+	dirs := make([]string, 0, len(p.targets)+len(p.dirs))
+	for dir := range p.targets {
+		dirs = append(dirs, dir)
+	}
+	for dir := range p.dirs {
+		if _, ok := p.targets[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+	}
+	return p.applyDirs(dirs)
+}
+
+// applyDirs writes the protection of the cgroups differing from the targets and clears the parents no longer
+// protected among dirs, the parents protect the sum of the pods under them since the protection of a cgroup
+// is bounded by its parent. The corrections of the pod cgroups are returned
+func (p *protector) applyDirs(dirs []string) []services.Correction {
+	file, ok := cgroup.FileName(p.file())
+	if !ok {
+		return nil
+	}
+
+	var corrections []services.Correction
+	checked := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+		value, ok := p.targets[dir]
+		if !ok {
+			p.clear(dir, file)
+			continue
+		}
+		actual, err := cgroup.ReadInt64(dir, file)
+		if err != nil {
+			// the pod cgroup may be not created yet or removed already
+			log.Debugf("read %s of %s failed: %v", file, dir, err)
+			continue
+		}
+		if actual == value {
+			p.dirs[dir] = p.podOf[dir] != nil
+			continue
+		}
+		if err := write(dir, file, value, "memory protection"); err != nil {
+			log.Errorf("set memory protection failed: %v", err)
+			continue
+		}
+		p.dirs[dir] = p.podOf[dir] != nil
+		if pod := p.podOf[dir]; pod != nil {
+			path, _ := cgroup.Join(dir, file)
+			corrections = append(corrections, services.Correction{Pod: pod, File: path,
+				Actual: typedef.FormatInt64(actual), Desired: typedef.FormatInt64(value)})
+		}
+	}
+
+	return corrections
+}
+
+// clear clears the protection of the parent no longer protecting any pod
+func (p *protector) clear(dir, file string) {
+	isPod, ok := p.dirs[dir]
+	if !ok {
+		return
+	}
+	delete(p.dirs, dir)
+	// the cgroups of the pods deleted are removed along with the pods
+	if isPod {
+		return
+	}
+	if err := write(dir, file, 0, "memory protection cleared"); err != nil {
+		log.Errorf("clear memory protection failed: %v", err)
+	}
+}
+
+func write(dir, file string, value int64, reason string) error {
+	path, err := cgroup.Join(dir, file)
+	if err != nil {
+		return err
+	}
+	if err := journal.WriteFile(moduleName, path, typedef.FormatInt64(value), reason); err != nil {
+		return errors.Errorf("write %d to %s failed: %v", value, path, err)
+	}
+	log.Debugf("set %s to %d", path, value)
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-12
+// Description: memory protection test
+
+package memprotect
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/typedef"
+)
+
+const gi = 1 << 30
+
+// TestValidateConfig tests the mode, the percentage and the kernel support are checked
+func TestValidateConfig(t *testing.T) {
+	defer capability.SetReport(capability.SetReport(nil))
+	assert.NoError(t, ValidateConfig(config.ProtectionConfig{Mode: ModeNone}))
+	assert.NoError(t, ValidateConfig(config.ProtectionConfig{Mode: ModeMin, RequestPercent: 50}))
+	assert.Error(t, ValidateConfig(config.ProtectionConfig{Mode: "max", RequestPercent: 50}))
+	assert.Error(t, ValidateConfig(config.ProtectionConfig{Mode: ModeLow, RequestPercent: 101}))
+
+	capability.SetReport(map[string]bool{capability.MemoryLow: false})
+	assert.NoError(t, ValidateConfig(config.ProtectionConfig{Mode: ModeNone}))
+	assert.Error(t, ValidateConfig(config.ProtectionConfig{Mode: ModeLow, RequestPercent: 100}))
+}
+
+// TestService tests the pod cgroups and their parents are protected as pods come and go
+func TestService(t *testing.T) {
+	fake := cgroup.NewFake()
+	defer cgroup.SetBackend(cgroup.SetBackend(fake))
+	defer cgroup.SetMode(cgroup.SetMode(cgroup.ModeV2))
+	assert.NoError(t, journal.Init(""))
+	defer journal.Restore(moduleName)
+
+	const root = constant.DefaultCgroupRoot
+	pods := map[string]*typedef.PodInfo{
+		"guaranteed": {UID: "guaranteed", CgroupPath: "kubepods/podguaranteed", MemoryRequest: 2 * gi,
+			MemoryProtection: constant.InvalidMemoryProtection},
+		"burstable": {UID: "burstable", CgroupPath: "kubepods/burstable/podburstable", MemoryRequest: 2 * gi,
+			MemoryProtection: gi},
+		"offline": {UID: "offline", CgroupPath: "kubepods/besteffort/podoffline", Offline: true,
+			MemoryRequest: 4 * gi, MemoryProtection: constant.InvalidMemoryProtection},
+	}
+	for _, dir := range []string{"kubepods", "kubepods/burstable", "kubepods/besteffort", "kubepods/podguaranteed",
+		"kubepods/burstable/podburstable", "kubepods/besteffort/podoffline"} {
+		fake.Set(filepath.Join(root, dir, "memory.low"), "0")
+		fake.Set(filepath.Join(root, dir, "memory.min"), "0")
+	}
+	value := func(dir, file string) string {
+		v, _ := fake.Get(filepath.Join(root, dir, file))
+		return v
+	}
+
+	cfg := &config.Config{MemCfg: config.MemoryConfig{Enable: true,
+		Protection: config.ProtectionConfig{Mode: ModeLow, RequestPercent: 50}}}
+	s := &memProtectService{}
+	assert.True(t, s.Enabled(cfg))
+	assert.NoError(t, s.Init(cfg, nil))
+	assert.NoError(t, s.Sync(pods))
+	// half of the requests, or the annotation, and nothing for offline pods
+	assert.Equal(t, "1073741824", value("kubepods/podguaranteed", "memory.low"))
+	assert.Equal(t, "1073741824", value("kubepods/burstable/podburstable", "memory.low"))
+	assert.Equal(t, "0", value("kubepods/besteffort/podoffline", "memory.low"))
+	assert.Equal(t, "1073741824", value("kubepods/burstable", "memory.low"))
+	assert.Equal(t, "2147483648", value("kubepods", "memory.low"))
+
+	// the parents are lowered when the pods are deleted, and cleared when nothing is left under them
+	burstable := pods["burstable"]
+	assert.NoError(t, s.PodDeleted(burstable))
+	assert.Equal(t, "1073741824", value("kubepods", "memory.low"))
+	assert.Equal(t, "0", value("kubepods/burstable", "memory.low"))
+
+	// the protection follows the annotation changed
+	assert.NoError(t, s.PodAdded(burstable))
+	updated := burstable.Clone()
+	updated.MemoryProtection = 3 * gi
+	assert.NoError(t, s.PodUpdated(burstable, updated))
+	assert.Equal(t, "3221225472", value("kubepods/burstable/podburstable", "memory.low"))
+	assert.Equal(t, "4294967296", value("kubepods", "memory.low"))
+	pods["burstable"] = updated
+
+	// the drifted pod cgroups are corrected
+	fake.Set(filepath.Join(root, "kubepods/podguaranteed", "memory.low"), "0")
+	// a pod event only checks the cgroups of the pod and its parents
+	assert.NoError(t, s.PodAdded(updated))
+	assert.Equal(t, "0", value("kubepods/podguaranteed", "memory.low"))
+	corrections, err := s.Reconcile(pods)
+	assert.NoError(t, err)
+	if assert.Len(t, corrections, 1) {
+		assert.Equal(t, "guaranteed", corrections[0].Pod.UID)
+		assert.Equal(t, "0", corrections[0].Actual)
+		assert.Equal(t, "1073741824", corrections[0].Desired)
+	}
+
+	// memory.low is restored when the mode is switched to min
+	cfg.MemCfg.Protection = config.ProtectionConfig{Mode: ModeMin, RequestPercent: 100}
+	assert.NoError(t, s.Reload(cfg))
+	assert.Equal(t, "0", value("kubepods", "memory.low"))
+	assert.Equal(t, "0", value("kubepods/podguaranteed", "memory.low"))
+	assert.Equal(t, "2147483648", value("kubepods/podguaranteed", "memory.min"))
+	assert.Equal(t, "5368709120", value("kubepods", "memory.min"))
+
+	cfg.MemCfg.Protection.Mode = ModeNone
+	assert.False(t, s.Enabled(cfg))
+}
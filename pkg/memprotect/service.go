@@ -0,0 +1,138 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-12
+// Description: memory protection service registration
+
+package memprotect
+
+import (
+	"sync"
+
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+type memProtectService struct {
+	sync.Mutex
+	p *protector
+}
+
+func init() {
+	services.Register(&memProtectService{})
+}
+
+// Name returns the service name
+func (s *memProtectService) Name() string {
+	return moduleName
+}
+
+// Enabled tells whether memory management is enabled with a protection mode in config
+func (s *memProtectService) Enabled(cfg *config.Config) bool {
+	return cfg.MemCfg.Enable && cfg.MemCfg.Protection.Mode != ModeNone
+}
+
+// Init checks the config and protects the pods synced later
+func (s *memProtectService) Init(cfg *config.Config, cpm *checkpoint.Manager) error {
+	if err := ValidateConfig(cfg.MemCfg.Protection); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.p = newProtector(cfg.MemCfg.Protection)
+	log.Infof("protect memory of online pods with memory.%s", cfg.MemCfg.Protection.Mode)
+	return nil
+}
+
+// Validate checks the new protection config
+func (s *memProtectService) Validate(cfg *config.Config) error {
+	return ValidateConfig(cfg.MemCfg.Protection)
+}
+
+// Reload applies the new protection to the pods, the protection of the old mode is restored
+// before the new mode is applied
+func (s *memProtectService) Reload(cfg *config.Config) error {
+	s.Lock()
+	defer s.Unlock()
+	newCfg := cfg.MemCfg.Protection
+	if s.p.cfg == newCfg {
+		return nil
+	}
+	if s.p.cfg.Mode != newCfg.Mode {
+		if err := journal.Restore(moduleName); err != nil {
+			log.Errorf("restore memory.%s failed: %v", s.p.cfg.Mode, err)
+		}
+		s.p.dirs = make(map[string]bool)
+	}
+	s.p.cfg = newCfg
+	// the protection of the online pods depends on requestPercent
+	s.p.setPods(s.p.pods)
+	s.p.apply()
+	return nil
+}
+
+// PodAdded protects the new pod and updates the protection of its parents
+func (s *memProtectService) PodAdded(pod *typedef.PodInfo) error {
+	s.Lock()
+	defer s.Unlock()
+	s.p.applyDirs(s.p.setPod(pod))
+	return nil
+}
+
+// PodUpdated updates the protection if the pod is switched between online and offline, or its
+// memory requests or annotation changes
+func (s *memProtectService) PodUpdated(old, new *typedef.PodInfo) error {
+	if old.Offline == new.Offline && old.MemoryRequest == new.MemoryRequest &&
+		old.MemoryProtection == new.MemoryProtection && old.CgroupPath == new.CgroupPath {
+		s.Lock()
+		defer s.Unlock()
+		s.p.setPod(new)
+		return nil
+	}
+	return s.PodAdded(new)
+}
+
+// PodDeleted lowers the protection of the parents of the pod deleted
+func (s *memProtectService) PodDeleted(pod *typedef.PodInfo) error {
+	s.Lock()
+	defer s.Unlock()
+	s.p.applyDirs(s.p.removePod(pod.UID))
+	return nil
+}
+
+// Sync protects all pods
+func (s *memProtectService) Sync(pods map[string]*typedef.PodInfo) error {
+	s.Lock()
+	defer s.Unlock()
+	s.p.setPods(pods)
+	s.p.apply()
+	return nil
+}
+
+// Reconcile sets the protection of the pod cgroups and their parents drifted again, the pods passed
+// may be part of the pods of the node, which are updated only
+func (s *memProtectService) Reconcile(pods map[string]*typedef.PodInfo) ([]services.Correction, error) {
+	s.Lock()
+	defer s.Unlock()
+	var dirs []string
+	for _, pod := range pods {
+		dirs = append(dirs, s.p.setPod(pod)...)
+	}
+	return s.p.applyDirs(dirs), nil
+}
+
+// Shutdown stops the service
+func (s *memProtectService) Shutdown() error {
+	return nil
+}
@@ -0,0 +1,271 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-30
+// Description: metrics registry in prometheus text format
+
+// Package metrics collects the decisions made and the signals sampled by modules
+// and exposes them in prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"isula.org/rubik/pkg/typedef"
+)
+
+const (
+	gaugeType   = "gauge"
+	counterType = "counter"
+
+	// labelSep joins label values to the key of a series, which never appears in label values
+	labelSep = "\xff"
+
+	// LabelModule is the label of the module making the decision
+	LabelModule = "module"
+	// LabelNamespace is the label of the pod namespace
+	LabelNamespace = "namespace"
+	// LabelPod is the label of the pod name
+	LabelPod = "pod"
+)
+
+// WriteFailures counts the cgroup writes failed for pods, labelled by module, namespace and pod
+var WriteFailures = NewCounter("rubik_write_failures_total",
+	"Number of cgroup writes failed for pods.", LabelModule, LabelNamespace, LabelPod)
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]*Vec)
+)
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// Vec is a metric family whose series are told apart by label values
+type Vec struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	// fn provides the value of a family without labels when it is sampled on scraping
+	fn func() float64
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+// NewGauge registers a gauge family with the label names
+func NewGauge(name, help string, labelNames ...string) *Vec {
+	return register(&Vec{name: name, help: help, typ: gaugeType, labelNames: labelNames})
+}
+
+// NewCounter registers a counter family with the label names
+func NewCounter(name, help string, labelNames ...string) *Vec {
+	return register(&Vec{name: name, help: help, typ: counterType, labelNames: labelNames})
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is got from fn on scraping
+func NewGaugeFunc(name, help string, fn func() float64) *Vec {
+	return register(&Vec{name: name, help: help, typ: gaugeType, fn: fn})
+}
+
+// register adds the family to the registry, families are registered at package initialization,
+// so that a duplicate name is a programming error
+func register(v *Vec) *Vec {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[v.name]; ok {
+		panic("metric " + v.name + " registered twice")
+	}
+	v.series = make(map[string]*series)
+	registry[v.name] = v
+	return v
+}
+
+// Set sets the value of the series with the label values
+func (v *Vec) Set(value float64, labelValues ...string) {
+	v.update(labelValues, func(s *series) { s.value = value })
+}
+
+// Add adds delta to the value of the series with the label values, the series starts from 0
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	v.update(labelValues, func(s *series) { s.value += delta })
+}
+
+// Inc increases the value of the series with the label values by 1
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+// SetState sets the series of state to 1 and the series of the other states to 0, the last label
+// is the state, which shows a state machine in the way of prometheus state set
+func (v *Vec) SetState(state string, states []string, labelValues ...string) {
+	for _, st := range states {
+		value := 0.0
+		if st == state {
+			value = 1
+		}
+		v.Set(value, append(append([]string{}, labelValues...), st)...)
+	}
+}
+
+func (v *Vec) update(labelValues []string, fn func(*series)) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSep)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+	fn(s)
+}
+
+// Delete removes the series with the label values
+func (v *Vec) Delete(labelValues ...string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	delete(v.series, strings.Join(labelValues, labelSep))
+}
+
+// Reset removes all series of the family
+func (v *Vec) Reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.series = make(map[string]*series)
+}
+
+// deleteMatch removes the series whose labels have the values in match
+func (v *Vec) deleteMatch(match map[string]string) {
+	var idx []int
+	for i, name := range v.labelNames {
+		if _, ok := match[name]; ok {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) != len(match) {
+		return
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for key, s := range v.series {
+		matched := true
+		for _, i := range idx {
+			if s.labelValues[i] != match[v.labelNames[i]] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			delete(v.series, key)
+		}
+	}
+}
+
+// DeletePod removes the series of the pod from all families, which is called when the pod is deleted
+// so that the series of pods gone do not pile up
+func DeletePod(pod *typedef.PodInfo) {
+	match := map[string]string{LabelNamespace: pod.Namespace, LabelPod: pod.Name}
+	for _, v := range list() {
+		v.deleteMatch(match)
+	}
+}
+
+func list() []*Vec {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	vecs := make([]*Vec, 0, len(registry))
+	for _, v := range registry {
+		vecs = append(vecs, v)
+	}
+	sort.Slice(vecs, func(i, j int) bool { return vecs[i].name < vecs[j].name })
+	return vecs
+}
+
+// WriteText writes all families in prometheus text format, families without any series are skipped
+func WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, v := range list() {
+		v.writeText(bw)
+	}
+	return bw.Flush()
+}
+
+func (v *Vec) writeText(w *bufio.Writer) {
+	var lines []string
+	if v.fn != nil {
+		lines = append(lines, v.name+" "+formatValue(v.fn()))
+	} else {
+		v.lock.Lock()
+		for _, s := range v.series {
+			lines = append(lines, v.name+formatLabels(v.labelNames, s.labelValues)+" "+formatValue(s.value))
+		}
+		v.lock.Unlock()
+	}
+	if len(lines) == 0 {
+		return
+	}
+	sort.Strings(lines)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=\"" + escapeLabel(values[i]) + "\""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,141 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-30
+// Description: metrics test
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/try"
+	"isula.org/rubik/pkg/typedef"
+)
+
+var (
+	testGauge = NewGauge("rubik_test_gauge", "Test gauge.", LabelNamespace, LabelPod)
+	testState = NewGauge("rubik_test_state", "Test\nstate.", "state")
+	testFunc  = NewGaugeFunc("rubik_test_func", "Test gauge func.", func() float64 { return 3 })
+)
+
+func writeString(t *testing.T) string {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteText(&buf))
+	return buf.String()
+}
+
+// TestWriteText tests families are written in prometheus text format
+func TestWriteText(t *testing.T) {
+	defer testGauge.Reset()
+	defer testState.Reset()
+	defer WriteFailures.Reset()
+
+	testGauge.Set(1.5, "default", "pod1")
+	testGauge.Set(2, "kube-system", `a"b\c`)
+	WriteFailures.Inc("qos", "default", "pod1")
+	WriteFailures.Add(2, "qos", "default", "pod1")
+	testState.SetState("mid", []string{"low", "mid", "high"})
+
+	text := writeString(t)
+	assert.Contains(t, text, "# HELP rubik_test_gauge Test gauge.\n# TYPE rubik_test_gauge gauge\n"+
+		"rubik_test_gauge{namespace=\"default\",pod=\"pod1\"} 1.5\n"+
+		"rubik_test_gauge{namespace=\"kube-system\",pod=\"a\\\"b\\\\c\"} 2\n")
+	assert.Contains(t, text, "# TYPE rubik_write_failures_total counter\n"+
+		"rubik_write_failures_total{module=\"qos\",namespace=\"default\",pod=\"pod1\"} 3\n")
+	assert.Contains(t, text, "# HELP rubik_test_state Test\\nstate.\n# TYPE rubik_test_state gauge\n"+
+		"rubik_test_state{state=\"high\"} 0\nrubik_test_state{state=\"low\"} 0\nrubik_test_state{state=\"mid\"} 1\n")
+	assert.Contains(t, text, "# TYPE rubik_test_func gauge\nrubik_test_func 3\n")
+
+	testState.SetState("high", []string{"low", "mid", "high"})
+	assert.Contains(t, writeString(t), "rubik_test_state{state=\"high\"} 1\n")
+
+	testGauge.Delete("default", "pod1")
+	assert.NotContains(t, writeString(t), "pod=\"pod1\"} 1.5")
+
+	testGauge.Reset()
+	assert.NotContains(t, writeString(t), "rubik_test_gauge")
+
+	assert.Panics(t, func() { testGauge.Set(1, "default") })
+	assert.Panics(t, func() { NewGauge("rubik_test_gauge", "Duplicate.") })
+}
+
+// TestDeletePod tests series of the pod deleted are removed from all families
+func TestDeletePod(t *testing.T) {
+	defer testGauge.Reset()
+	defer WriteFailures.Reset()
+
+	testGauge.Set(1, "default", "pod1")
+	testGauge.Set(1, "default", "pod2")
+	WriteFailures.Inc("blkio", "default", "pod1")
+	testState.SetState("low", []string{"low"})
+	DeletePod(&typedef.PodInfo{Namespace: "default", Name: "pod1"})
+
+	text := writeString(t)
+	assert.NotContains(t, text, "pod=\"pod1\"")
+	assert.Contains(t, text, "rubik_test_gauge{namespace=\"default\",pod=\"pod2\"} 1\n")
+	assert.Contains(t, text, "rubik_test_state{state=\"low\"} 1\n")
+}
+
+// TestValidateAddr tests the metrics address check
+func TestValidateAddr(t *testing.T) {
+	for _, addr := range []string{"", "127.0.0.1:9100", ":9100", "unix:/run/rubik/metrics.sock"} {
+		assert.NoError(t, ValidateAddr(addr), addr)
+	}
+	for _, addr := range []string{"9100", "unix:metrics.sock", "localhost"} {
+		assert.Error(t, ValidateAddr(addr), addr)
+	}
+}
+
+// TestServer tests metrics are served on unix socket
+func TestServer(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	defer testGauge.Reset()
+	sock := filepath.Join(constant.TmpTestDir, "metrics", "metrics.sock")
+	addr := "unix:" + sock
+
+	server, listener, err := NewServer(addr)
+	assert.NoError(t, err)
+	go server.Serve(listener)
+	defer Shutdown(server, addr)
+	testGauge.Set(1, "default", "pod1")
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://localhost/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "rubik_test_gauge{namespace=\"default\",pod=\"pod1\"} 1\n")
+
+	resp, err = client.Post("http://localhost/metrics", "text/plain", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	_, _, err = NewServer("unix:metrics.sock")
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-30
+// Description: metrics http server listening on tcp port or unix socket
+
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/constant"
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	unixPrefix  = "unix:"
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// ValidateAddr checks the metrics address, which is empty for disabled, host:port for tcp
+// or unix:<absolute path> for unix socket
+func ValidateAddr(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	if strings.HasPrefix(addr, unixPrefix) {
+		if path := strings.TrimPrefix(addr, unixPrefix); !filepath.IsAbs(path) {
+			return errors.Errorf("metrics socket %q is not an absolute path", path)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return errors.Errorf("invalid metrics address %q: %v", addr, err)
+	}
+	return nil
+}
+
+// NewServer creates the metrics http server and the listener on addr
+func NewServer(addr string) (*http.Server, net.Listener, error) {
+	if err := ValidateAddr(addr); err != nil {
+		return nil, nil, err
+	}
+	listener, err := listen(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  constant.ReadTimeout,
+		WriteTimeout: constant.WriteTimeout,
+	}
+	return server, listener, nil
+}
+
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, errors.Errorf("listen on %s failed: %v", addr, err)
+		}
+		return listener, nil
+	}
+
+	sock := strings.TrimPrefix(addr, unixPrefix)
+	if err := os.MkdirAll(filepath.Dir(sock), constant.DefaultDirMode); err != nil {
+		return nil, errors.Errorf("create socket dir failed: %v", err)
+	}
+	if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Errorf("remove stale socket failed: %v", err)
+	}
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, errors.Errorf("listen on %s failed: %v", sock, err)
+	}
+	if err := os.Chmod(sock, constant.DefaultFileMode); err != nil {
+		log.DropError(listener.Close())
+		return nil, errors.Errorf("chmod %s failed: %v", sock, err)
+	}
+	return listener, nil
+}
+
+// Shutdown stops the metrics http server gracefully and removes the unix socket if any
+func Shutdown(server *http.Server, addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), constant.WriteTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Errorf("shutdown metrics server failed: %v", err)
+	}
+	if strings.HasPrefix(addr, unixPrefix) {
+		log.DropError(os.Remove(strings.TrimPrefix(addr, unixPrefix)))
+	}
+}
+
+// Handler serves all metrics in prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		if err := WriteText(w); err != nil {
+			log.Errorf("write metrics failed: %v", err)
+		}
+	})
+}
@@ -24,7 +24,7 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 
-	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/constant"
 	log "isula.org/rubik/pkg/tinylog"
 )
@@ -278,8 +278,16 @@ func CgroupStat(cgpath string, dur time.Duration) (*PerfStat, error) {
 }
 
 func init() {
-	_, err := CgroupStat(filepath.Join(config.CgroupRoot, "perf_event", constant.KubepodsCgroup), time.Millisecond)
-	if err == nil {
-		hwSupport = true
+	// the hierarchy mode and cgroup driver are not initialized yet when packages are initialized
+	root := filepath.Join(constant.DefaultCgroupRoot, "perf_event")
+	if cgroup.DetectMode(constant.DefaultCgroupRoot) == cgroup.ModeV2 {
+		root = constant.DefaultCgroupRoot
+	}
+	// kubepods is named kubepods.slice by systemd cgroup driver
+	for _, name := range []string{constant.KubepodsCgroup, constant.KubepodsCgroup + ".slice"} {
+		if _, err := CgroupStat(filepath.Join(root, name), time.Millisecond); err == nil {
+			hwSupport = true
+			return
+		}
 	}
 }
@@ -0,0 +1,253 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-05-25
+// Description: offline classification and feature settings of pods
+
+// Package podpolicy classifies pods as offline or online and reads the feature settings of pods
+// from the annotations and labels configured
+package podpolicy
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/events"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// policy is the parsed PolicyConfig
+type policy struct {
+	cfg             config.PolicyConfig
+	values          map[string]bool
+	priorityClasses map[string]bool
+	namespaces      map[string]bool
+	// selector is nil if no label selector is configured
+	selector labels.Selector
+}
+
+var (
+	policyLock sync.RWMutex
+	current    = mustParse(config.DefaultPolicyConfig())
+)
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, i := range items {
+		set[i] = true
+	}
+	return set
+}
+
+func parse(cfg config.PolicyConfig) (*policy, error) {
+	if cfg.OfflineAnnotation == "" && cfg.OfflineLabel == "" && len(cfg.OfflinePriorityClasses) == 0 &&
+		len(cfg.OfflineNamespaces) == 0 && cfg.OfflineSelector == "" {
+		return nil, errors.Errorf("no offline classification configured in podPolicy")
+	}
+	if (cfg.OfflineAnnotation != "" || cfg.OfflineLabel != "") && len(cfg.OfflineValues) == 0 {
+		return nil, errors.Errorf("offlineValues of podPolicy is empty")
+	}
+	p := &policy{
+		cfg:             cfg,
+		values:          toSet(cfg.OfflineValues),
+		priorityClasses: toSet(cfg.OfflinePriorityClasses),
+		namespaces:      toSet(cfg.OfflineNamespaces),
+	}
+	if cfg.OfflineSelector != "" {
+		s, err := labels.Parse(cfg.OfflineSelector)
+		if err != nil {
+			return nil, errors.Errorf("invalid offlineSelector %q: %v", cfg.OfflineSelector, err)
+		}
+		p.selector = s
+	}
+	return p, nil
+}
+
+func mustParse(cfg config.PolicyConfig) *policy {
+	p, err := parse(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Validate checks the pod policy
+func Validate(cfg config.PolicyConfig) error {
+	_, err := parse(cfg)
+	return err
+}
+
+// Init sets the pod policy used to classify pods and read their feature settings
+func Init(cfg config.PolicyConfig) error {
+	p, err := parse(cfg)
+	if err != nil {
+		return err
+	}
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	current = p
+	return nil
+}
+
+func getPolicy() *policy {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	return current
+}
+
+// lookup returns the value of key in the annotations of pod, and then in the labels
+func lookup(pod *corev1.Pod, key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	if v, ok := pod.Annotations[key]; ok {
+		return v, true
+	}
+	v, ok := pod.Labels[key]
+	return v, ok
+}
+
+// IsOffline judges whether pod is offline pod, the sources are checked in the order of the annotation,
+// the label, the priority class, the namespace and the label selector, the first matching one decides.
+// The offline annotation and label decide whether the pod is offline by their values if they are present,
+// so that a pod can be marked online explicitly in the offline namespaces
+func IsOffline(pod *corev1.Pod) bool {
+	p := getPolicy()
+	if p.cfg.OfflineAnnotation != "" {
+		if v, ok := pod.Annotations[p.cfg.OfflineAnnotation]; ok {
+			return p.values[v]
+		}
+	}
+	if p.cfg.OfflineLabel != "" {
+		if v, ok := pod.Labels[p.cfg.OfflineLabel]; ok {
+			return p.values[v]
+		}
+	}
+	if p.priorityClasses[pod.Spec.PriorityClassName] || p.namespaces[pod.Namespace] {
+		return true
+	}
+	return p.selector != nil && p.selector.Matches(labels.Set(pod.Labels))
+}
+
+// GetQosLevel returns the qos level of the pod set by annotation, which is negative for offline pods and
+// non-negative for online pods, the default levels -1 and 0 are used if it is missing or mismatches the class
+func GetQosLevel(pod *corev1.Pod, offline bool) int {
+	level := constant.MaxLevel.Int()
+	if offline {
+		level = constant.MinLevel.Int()
+	}
+	value, ok := lookup(pod, getPolicy().cfg.QosLevelKey)
+	if !ok || value == "" {
+		return level
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || (v < constant.MaxLevel.Int()) != offline {
+		log.Errorf("pod %s qos level annotation value %v is invalid, expect integer %s for offline=%v",
+			pod.Name, value, levelClass(offline), offline)
+		events.Warningf(pod, events.ReasonInvalidQosLevelAnnotation,
+			"Invalid qos level annotation %q, expect integer %s", value, levelClass(offline))
+		return level
+	}
+	return v
+}
+
+func levelClass(offline bool) string {
+	if offline {
+		return "below 0 for offline pod"
+	}
+	return "not below 0 for online pod"
+}
+
+// GetPodCacheLimit returns cache limit level annotation of pod
+func GetPodCacheLimit(pod *corev1.Pod) string {
+	v, _ := lookup(pod, getPolicy().cfg.CacheLimitKey)
+	return v
+}
+
+// GetPodBlkioLimit returns blkio limit annotation of pod
+func GetPodBlkioLimit(pod *corev1.Pod) string {
+	v, _ := lookup(pod, getPolicy().cfg.BlkioKey)
+	return v
+}
+
+// GetQuotaBurst checks CPU quota burst annotation value.
+func GetQuotaBurst(pod *corev1.Pod) int64 {
+	quota, _ := lookup(pod, getPolicy().cfg.QuotaBurstKey)
+	if quota == "" {
+		return constant.InvalidBurst
+	}
+
+	quotaBurst, err := typedef.ParseInt64(quota)
+	if err != nil {
+		log.Errorf("pod %s burst quota annotation value %v is invalid, expect integer", pod.Name, quota)
+		events.Warningf(pod, events.ReasonInvalidQuotaBurstAnnotation,
+			"Invalid quota burst annotation %q, expect integer", quota)
+		return constant.InvalidBurst
+	}
+	if quotaBurst < 0 {
+		log.Errorf("pod %s burst quota annotation value %v is invalid, expect positive", pod.Name, quotaBurst)
+		events.Warningf(pod, events.ReasonInvalidQuotaBurstAnnotation,
+			"Invalid quota burst annotation %q, expect non-negative", quota)
+		return constant.InvalidBurst
+	}
+	return quotaBurst
+}
+
+// GetMemoryProtection returns the memory protected for the pod set by annotation in bytes, such as 2Gi,
+// which is capped at the memory limit of the pod, or its requests if it has no limit, since the memory
+// protected is never reclaimed in min mode. constant.InvalidMemoryProtection is returned if it is missing or invalid
+func GetMemoryProtection(pod *corev1.Pod) int64 {
+	value, ok := lookup(pod, getPolicy().cfg.MemoryProtectionKey)
+	if !ok || value == "" {
+		return constant.InvalidMemoryProtection
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil || q.Sign() < 0 {
+		log.Errorf("pod %s memory protection annotation value %v is invalid, expect non-negative quantity",
+			pod.Name, value)
+		events.Warningf(pod, events.ReasonInvalidMemoryProtectionAnnotation,
+			"Invalid memory protection annotation %q, expect non-negative quantity such as 2Gi", value)
+		return constant.InvalidMemoryProtection
+	}
+	if bound := memoryBound(pod); q.Value() > bound {
+		log.Infof("pod %s memory protection %v is capped at %d bytes", pod.Name, value, bound)
+		events.Warningf(pod, events.ReasonMemoryProtectionCapped,
+			"Memory protection annotation %q exceeds the memory limit or requests of the pod, capped at %d bytes",
+			value, bound)
+		return bound
+	}
+	return q.Value()
+}
+
+// memoryBound returns the sum of the memory limits of the containers, or the sum of the requests
+// if any container has no limit
+func memoryBound(pod *corev1.Pod) int64 {
+	var limits, requests int64
+	limited := true
+	for _, c := range pod.Spec.Containers {
+		requests += c.Resources.Requests.Memory().Value()
+		if c.Resources.Limits.Memory().IsZero() {
+			limited = false
+		}
+		limits += c.Resources.Limits.Memory().Value()
+	}
+	if limited && len(pod.Spec.Containers) != 0 {
+		return limits
+	}
+	return requests
+}
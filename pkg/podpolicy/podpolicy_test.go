@@ -0,0 +1,268 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Jingxiao Lu
+// Create: 2022-05-25
+// Description: tests for pod.go
+
+package podpolicy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+)
+
+const (
+	trueStr = "true"
+)
+
+func TestIsOffline(t *testing.T) {
+	var pod = &corev1.Pod{}
+	pod.Annotations = make(map[string]string)
+	pod.Annotations[constant.PriorityAnnotationKey] = trueStr
+	if !IsOffline(pod) {
+		t.Fatalf("%s failed for Annotations is %s", t.Name(), trueStr)
+	}
+
+	delete(pod.Annotations, constant.PriorityAnnotationKey)
+	if IsOffline(pod) {
+		t.Fatalf("%s failed for Annotations no such key", t.Name())
+	}
+}
+
+// TestGetQuotaBurst is testcase for GetQuotaBurst
+func TestGetQuotaBurst(t *testing.T) {
+	pod := &corev1.Pod{}
+	pod.Annotations = make(map[string]string)
+	maxInt64PlusOne := "9223372036854775808"
+	tests := []struct {
+		name       string
+		quotaBurst string
+		want       int64
+	}{
+		{
+			name:       "TC1-valid quota burst",
+			quotaBurst: "1",
+			want:       1,
+		},
+		{
+			name:       "TC2-empty quota burst",
+			quotaBurst: "",
+			want:       -1,
+		},
+		{
+			name:       "TC3-zero quota burst",
+			quotaBurst: "0",
+			want:       0,
+		},
+		{
+			name:       "TC4-negative quota burst",
+			quotaBurst: "-100",
+			want:       -1,
+		},
+		{
+			name:       "TC5-float quota burst",
+			quotaBurst: "100.34",
+			want:       -1,
+		},
+		{
+			name:       "TC6-nonnumerical quota burst",
+			quotaBurst: "nonnumerical",
+			want:       -1,
+		},
+		{
+			name:       "TC7-exceed max int64",
+			quotaBurst: maxInt64PlusOne,
+			want:       -1,
+		},
+	}
+	for _, tt := range tests {
+		pod.Annotations[constant.QuotaBurstAnnotationKey] = tt.quotaBurst
+		assert.Equal(t, GetQuotaBurst(pod), tt.want)
+	}
+}
+
+func newPod(ns string, annotations, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Annotations: annotations, Labels: labels}}
+}
+
+// TestPolicyIsOffline tests the precedence of the offline classification sources
+func TestPolicyIsOffline(t *testing.T) {
+	assert.NoError(t, Init(config.PolicyConfig{
+		OfflineAnnotation:      "example.com/offline",
+		OfflineLabel:           "example.com/tier",
+		OfflineValues:          []string{"true", "batch"},
+		OfflinePriorityClasses: []string{"low-priority"},
+		OfflineNamespaces:      []string{"batch"},
+		OfflineSelector:        "app in (spark, flink)",
+	}))
+	defer Init(config.DefaultPolicyConfig())
+
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want bool
+	}{
+		{
+			name: "TC1-annotation marks offline",
+			pod:  newPod("default", map[string]string{"example.com/offline": "true"}, nil),
+			want: true,
+		},
+		{
+			name: "TC2-annotation marks online in offline namespace",
+			pod: newPod("batch", map[string]string{"example.com/offline": "false"},
+				map[string]string{"example.com/tier": "batch"}),
+			want: false,
+		},
+		{
+			name: "TC3-label marks offline",
+			pod:  newPod("default", nil, map[string]string{"example.com/tier": "batch"}),
+			want: true,
+		},
+		{
+			name: "TC4-label marks online matching selector",
+			pod:  newPod("default", nil, map[string]string{"example.com/tier": "web", "app": "spark"}),
+			want: false,
+		},
+		{
+			name: "TC5-offline namespace",
+			pod:  newPod("batch", nil, nil),
+			want: true,
+		},
+		{
+			name: "TC6-selector matches",
+			pod:  newPod("default", nil, map[string]string{"app": "flink"}),
+			want: true,
+		},
+		{
+			name: "TC7-no source matches",
+			pod:  newPod("default", nil, map[string]string{"app": "nginx"}),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsOffline(tt.pod))
+		})
+	}
+
+	pod := newPod("default", nil, nil)
+	pod.Spec.PriorityClassName = "low-priority"
+	assert.True(t, IsOffline(pod))
+	// the volcano annotation is not used any more
+	assert.False(t, IsOffline(newPod("default", map[string]string{constant.PriorityAnnotationKey: trueStr}, nil)))
+}
+
+// TestPolicyKeys tests the feature settings are read from the keys configured
+func TestPolicyKeys(t *testing.T) {
+	assert.NoError(t, Init(config.PolicyConfig{
+		OfflineNamespaces: []string{"batch"},
+		CacheLimitKey:     "example.com/cache-limit",
+		QuotaBurstKey:     "example.com/quota-burst",
+		BlkioKey:          "example.com/blkio",
+	}))
+	defer Init(config.DefaultPolicyConfig())
+
+	pod := newPod("default", map[string]string{"example.com/cache-limit": "low", "example.com/quota-burst": "10"},
+		map[string]string{"example.com/blkio": "{}", "example.com/cache-limit": "high"})
+	assert.Equal(t, "low", GetPodCacheLimit(pod))
+	assert.Equal(t, int64(10), GetQuotaBurst(pod))
+	assert.Equal(t, "{}", GetPodBlkioLimit(pod))
+
+	pod = newPod("default", map[string]string{constant.CacheLimitAnnotationKey: "low"}, nil)
+	assert.Equal(t, "", GetPodCacheLimit(pod))
+	assert.Equal(t, int64(constant.InvalidBurst), GetQuotaBurst(pod))
+}
+
+// TestValidate tests the pod policy check
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(config.DefaultPolicyConfig()))
+	for _, cfg := range []config.PolicyConfig{
+		{},
+		{OfflineAnnotation: "example.com/offline"},
+		{OfflineSelector: "app in (spark"},
+	} {
+		assert.Error(t, Validate(cfg))
+		assert.Error(t, Init(cfg))
+	}
+}
+
+// TestGetQosLevel tests the qos level annotation is accepted within the class of the pod
+func TestGetQosLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		offline bool
+		want    int
+	}{
+		{name: "TC1-online default", want: 0},
+		{name: "TC2-offline default", offline: true, want: -1},
+		{name: "TC3-online high level", value: "2", want: 2},
+		{name: "TC4-offline low level", value: "-2", offline: true, want: -2},
+		{name: "TC5-online with offline level", value: "-1", want: 0},
+		{name: "TC6-offline with online level", value: "1", offline: true, want: -1},
+		{name: "TC7-not integer", value: "high", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newPod("default", map[string]string{}, nil)
+			if tt.value != "" {
+				pod.Annotations[constant.QosLevelAnnotationKey] = tt.value
+			}
+			assert.Equal(t, tt.want, GetQosLevel(pod, tt.offline))
+		})
+	}
+}
+
+// withMemory sets the memory requests and limits of a container of the pod, the limit is not set if empty
+func withMemory(pod *corev1.Pod, request, limit string) *corev1.Pod {
+	c := corev1.Container{Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(request)},
+		Limits:   corev1.ResourceList{},
+	}}
+	if limit != "" {
+		c.Resources.Limits[corev1.ResourceMemory] = resource.MustParse(limit)
+	}
+	pod.Spec.Containers = append(pod.Spec.Containers, c)
+	return pod
+}
+
+// TestGetMemoryProtection tests the memory protection annotation is read as a quantity
+func TestGetMemoryProtection(t *testing.T) {
+	for value, want := range map[string]int64{
+		"2Gi":  2 << 30,
+		"512M": 512000000,
+		"0":    0,
+		"-1Gi": constant.InvalidMemoryProtection,
+		"abc":  constant.InvalidMemoryProtection,
+		"":     constant.InvalidMemoryProtection,
+	} {
+		pod := newPod("default", map[string]string{constant.MemoryProtectionAnnotationKey: value}, nil)
+		assert.Equal(t, want, GetMemoryProtection(withMemory(pod, "1Gi", "4Gi")), value)
+	}
+	assert.Equal(t, int64(constant.InvalidMemoryProtection), GetMemoryProtection(newPod("default", nil, nil)))
+}
+
+// TestMemoryProtectionCapped tests the memory protection is capped at the memory limit, or the requests
+// if any container has no limit
+func TestMemoryProtectionCapped(t *testing.T) {
+	annotations := map[string]string{constant.MemoryProtectionAnnotationKey: "8Gi"}
+	pod := withMemory(withMemory(newPod("default", annotations, nil), "1Gi", "2Gi"), "1Gi", "3Gi")
+	assert.Equal(t, int64(5<<30), GetMemoryProtection(pod))
+	pod = withMemory(withMemory(newPod("default", annotations, nil), "1Gi", "2Gi"), "1Gi", "")
+	assert.Equal(t, int64(2<<30), GetMemoryProtection(pod))
+	assert.Equal(t, int64(0), GetMemoryProtection(newPod("default", annotations, nil)))
+}
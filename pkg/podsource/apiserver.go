@@ -0,0 +1,143 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2021. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2021-07-22
+// Description: pod source of the apiserver informer
+
+package podsource
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	invalidErr = "Auto config error: invalid pod type"
+	// healthTimeout bounds the requests checking whether the source is reachable
+	healthTimeout = 5 * time.Second
+)
+
+// KubeConfig returns the config of the kubeconfig file, or the in-cluster config if kubeconfig is empty
+func KubeConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeconfig)
+}
+
+type apiServerSource struct {
+	client kubernetes.Interface
+	// healthClient has a timeout which the long-running watches of client must not have
+	healthClient kubernetes.Interface
+	nodeName     string
+}
+
+// NewAPIServer creates the source watching the pods of the node through the apiserver
+func NewAPIServer(conf *rest.Config, nodeName string) (Source, error) {
+	client, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	healthConf := rest.CopyConfig(conf)
+	healthConf.Timeout = healthTimeout
+	healthClient, err := kubernetes.NewForConfig(healthConf)
+	if err != nil {
+		return nil, err
+	}
+	return &apiServerSource{client: client, healthClient: healthClient, nodeName: nodeName}, nil
+}
+
+// Name returns the name of the source
+func (s *apiServerSource) Name() string {
+	return APIServer
+}
+
+// List returns the pods of the node
+func (s *apiServerSource) List() ([]corev1.Pod, error) {
+	pods, err := s.healthClient.CoreV1().Pods("").List(context.Background(), s.listOptions())
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// Healthy checks the apiserver is reachable
+func (s *apiServerSource) Healthy() error {
+	_, err := s.healthClient.Discovery().ServerVersion()
+	return err
+}
+
+func (s *apiServerSource) listOptions() metav1.ListOptions {
+	const specNodeNameField = "spec.nodeName"
+	return metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector(specNodeNameField, s.nodeName).String()}
+}
+
+// Watch starts the informer of the pods of the node
+func (s *apiServerSource) Watch(handler EventHandler, stop <-chan struct{}) {
+	const reSyncTime = 30
+	kubeInformerFactory := informers.NewSharedInformerFactoryWithOptions(s.client,
+		time.Duration(reSyncTime)*time.Second,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			// set Options to return only pods on the current node.
+			options.FieldSelector = s.listOptions().FieldSelector
+		}))
+	kubeInformerFactory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { addHandler(handler, obj) },
+		UpdateFunc: func(old, new interface{}) { updateHandler(handler, old, new) },
+		DeleteFunc: func(obj interface{}) { deleteHandler(handler, obj) },
+	})
+	kubeInformerFactory.Start(stop)
+}
+
+func addHandler(handler EventHandler, obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		log.Errorf(invalidErr)
+		return
+	}
+
+	handler.AddEvent(pod)
+}
+
+func updateHandler(handler EventHandler, old, new interface{}) {
+	oldPod, ok1 := old.(*corev1.Pod)
+	newPod, ok2 := new.(*corev1.Pod)
+	if !ok1 || !ok2 {
+		log.Errorf(invalidErr)
+		return
+	}
+
+	handler.UpdateEvent(oldPod, newPod)
+}
+
+func deleteHandler(handler EventHandler, obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		// the pod deleted while the watch is broken comes in a tombstone
+		tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown)
+		if pod, ok = tombstone.Obj.(*corev1.Pod); !isTombstone || !ok {
+			log.Errorf(invalidErr)
+			return
+		}
+	}
+
+	handler.DeleteEvent(pod)
+}
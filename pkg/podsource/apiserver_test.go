@@ -0,0 +1,85 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2021. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2021-07-22
+// Description: apiserver pod source test
+
+package podsource
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"isula.org/rubik/pkg/constant"
+)
+
+const kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: http://127.0.0.1:1
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`
+
+// TestKubeConfig tests the apiserver is accessed by the kubeconfig out of cluster
+func TestKubeConfig(t *testing.T) {
+	_, err := KubeConfig("")
+	assert.Contains(t, err.Error(), "must be defined")
+
+	assert.NoError(t, os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode))
+	defer os.RemoveAll(constant.TmpTestDir)
+	file := filepath.Join(constant.TmpTestDir, "kubeconfig")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(kubeconfig), constant.DefaultFileMode))
+	conf, err := KubeConfig(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:1", conf.Host)
+	_, err = KubeConfig(filepath.Join(constant.TmpTestDir, "path/not/exist"))
+	assert.Error(t, err)
+}
+
+// TestAPIServer tests the unreachable apiserver is unhealthy
+func TestAPIServer(t *testing.T) {
+	src, err := NewAPIServer(&rest.Config{Host: "http://127.0.0.1:1"}, "nodeName")
+	assert.NoError(t, err)
+	assert.Equal(t, APIServer, src.Name())
+	assert.Error(t, src.Healthy())
+	_, err = src.List()
+	assert.Error(t, err)
+
+	stop := make(chan struct{})
+	src.Watch(&recorder{}, stop)
+	close(stop)
+}
+
+// TestAddUpdateDelHandler test Handler
+func TestAddUpdateDelHandler(t *testing.T) {
+	r := &recorder{}
+	oldObj := corev1.Pod{}
+	newObj := corev1.Pod{}
+	addHandler(r, oldObj)
+	updateHandler(r, oldObj, newObj)
+	deleteHandler(r, oldObj)
+	assert.Empty(t, r.events)
+
+	deleteHandler(r, cache.DeletedFinalStateUnknown{Obj: pod("aaa", "1")})
+	assert.Equal(t, []string{"delete aaa"}, r.events)
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-08
+// Description: pod source of the kubelet pods endpoint
+
+package podsource
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"isula.org/rubik/pkg/config"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/util"
+)
+
+const podsPath = "/pods"
+
+type kubeletSource struct {
+	url string
+	// tokenFile is read on each request, since the projected service account token is rotated
+	tokenFile string
+	client    *http.Client
+	interval  time.Duration
+}
+
+// NewKubelet creates the source polling the pods from kubelet, the read-only port is used
+// if the url is http, and the authenticated port with the bearer token if it is https.
+// The environment variables in the url are expanded, such as the node IP passed to the pod.
+// The serving certificate is verified with the CA file unless skipping verification is configured explicitly
+func NewKubelet(cfg config.PodSourceConfig) (Source, error) {
+	addr := strings.TrimSuffix(os.ExpandEnv(cfg.KubeletURL), "/")
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if strings.HasPrefix(addr, "https") {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		switch {
+		case cfg.KubeletInsecureSkipVerify:
+			log.Logf("the serving certificate of kubelet %s is not verified", addr)
+			tlsConfig.InsecureSkipVerify = true
+		case cfg.KubeletCAFile == "":
+			return nil, errors.Errorf("no ca file to verify kubelet %s", addr)
+		default:
+			ca, err := util.ReadSmallFile(filepath.Clean(cfg.KubeletCAFile))
+			if err != nil {
+				return nil, errors.Errorf("read kubelet ca file failed: %v", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, errors.Errorf("no certificate found in %s", cfg.KubeletCAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &kubeletSource{
+		url:       addr,
+		tokenFile: cfg.KubeletTokenFile,
+		client:    &http.Client{Transport: transport, Timeout: healthTimeout},
+		interval:  time.Duration(cfg.PollInterval) * time.Second,
+	}, nil
+}
+
+// Name returns the name of the source
+func (s *kubeletSource) Name() string {
+	return Kubelet
+}
+
+// List returns the pods of the node from kubelet
+func (s *kubeletSource) List() ([]corev1.Pod, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url+podsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.tokenFile != "" && util.PathExist(s.tokenFile) {
+		token, err := ioutil.ReadFile(filepath.Clean(s.tokenFile))
+		if err != nil {
+			return nil, errors.Errorf("read token failed: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("get %s%s: %s", s.url, podsPath, resp.Status)
+	}
+	var pods corev1.PodList
+	if err := json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, errors.Errorf("decode pods failed: %v", err)
+	}
+	return pods.Items, nil
+}
+
+// Healthy checks the pods can be got from kubelet
+func (s *kubeletSource) Healthy() error {
+	_, err := s.List()
+	return err
+}
+
+// Watch polls the pods periodically and sends the differences to the handler, the pods listed when the
+// watch starts are known to the handler already
+func (s *kubeletSource) Watch(handler EventHandler, stop <-chan struct{}) {
+	known := make(map[types.UID]*corev1.Pod)
+	if pods, err := s.List(); err == nil {
+		for i := range pods {
+			known[pods[i].UID] = &pods[i]
+		}
+	}
+	go wait.Until(func() {
+		pods, err := s.List()
+		if err != nil {
+			log.Errorf("poll pods from kubelet failed: %v", err)
+			return
+		}
+		known = diffPods(handler, known, pods)
+	}, s.interval, stop)
+}
+
+// diffPods sends the changes from the known pods to the pods listed, and returns the pods listed
+func diffPods(handler EventHandler, known map[types.UID]*corev1.Pod, pods []corev1.Pod) map[types.UID]*corev1.Pod {
+	listed := make(map[types.UID]*corev1.Pod, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		listed[pod.UID] = pod
+		old, ok := known[pod.UID]
+		if !ok {
+			handler.AddEvent(pod)
+			continue
+		}
+		handler.UpdateEvent(old, pod)
+	}
+	for uid, pod := range known {
+		if _, ok := listed[uid]; !ok {
+			handler.DeleteEvent(pod)
+		}
+	}
+	return listed
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-08
+// Description: kubelet pod source test
+
+package podsource
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+)
+
+// kubeletStub serves the pods on /pods like kubelet, and requires the token if it is set
+type kubeletStub struct {
+	lock  sync.Mutex
+	pods  []corev1.Pod
+	token string
+}
+
+func (k *kubeletStub) setPods(pods ...*corev1.Pod) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.pods = nil
+	for _, p := range pods {
+		k.pods = append(k.pods, *p)
+	}
+}
+
+func (k *kubeletStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != podsPath {
+		http.NotFound(w, r)
+		return
+	}
+	if k.token != "" && r.Header.Get("Authorization") != "Bearer "+k.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	_ = json.NewEncoder(w).Encode(corev1.PodList{Items: k.pods})
+}
+
+func kubeletConfig(url string) config.PodSourceConfig {
+	return config.PodSourceConfig{KubeletURL: url, PollInterval: 1}
+}
+
+// TestKubeletList tests the pods are listed from the kubelet stub with the token
+func TestKubeletList(t *testing.T) {
+	stub := &kubeletStub{token: "secret"}
+	stub.setPods(pod("aaa", "1"))
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	defer os.Unsetenv("KUBELET_ADDR")
+	os.Setenv("KUBELET_ADDR", strings.TrimPrefix(server.URL, "http://"))
+	cfg := kubeletConfig("http://${KUBELET_ADDR}/")
+	src, err := NewKubelet(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, Kubelet, src.Name())
+	assert.Contains(t, src.Healthy().Error(), "401")
+
+	assert.NoError(t, os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode))
+	defer os.RemoveAll(constant.TmpTestDir)
+	cfg.KubeletTokenFile = filepath.Join(constant.TmpTestDir, "token")
+	assert.NoError(t, ioutil.WriteFile(cfg.KubeletTokenFile, []byte("secret\n"), constant.DefaultFileMode))
+	src, err = NewKubelet(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, src.Healthy())
+	pods, err := src.List()
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1)
+	assert.Equal(t, "aaa", string(pods[0].UID))
+
+	// the token is not a certificate
+	cfg.KubeletURL, cfg.KubeletCAFile = "https://127.0.0.1:10250", cfg.KubeletTokenFile
+	_, err = NewKubelet(cfg)
+	assert.Contains(t, err.Error(), "no certificate")
+}
+
+// TestKubeletVerify tests the kubelet serving certificate is verified unless skipping is configured
+func TestKubeletVerify(t *testing.T) {
+	stub := &kubeletStub{}
+	stub.setPods(pod("aaa", "1"))
+	server := httptest.NewTLSServer(stub)
+	defer server.Close()
+
+	cfg := kubeletConfig(server.URL)
+	_, err := NewKubelet(cfg)
+	assert.Contains(t, err.Error(), "no ca file")
+
+	assert.NoError(t, os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode))
+	defer os.RemoveAll(constant.TmpTestDir)
+	cfg.KubeletCAFile = filepath.Join(constant.TmpTestDir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(cfg.KubeletCAFile, ca, constant.DefaultFileMode))
+	src, err := NewKubelet(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, src.Healthy())
+
+	cfg.KubeletCAFile, cfg.KubeletInsecureSkipVerify = "", true
+	src, err = NewKubelet(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, src.Healthy())
+}
+
+// TestKubeletWatch tests the changes of the pods polled are sent to the handler
+func TestKubeletWatch(t *testing.T) {
+	stub := &kubeletStub{}
+	stub.setPods(pod("aaa", "1"), pod("bbb", "1"))
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	src, err := NewKubelet(kubeletConfig(server.URL))
+	assert.NoError(t, err)
+	r := &recorder{}
+	stop := make(chan struct{})
+	defer close(stop)
+	src.Watch(r, stop)
+
+	stub.setPods(pod("aaa", "2"), pod("ccc", "1"))
+	assert.Eventually(t, func() bool { return len(r.list()) == 3 }, 3*time.Second, 100*time.Millisecond)
+	assert.ElementsMatch(t, []string{"update aaa", "add ccc", "delete bbb"}, r.list())
+}
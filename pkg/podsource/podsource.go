@@ -0,0 +1,220 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-08
+// Description: pod sources and the switch between them
+
+// Package podsource gets the pods of the node from the apiserver or kubelet, and switches to the next
+// source available when the preferred one is down
+package podsource
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/metrics"
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	// APIServer watches the pods of the node with an informer
+	APIServer = "apiserver"
+	// Kubelet polls the pods from the /pods endpoint of kubelet
+	Kubelet = "kubelet"
+
+	minPollInterval  = 1
+	maxPollInterval  = 300
+	minCheckInterval = 5
+	maxCheckInterval = 3600
+)
+
+// sourceGauge tells which pod source is active
+var sourceGauge = metrics.NewGauge("rubik_pod_source",
+	"The pod source in use, 1 for active and 0 for standby.", "source")
+
+// EventHandler is used to process pod events pushed by the pod sources.
+type EventHandler interface {
+	AddEvent(pod *corev1.Pod)
+	UpdateEvent(oldPod *corev1.Pod, newPod *corev1.Pod)
+	DeleteEvent(pod *corev1.Pod)
+	// SyncEvent receives all pods of the node when the source is switched, the events missed are made up
+	SyncEvent(pods []corev1.Pod)
+}
+
+// Source provides the pods of the node
+type Source interface {
+	Name() string
+	// List returns the pods of the node
+	List() ([]corev1.Pod, error)
+	// Healthy returns nil if the source is reachable
+	Healthy() error
+	// Watch sends the pod changes to the handler in the background until stop is closed
+	Watch(handler EventHandler, stop <-chan struct{})
+}
+
+// ValidateConfig checks the pod source config
+func ValidateConfig(cfg config.PodSourceConfig) error {
+	if len(cfg.Sources) == 0 {
+		return errors.Errorf("no pod source is configured")
+	}
+	seen := make(map[string]bool, len(cfg.Sources))
+	for _, name := range cfg.Sources {
+		if name != APIServer && name != Kubelet {
+			return errors.Errorf("unknown pod source %q, expect %s or %s", name, APIServer, Kubelet)
+		}
+		if seen[name] {
+			return errors.Errorf("pod source %s is configured more than once", name)
+		}
+		seen[name] = true
+	}
+	if seen[Kubelet] {
+		u, err := url.Parse(os.ExpandEnv(cfg.KubeletURL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("invalid kubeletURL %q, expect http(s)://host:port", cfg.KubeletURL)
+		}
+		if u.Scheme == "https" && cfg.KubeletCAFile == "" && !cfg.KubeletInsecureSkipVerify {
+			return errors.Errorf("kubeletCAFile is needed to verify %s unless kubeletInsecureSkipVerify is set",
+				cfg.KubeletURL)
+		}
+	}
+	if cfg.PollInterval < minPollInterval || cfg.PollInterval > maxPollInterval {
+		return errors.Errorf("pollInterval should be in range [%d, %d]", minPollInterval, maxPollInterval)
+	}
+	if cfg.CheckInterval < minCheckInterval || cfg.CheckInterval > maxCheckInterval {
+		return errors.Errorf("checkInterval should be in range [%d, %d]", minCheckInterval, maxCheckInterval)
+	}
+	return nil
+}
+
+// Switcher watches the pods with the most preferred source available
+type Switcher struct {
+	sources  []Source
+	handler  EventHandler
+	interval time.Duration
+	lock     sync.Mutex
+	// active is the index of the source watching, -1 if none
+	active int
+	// stopActive stops the watch of the active source
+	stopActive chan struct{}
+}
+
+// NewSwitcher creates a switcher of the sources in order of preference
+func NewSwitcher(sources []Source, handler EventHandler, interval time.Duration) *Switcher {
+	return &Switcher{sources: sources, handler: handler, interval: interval, active: -1}
+}
+
+// List returns the pods from the first source which succeeds
+func (s *Switcher) List() ([]corev1.Pod, error) {
+	var errs []string
+	for _, src := range s.sources {
+		pods, err := src.List()
+		if err == nil {
+			log.Infof("list pods from %s", src.Name())
+			return pods, nil
+		}
+		errs = append(errs, src.Name()+": "+err.Error())
+	}
+	return nil, errors.Errorf("no pod source available: %s", strings.Join(errs, "; "))
+}
+
+// Active returns the name of the source watching, empty if none is available
+func (s *Switcher) Active() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.active < 0 {
+		return ""
+	}
+	return s.sources[s.active].Name()
+}
+
+// Run watches with the first healthy source and checks the sources periodically until stop is closed,
+// the preferred source is switched back to once it recovers
+func (s *Switcher) Run(stop <-chan struct{}) {
+	s.check()
+	go func() {
+		wait.Until(s.check, s.interval, stop)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.stopWatch()
+	}()
+}
+
+// check switches to the most preferred healthy source
+func (s *Switcher) check() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, src := range s.sources {
+		err := src.Healthy()
+		if err != nil {
+			if i == s.active {
+				log.Errorf("pod source %s is down: %v", src.Name(), err)
+			}
+			continue
+		}
+		if i == s.active {
+			return
+		}
+		if err := s.switchTo(i); err != nil {
+			log.Errorf("switch to pod source %s failed: %v", src.Name(), err)
+			continue
+		}
+		return
+	}
+	if s.active >= 0 {
+		log.Errorf("no pod source available, keep watching with %s", s.sources[s.active].Name())
+	}
+}
+
+// switchTo stops the watch of the active source and watches with the i-th source,
+// the pods are synced from the new source unless it is the first one watching
+func (s *Switcher) switchTo(i int) error {
+	src := s.sources[i]
+	first := s.active < 0
+	var pods []corev1.Pod
+	if !first {
+		var err error
+		if pods, err = src.List(); err != nil {
+			return err
+		}
+	}
+	s.stopWatch()
+	s.stopActive = make(chan struct{})
+	src.Watch(s.handler, s.stopActive)
+	if first {
+		log.Infof("watch pods with %s", src.Name())
+	} else {
+		log.Infof("switch pod source from %s to %s", s.sources[s.active].Name(), src.Name())
+		s.handler.SyncEvent(pods)
+	}
+	s.active = i
+	for j, other := range s.sources {
+		value := 0.0
+		if j == i {
+			value = 1
+		}
+		sourceGauge.Set(value, other.Name())
+	}
+	return nil
+}
+
+func (s *Switcher) stopWatch() {
+	if s.stopActive != nil {
+		close(s.stopActive)
+		s.stopActive = nil
+	}
+}
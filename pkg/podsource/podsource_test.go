@@ -0,0 +1,142 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-11-08
+// Description: pod source switch test
+
+package podsource
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"isula.org/rubik/pkg/config"
+)
+
+func pod(uid, version string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), ResourceVersion: version}}
+}
+
+// recorder records the events handled
+type recorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) list() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.events...)
+}
+
+func (r *recorder) AddEvent(pod *corev1.Pod) { r.record("add %s", pod.UID) }
+
+func (r *recorder) UpdateEvent(oldPod *corev1.Pod, newPod *corev1.Pod) {
+	if oldPod.ResourceVersion != newPod.ResourceVersion {
+		r.record("update %s", newPod.UID)
+	}
+}
+
+func (r *recorder) DeleteEvent(pod *corev1.Pod) { r.record("delete %s", pod.UID) }
+
+func (r *recorder) SyncEvent(pods []corev1.Pod) { r.record("sync %d", len(pods)) }
+
+// downSource is a source which is never available
+type downSource struct{}
+
+func (downSource) Name() string                        { return "down" }
+func (downSource) List() ([]corev1.Pod, error)         { return nil, fmt.Errorf("connection refused") }
+func (downSource) Healthy() error                      { return fmt.Errorf("connection refused") }
+func (downSource) Watch(EventHandler, <-chan struct{}) {}
+
+// TestValidateConfig tests the pod source config is checked
+func TestValidateConfig(t *testing.T) {
+	valid := config.PodSourceConfig{Sources: []string{APIServer, Kubelet}, KubeletURL: "http://127.0.0.1:10255",
+		PollInterval: 10, CheckInterval: 30}
+	assert.NoError(t, ValidateConfig(valid))
+	for name, modify := range map[string]func(c *config.PodSourceConfig){
+		"no pod source":   func(c *config.PodSourceConfig) { c.Sources = nil },
+		"unknown":         func(c *config.PodSourceConfig) { c.Sources = []string{"etcd"} },
+		"more than once":  func(c *config.PodSourceConfig) { c.Sources = []string{Kubelet, Kubelet} },
+		"invalid kubelet": func(c *config.PodSourceConfig) { c.KubeletURL = "127.0.0.1:10250" },
+		"pollInterval":    func(c *config.PodSourceConfig) { c.PollInterval = 0 },
+		"checkInterval":   func(c *config.PodSourceConfig) { c.CheckInterval = maxCheckInterval + 1 },
+		"kubeletCAFile":   func(c *config.PodSourceConfig) { c.KubeletURL = "https://127.0.0.1:10250" },
+	} {
+		c := valid
+		modify(&c)
+		err := ValidateConfig(c)
+		if assert.Error(t, err, name) {
+			assert.Contains(t, err.Error(), name)
+		}
+	}
+	// the kubelet serving certificate is not verified only if configured explicitly
+	valid.KubeletURL, valid.KubeletInsecureSkipVerify = "https://127.0.0.1:10250", true
+	assert.NoError(t, ValidateConfig(valid))
+	// the kubelet url is not used without the kubelet source
+	valid.Sources, valid.KubeletURL = []string{APIServer}, ""
+	assert.NoError(t, ValidateConfig(valid))
+}
+
+// TestSwitcher tests the pods are watched with the first source available, and the source
+// preferred is switched back to once it recovers
+func TestSwitcher(t *testing.T) {
+	stub := &kubeletStub{}
+	stub.setPods(pod("aaa", "1"))
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	primary, err := NewKubelet(kubeletConfig(server.URL))
+	assert.NoError(t, err)
+	down := httptest.NewServer(stub)
+	down.Close()
+	secondary, err := NewKubelet(kubeletConfig(down.URL))
+	assert.NoError(t, err)
+
+	r := &recorder{}
+	s := NewSwitcher([]Source{downSource{}, primary}, r, time.Hour)
+	pods, err := s.List()
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1)
+	_, err = NewSwitcher([]Source{downSource{}, secondary}, r, time.Hour).List()
+	assert.Contains(t, err.Error(), "no pod source available: down: connection refused; kubelet:")
+
+	stop := make(chan struct{})
+	s.Run(stop)
+	assert.Equal(t, Kubelet, s.Active())
+	assert.Empty(t, r.list())
+
+	// the pods are synced from the source switched to
+	s.sources[0] = &fakeSource{Source: primary}
+	s.check()
+	assert.Equal(t, "fake", s.Active())
+	assert.Equal(t, []string{"sync 1"}, r.list())
+	close(stop)
+}
+
+// fakeSource is a healthy source under another name
+type fakeSource struct {
+	Source
+}
+
+func (*fakeSource) Name() string { return "fake" }
@@ -0,0 +1,115 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-04
+// Description: cpu priority backends of different kernels
+
+package qos
+
+import (
+	"strconv"
+	"sync"
+
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/constant"
+	log "isula.org/rubik/pkg/tinylog"
+)
+
+const (
+	// backendQosLevel uses cpu.qos_level of openEuler kernels
+	backendQosLevel = "qos_level"
+	// backendIdle uses cpu.idle of upstream kernels since 5.15, which runs offline tasks as SCHED_IDLE
+	backendIdle = "idle"
+	// backendWeight sets the minimal cpu.shares or cpu.weight of offline pods on the other kernels
+	backendWeight = "weight"
+
+	cpuIdleFile   = "cpu.idle"
+	cpuSharesFile = "cpu.shares"
+	cpuWeightFile = "cpu.weight"
+	// minShares and minWeight are the minimal values of cpu.shares and cpu.weight
+	minShares = "2"
+	minWeight = "1"
+)
+
+// cpuBackend sets the cpu priority of the qos levels through a cgroup file
+type cpuBackend struct {
+	name string
+	// file is the cgroup file name in the current hierarchy mode
+	file string
+	// value returns the content of file for the qos level, empty means the value is left as it is
+	value func(level int) string
+}
+
+var (
+	backendLock sync.RWMutex
+	// backend keeps cpu.qos_level until Init probes the kernel, so that the modules work the same as before in tests
+	backend = newQosLevelBackend()
+	// memoryQos tells whether memory.qos_level exists
+	memoryQos = true
+)
+
+func newQosLevelBackend() *cpuBackend {
+	return &cpuBackend{name: backendQosLevel, file: constant.CPUCgroupFileName, value: strconv.Itoa}
+}
+
+func newIdleBackend() *cpuBackend {
+	return &cpuBackend{name: backendIdle, file: cpuIdleFile, value: func(level int) string {
+		if level < constant.MaxLevel.Int() {
+			return "1"
+		}
+		return "0"
+	}}
+}
+
+// newWeightBackend does not know the weight of online pods, which is decided by kubelet
+func newWeightBackend() *cpuBackend {
+	file, min := cpuSharesFile, minShares
+	if cgroup.IsV2() {
+		file, min = cpuWeightFile, minWeight
+	}
+	return &cpuBackend{name: backendWeight, file: file, value: func(level int) string {
+		if level < constant.MaxLevel.Int() {
+			return min
+		}
+		return ""
+	}}
+}
+
+func activeBackend() (*cpuBackend, bool) {
+	backendLock.RLock()
+	defer backendLock.RUnlock()
+	return backend, memoryQos
+}
+
+func setBackend(b *cpuBackend, memory bool) {
+	backendLock.Lock()
+	defer backendLock.Unlock()
+	backend, memoryQos = b, memory
+}
+
+// probeBackend chooses the first cpu priority backend supported by the kernel,
+// in the order of cpu.qos_level, cpu.idle and cpu.shares or cpu.weight
+func probeBackend() (*cpuBackend, bool) {
+	if capability.Supported(capability.CPUQosLevel) {
+		return newQosLevelBackend(), capability.Supported(capability.MemoryQosLevel)
+	}
+	if capability.Supported(capability.CPUIdle) {
+		return newIdleBackend(), false
+	}
+	return newWeightBackend(), false
+}
+
+// initBackend chooses the cpu priority backend by the capabilities of the kernel
+func initBackend() {
+	b, memory := probeBackend()
+	setBackend(b, memory)
+	log.Infof("cpu priority backend %s(%s) is used, memory.qos_level supported: %v", b.name, b.file, memory)
+}
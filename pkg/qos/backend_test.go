@@ -0,0 +1,122 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-04
+// Description: cpu priority backends test
+
+package qos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// TestProbeBackend tests the cpu priority backend falls back from cpu.qos_level to cpu.idle and cpu.shares
+func TestProbeBackend(t *testing.T) {
+	defer cgroup.SetMode(cgroup.SetMode(cgroup.ModeV1))
+	defer capability.SetReport(capability.SetReport(map[string]bool{}))
+
+	b, memory := probeBackend()
+	assert.Equal(t, backendWeight, b.name)
+	assert.Equal(t, cpuSharesFile, b.file)
+	assert.Equal(t, minShares, b.value(-1))
+	assert.Equal(t, "", b.value(0))
+	assert.False(t, memory)
+
+	capability.SetReport(map[string]bool{capability.CPUIdle: true})
+	b, memory = probeBackend()
+	assert.Equal(t, backendIdle, b.name)
+	assert.Equal(t, "1", b.value(-1))
+	assert.Equal(t, "0", b.value(0))
+	assert.False(t, memory)
+
+	capability.SetReport(map[string]bool{capability.CPUIdle: true, capability.CPUQosLevel: true})
+	b, memory = probeBackend()
+	assert.Equal(t, backendQosLevel, b.name)
+	assert.False(t, memory)
+	capability.SetReport(map[string]bool{capability.CPUQosLevel: true, capability.MemoryQosLevel: true})
+	_, memory = probeBackend()
+	assert.True(t, memory)
+
+	cgroup.SetMode(cgroup.ModeV2)
+	capability.SetReport(map[string]bool{})
+	b, _ = probeBackend()
+	assert.Equal(t, cpuWeightFile, b.file)
+	assert.Equal(t, minWeight, b.value(-1))
+}
+
+// TestFallbackBackends tests the offline pods are set and validated by the backend in use
+func TestFallbackBackends(t *testing.T) {
+	assert.NoError(t, os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode))
+	defer os.RemoveAll(constant.TmpTestDir)
+	defer setBackend(newQosLevelBackend(), true)
+	const podPath = "kubepods/besteffort/poda5cb0d50-1234-1234-1234-e0ae4b7884b2"
+
+	tests := []struct {
+		name    string
+		backend *cpuBackend
+		file    string
+		origin  string
+		offline string
+		online  string
+	}{
+		{name: "TC1-cpu.idle", backend: newIdleBackend(), file: cpuIdleFile, origin: "0", offline: "1", online: "0"},
+		{name: "TC2-cpu.shares", backend: newWeightBackend(), file: cpuSharesFile, origin: "1024",
+			offline: minShares, online: minShares},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cgRoot, err := ioutil.TempDir(constant.TmpTestDir, "backend")
+			assert.NoError(t, err)
+			containerPath := filepath.Join(cgRoot, "cpu", podPath, "container")
+			assert.NoError(t, os.MkdirAll(containerPath, constant.DefaultDirMode))
+			for _, dir := range []string{filepath.Dir(containerPath), containerPath} {
+				assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, tt.file), []byte(tt.origin),
+					constant.DefaultFileMode))
+			}
+			setBackend(tt.backend, false)
+			assert.NoError(t, journal.Init(""))
+
+			pod := &typedef.PodInfo{UID: "poda5cb0d50", CgroupPath: podPath, CgroupRoot: cgRoot, Offline: true}
+			assert.NoError(t, setQos(pod))
+			assert.NoError(t, validateQos(pod))
+			b, err := ioutil.ReadFile(filepath.Join(containerPath, tt.file))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.offline, strings.TrimSpace(string(b)))
+			// memory.qos_level is not touched
+			assert.NoDirExists(t, filepath.Join(cgRoot, "memory", podPath))
+
+			// offline pods can become online again without cpu.qos_level
+			pod.Offline = false
+			assert.NoError(t, setQos(pod))
+			assert.NoError(t, validateQos(pod))
+			b, err = ioutil.ReadFile(filepath.Join(containerPath, tt.file))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.online, strings.TrimSpace(string(b)))
+
+			// the cpu priority is restored as it is journaled
+			assert.NoError(t, journal.Restore(moduleName))
+			b, err = ioutil.ReadFile(filepath.Join(containerPath, tt.file))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.origin, strings.TrimSpace(string(b)))
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-03
+// Description: qos levels supported by the kernel
+
+package qos
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// probeDir is the temporary cgroup under the cpu hierarchy where the qos levels are tried
+const probeDir = "rubik-qos-probe"
+
+var (
+	levelLock sync.RWMutex
+	// minLevel and maxLevel are the range of qos levels accepted by the kernel
+	minLevel = constant.MinLevel.Int()
+	maxLevel = constant.MaxLevel.Int()
+)
+
+// LevelRange returns the range of qos levels accepted by the kernel
+func LevelRange() (int, int) {
+	levelLock.RLock()
+	defer levelLock.RUnlock()
+	return minLevel, maxLevel
+}
+
+func setLevelRange(min, max int) {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	minLevel, maxLevel = min, max
+}
+
+// ValidateLevel checks the qos level is accepted by the kernel
+func ValidateLevel(level int) error {
+	min, max := LevelRange()
+	if level < min || level > max {
+		return errors.Errorf("qos level %d is not supported by the kernel, should be in range [%d,%d]",
+			level, min, max)
+	}
+	return nil
+}
+
+// podLevel returns the qos level of the pod, the level is kept within the range of its class as
+// the offline pods are always below the online ones
+func podLevel(pod *typedef.PodInfo) int {
+	if pod.Offline && pod.QosLevel >= constant.MaxLevel.Int() {
+		return constant.MinLevel.Int()
+	}
+	if !pod.Offline && pod.QosLevel < constant.MaxLevel.Int() {
+		return constant.MaxLevel.Int()
+	}
+	return pod.QosLevel
+}
+
+// memoryLevel returns memory.qos_level of the qos level, which only distinguishes offline from online
+func memoryLevel(level int) int {
+	if level < constant.MaxLevel.Int() {
+		return constant.MinLevel.Int()
+	}
+	return constant.MaxLevel.Int()
+}
+
+// probeLevels finds the range of qos levels by accept, the levels beyond the default range [-1, 0]
+// are accepted only if the levels between them and the default range are accepted
+func probeLevels(accept func(level int) bool) (int, int) {
+	min, max := constant.MinLevel.Int(), constant.MaxLevel.Int()
+	for l := min - 1; l >= constant.LowestLevel.Int() && accept(l); l-- {
+		min = l
+	}
+	for l := max + 1; l <= constant.HighestLevel.Int() && accept(l); l++ {
+		max = l
+	}
+	return min, max
+}
+
+// acceptLevel writes the level to cpu.qos_level of a new temporary cgroup, as the level of a cgroup
+// can not always be changed back
+func acceptLevel(level int) bool {
+	dir := filepath.Join(cgroup.Root(), "cpu", probeDir)
+	if err := cgroup.Mkdir(dir); err != nil {
+		log.Debugf("create qos probe cgroup %s failed: %v", dir, err)
+		return false
+	}
+	defer func() {
+		if err := cgroup.Remove(dir); err != nil {
+			log.Errorf("remove qos probe cgroup %s failed: %v", dir, err)
+		}
+	}()
+	return cgroup.WriteValue(dir, constant.CPUCgroupFileName, strconv.Itoa(level)) == nil
+}
+
+// initLevelRange probes the qos levels accepted by the kernel, the default range is used
+// in dry-run mode as no cgroup is created then, and by the backends other than cpu.qos_level
+func initLevelRange() {
+	min, max := constant.MinLevel.Int(), constant.MaxLevel.Int()
+	if b, _ := activeBackend(); b.name == backendQosLevel && qosSupported() && !dryrun.Enabled() {
+		min, max = probeLevels(acceptLevel)
+	}
+	setLevelRange(min, max)
+	log.Infof("qos levels supported by the kernel: [%d,%d]", min, max)
+}
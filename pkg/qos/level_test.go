@@ -0,0 +1,153 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-11-03
+// Description: qos levels test
+
+package qos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// TestProbeLevels tests the range of qos levels is contiguous around the default range
+func TestProbeLevels(t *testing.T) {
+	acceptOf := func(levels ...int) func(int) bool {
+		return func(level int) bool {
+			for _, l := range levels {
+				if l == level {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	tests := []struct {
+		name     string
+		accept   func(int) bool
+		min, max int
+	}{
+		{name: "TC1-default levels only", accept: acceptOf(), min: -1, max: 0},
+		{name: "TC2-all levels", accept: acceptOf(-2, 1, 2), min: -2, max: 2},
+		{name: "TC3-online levels only", accept: acceptOf(1), min: -1, max: 1},
+		{name: "TC4-gap is not accepted", accept: acceptOf(2), min: -1, max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			min, max := probeLevels(tt.accept)
+			assert.Equal(t, tt.min, min)
+			assert.Equal(t, tt.max, max)
+		})
+	}
+}
+
+// TestPodLevel tests the level of pods is kept within their class
+func TestPodLevel(t *testing.T) {
+	assert.Equal(t, -1, podLevel(&typedef.PodInfo{Offline: true}))
+	assert.Equal(t, -1, podLevel(&typedef.PodInfo{Offline: true, QosLevel: 2}))
+	assert.Equal(t, -2, podLevel(&typedef.PodInfo{Offline: true, QosLevel: -2}))
+	assert.Equal(t, 0, podLevel(&typedef.PodInfo{QosLevel: -2}))
+	assert.Equal(t, 2, podLevel(&typedef.PodInfo{QosLevel: 2}))
+}
+
+// TestSetMultiLevel tests the levels beyond the default range are set if the kernel supports them
+func TestSetMultiLevel(t *testing.T) {
+	assert.NoError(t, os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode))
+	defer os.RemoveAll(constant.TmpTestDir)
+	cgRoot, err := ioutil.TempDir(constant.TmpTestDir, t.Name())
+	assert.NoError(t, err)
+	const podPath = "kubepods/burstable/poda5cb0d50-1234-1234-1234-e0ae4b7884b2"
+	for _, kind := range SupportCgroupTypes {
+		assert.NoError(t, os.MkdirAll(filepath.Join(cgRoot, kind, podPath, "container"), constant.DefaultDirMode))
+	}
+	// memory.qos_level of online pods is left as the default
+	for _, dir := range []string{podPath, filepath.Join(podPath, "container")} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(cgRoot, "memory", dir, constant.MemoryCgroupFileName),
+			[]byte("0"), constant.DefaultFileMode))
+	}
+	pod := &typedef.PodInfo{UID: "poda5cb0d50", CgroupPath: podPath, CgroupRoot: cgRoot, QosLevel: 2}
+
+	assert.Error(t, ValidateLevel(2))
+	assert.Error(t, setQos(pod))
+
+	setLevelRange(-2, 2)
+	defer setLevelRange(constant.MinLevel.Int(), constant.MaxLevel.Int())
+	assert.NoError(t, ValidateLevel(2))
+	assert.NoError(t, setQos(pod))
+	assert.NoError(t, validateQos(pod))
+	readLevel := func(kind, file string) string {
+		b, err := ioutil.ReadFile(filepath.Join(cgRoot, kind, podPath, "container", file))
+		assert.NoError(t, err)
+		return strings.TrimSpace(string(b))
+	}
+	assert.Equal(t, "2", readLevel("cpu", constant.CPUCgroupFileName))
+
+	// offline levels can be lowered, but not raised to online ones
+	pod.QosLevel, pod.Offline = -1, true
+	assert.NoError(t, setQos(pod))
+	pod.QosLevel = -2
+	assert.NoError(t, setQos(pod))
+	assert.NoError(t, validateQos(pod))
+	assert.Equal(t, "-2", readLevel("cpu", constant.CPUCgroupFileName))
+	assert.Equal(t, "-1", readLevel("memory", constant.MemoryCgroupFileName))
+	pod.QosLevel, pod.Offline = 1, false
+	assert.Error(t, setQos(pod))
+}
+
+// TestLowerToDefault tests the online pod lowered to the default level is set and reconciled
+func TestLowerToDefault(t *testing.T) {
+	assert.NoError(t, os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode))
+	defer os.RemoveAll(constant.TmpTestDir)
+	cgRoot, err := ioutil.TempDir(constant.TmpTestDir, t.Name())
+	assert.NoError(t, err)
+	const podPath = "kubepods/burstable/poda5cb0d50-1234-1234-1234-e0ae4b7884b2"
+	container := filepath.Join(podPath, "container")
+	for _, kind := range SupportCgroupTypes {
+		assert.NoError(t, os.MkdirAll(filepath.Join(cgRoot, kind, container), constant.DefaultDirMode))
+	}
+	for _, dir := range []string{podPath, container} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(cgRoot, "memory", dir, constant.MemoryCgroupFileName),
+			[]byte("0"), constant.DefaultFileMode))
+	}
+	readLevel := func() string {
+		b, err := ioutil.ReadFile(filepath.Join(cgRoot, "cpu", container, constant.CPUCgroupFileName))
+		assert.NoError(t, err)
+		return strings.TrimSpace(string(b))
+	}
+	setLevelRange(-2, 2)
+	defer setLevelRange(constant.MinLevel.Int(), constant.MaxLevel.Int())
+
+	pod := &typedef.PodInfo{UID: "poda5cb0d50", CgroupPath: podPath, CgroupRoot: cgRoot, QosLevel: 2}
+	assert.NoError(t, setQos(pod))
+	assert.Equal(t, "2", readLevel())
+
+	pod.QosLevel = 0
+	assert.Error(t, validateQos(pod))
+	assert.NoError(t, setQos(pod))
+	assert.NoError(t, validateQos(pod))
+	assert.Equal(t, "0", readLevel())
+
+	// the drift of the default level is corrected too
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(cgRoot, "cpu", container, constant.CPUCgroupFileName),
+		[]byte("1"), constant.DefaultFileMode))
+	corrections, err := reconcileQos(pod)
+	assert.NoError(t, err)
+	assert.Len(t, corrections, 1)
+	assert.Equal(t, "0", readLevel())
+}
@@ -14,41 +14,74 @@
 package qos
 
 import (
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
-	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/events"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/metrics"
+	"isula.org/rubik/pkg/services"
 	log "isula.org/rubik/pkg/tinylog"
 	"isula.org/rubik/pkg/typedef"
 	"isula.org/rubik/pkg/util"
 )
 
+const moduleName = "qos"
+
 // SupportCgroupTypes are supported cgroup types for qos setting
 var SupportCgroupTypes = []string{"cpu", "memory"}
 
 // SetQosLevel set pod qos_level
 func SetQosLevel(pod *typedef.PodInfo) error {
+	if !qosSupported() {
+		return nil
+	}
+	if dryrun.Enabled() {
+		recordQos(pod)
+		return nil
+	}
 	if err := setQos(pod); err != nil {
+		metrics.WriteFailures.Inc(moduleName, pod.Namespace, pod.Name)
+		events.Warningf(events.PodRef(pod), events.ReasonQosLevelApplyFailed, "Set qos level failed: %v", err)
 		return errors.Errorf("set qos for pod %s(%s) error: %v", pod.Name, pod.UID, err)
 	}
 	if err := validateQos(pod); err != nil {
+		events.Warningf(events.PodRef(pod), events.ReasonQosLevelApplyFailed, "Validate qos level failed: %v", err)
 		return errors.Errorf("validate qos for pod %s(%s) error: %v", pod.Name, pod.UID, err)
 	}
+	recordValid(pod)
 
-	log.Logf("Set pod %s(UID=%s, offline=%v) qos level OK", pod.Name, pod.UID, pod.Offline)
+	log.Logf("Set pod %s(UID=%s, offline=%v, level=%d) qos level OK", pod.Name, pod.UID, pod.Offline, podLevel(pod))
 	return nil
 }
 
+// UpdateQosLevel checks the qos level of the updated pod and resets it if it is wrong, only the cgroups
+// of the containers created since the last full validation are checked within the full check interval
 func UpdateQosLevel(pod *typedef.PodInfo) error {
-	if err := validateQos(pod); err != nil {
-		log.Logf("Checking pod %s(%s) value failed: %v, reset it", err, pod.Name, pod.UID)
+	if !qosSupported() {
+		return nil
+	}
+	if dryrun.Enabled() {
+		// the cgroups are never written in dry-run mode, so only the pods not recorded yet are recorded
+		if containers, ok := newContainers(pod); !ok || len(containers) != 0 {
+			recordQos(pod)
+		}
+		return nil
+	}
+	if err := validateQuick(pod); err != nil {
+		log.Logf("Checking pod %s(%s) value failed: %v, reset it", pod.Name, pod.UID, err)
+		forgetValid(pod.UID)
 		if err := setQos(pod); err != nil {
+			metrics.WriteFailures.Inc(moduleName, pod.Namespace, pod.Name)
+			events.Warningf(events.PodRef(pod), events.ReasonQosLevelApplyFailed, "Reset qos level failed: %v", err)
 			return errors.Errorf("set qos for pod %s(%s) error: %v", pod.Name, pod.UID, err)
 		}
 	}
@@ -56,18 +89,47 @@ func UpdateQosLevel(pod *typedef.PodInfo) error {
 	return nil
 }
 
+// recordQos records the qos level of the pod in dry-run mode, where the cgroups keep the old values,
+// so the validation, the events and the metrics of the failures are skipped
+func recordQos(pod *typedef.PodInfo) {
+	if err := setQos(pod); err != nil {
+		log.Errorf("record qos level of pod %s(%s) failed: %v", pod.Name, pod.UID, err)
+		return
+	}
+	recordValid(pod)
+	log.Logf("Record pod %s(UID=%s, offline=%v, level=%d) qos level in dry-run mode", pod.Name, pod.UID,
+		pod.Offline, podLevel(pod))
+}
+
+// qosSupported tells whether the file of the cpu priority backend exists in the current cgroup mode
+func qosSupported() bool {
+	b, _ := activeBackend()
+	if b.name != backendQosLevel {
+		return true
+	}
+	_, cpuOK := cgroup.FileName(constant.CPUCgroupFileName)
+	_, memOK := cgroup.FileName(constant.MemoryCgroupFileName)
+	return cpuOK && memOK
+}
+
 // setQos is used for setting pod's qos level following it's cgroup path
 func setQos(pod *typedef.PodInfo) error {
 	if len(pod.UID) > constant.MaxPodIDLen {
 		return errors.Errorf("Pod id too long")
 	}
 
-	// default qos_level is online, no need to set online pod qos_level
-	if !pod.Offline {
-		log.Logf("Set level=%v for pod %s(%s)", constant.MaxLevel, pod.Name, pod.UID)
+	level := podLevel(pod)
+	b, memory := activeBackend()
+	// default qos_level is online, no need to set online pod qos_level unless it is lowered from a higher
+	// online level, while cpu.idle can be reset
+	if level == constant.MaxLevel.Int() && b.name != backendIdle && validateQos(pod) == nil {
+		log.Logf("Set level=%v for pod %s(%s)", level, pod.Name, pod.UID)
 		return nil
 	}
-	log.Logf("Set level=%v for pod %s(%s)", constant.MinLevel, pod.Name, pod.UID)
+	if err := ValidateLevel(level); err != nil {
+		return err
+	}
+	log.Logf("Set level=%v for pod %s(%s) by %s", level, pod.Name, pod.UID, b.name)
 
 	cgroupMap, err := initCgroupPath(pod.CgroupRoot, pod.CgroupPath)
 	if err != nil {
@@ -77,11 +139,26 @@ func setQos(pod *typedef.PodInfo) error {
 	for kind, cgPath := range cgroupMap {
 		switch kind {
 		case "cpu":
-			if err := setQosLevel(cgPath, constant.CPUCgroupFileName, int(constant.MinLevel)); err != nil {
+			if b.name == backendQosLevel {
+				err = setQosLevel(cgPath, b.file, level)
+			} else {
+				err = writeAll(cgPath, b.file, b.value(level), "set cpu priority", writerOf(kind))
+			}
+			if err != nil {
 				return err
 			}
 		case "memory":
-			if err := setQosLevel(cgPath, constant.MemoryCgroupFileName, int(constant.MinLevel)); err != nil {
+			// memory.qos_level of online pods is left as the default if it is not changed
+			if !memory {
+				continue
+			}
+			if memoryLevel(level) == constant.MaxLevel.Int() {
+				v, err := getValue(cgPath, constant.MemoryCgroupFileName)
+				if err == nil && v == strconv.Itoa(constant.MaxLevel.Int()) {
+					continue
+				}
+			}
+			if err := setQosLevel(cgPath, constant.MemoryCgroupFileName, memoryLevel(level)); err != nil {
 				return err
 			}
 		}
@@ -94,103 +171,162 @@ func setQosLevel(root, file string, target int) error {
 	if !util.IsDirectory(root) {
 		return errors.Errorf("Invalid cgroup path %q", root)
 	}
-	if old, err := getQosLevel(root, file); err == nil && target > old {
-		return errors.Errorf("Not support change qos level from low to high")
+	// the kernel does not allow offline tasks to become online again
+	if old, err := getQosLevel(root, file); err == nil && old < constant.MaxLevel.Int() &&
+		target >= constant.MaxLevel.Int() {
+		return errors.Errorf("Not support change qos level from offline %d to online %d", old, target)
+	}
+	return writeAll(root, file, strconv.Itoa(target), "set qos level", dryrun.WriteFile)
+}
+
+// writeAll writes value to file of root and all its sub cgroups by write
+func writeAll(root, file, value, reason string, write func(module, path, value, reason string) error) error {
+	if !util.IsDirectory(root) {
+		return errors.Errorf("Invalid cgroup path %q", root)
 	}
 	// walk through all sub paths
-	if err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+	return filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
 		if f != nil && f.IsDir() {
-			cgFilePath, err := securejoin.SecureJoin(path, file)
+			cgFilePath, err := cgroup.Join(path, file)
 			if err != nil {
-				return errors.Errorf("Join path failed for %s and %s: %v", path, file, err)
+				return err
 			}
-			if err = ioutil.WriteFile(cgFilePath, []byte(strconv.Itoa(target)),
-				constant.DefaultFileMode); err != nil {
-				return errors.Errorf("Setting qos level failed for %s=%d: %v", cgFilePath, target, err)
+			if err = write(moduleName, cgFilePath, value, reason); err != nil {
+				return errors.Errorf("Setting qos level failed for %s=%s: %v", cgFilePath, value, err)
 			}
 		}
-
 		return nil
-	}); err != nil {
-		return err
-	}
-
-	return nil
+	})
 }
 
-// validateQos is used for checking pod's qos level if equal to the value it should be set up to
-func validateQos(pod *typedef.PodInfo) error {
-	var (
-		cpuInfo, memInfo int
-		err              error
-		qosLevel         int
-	)
+// writerOf returns how the qos file of the cgroup kind is written, the cpu priority set by cpu.idle,
+// cpu.shares or cpu.weight is journaled to be restored when rubik exits, while qos_level is not as
+// the kernel does not allow offline tasks to become online again
+func writerOf(kind string) func(module, path, value, reason string) error {
+	if b, _ := activeBackend(); kind == "cpu" && b.name != backendQosLevel {
+		return journal.WriteFile
+	}
+	return dryrun.WriteFile
+}
 
-	if !pod.Offline {
-		qosLevel = int(constant.MaxLevel)
-	} else {
-		qosLevel = int(constant.MinLevel)
+// desiredValues returns the files of the cgroup kinds and their contents the qos level is set to,
+// the files left as they are do not present
+func desiredValues(level int) map[string][2]string {
+	b, memory := activeBackend()
+	desired := make(map[string][2]string, len(SupportCgroupTypes))
+	if v := b.value(level); v != "" {
+		desired["cpu"] = [2]string{b.file, v}
+	}
+	if memory {
+		desired["memory"] = [2]string{constant.MemoryCgroupFileName, strconv.Itoa(memoryLevel(level))}
 	}
+	return desired
+}
 
+// reconcileQos sets the qos level of the cgroups of the pod drifted from its level again, nothing drifts
+// in dry-run mode as the cgroups are never written and the writes are recorded when the pods are added
+func reconcileQos(pod *typedef.PodInfo) ([]services.Correction, error) {
+	level := podLevel(pod)
+	if dryrun.Enabled() || !qosSupported() || ValidateLevel(level) != nil {
+		return nil, nil
+	}
 	cgroupMap, err := initCgroupPath(pod.CgroupRoot, pod.CgroupPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for kind, cgPath := range cgroupMap {
-		switch kind {
-		case "cpu":
-			if cpuInfo, err = getQosLevel(cgPath, constant.CPUCgroupFileName); err != nil {
-				return errors.Errorf("read %s failed: %v", constant.CPUCgroupFileName, err)
+
+	var corrections []services.Correction
+	for kind, fileValue := range desiredValues(level) {
+		root, ok := cgroupMap[kind]
+		if !ok {
+			continue
+		}
+		file, desired := fileValue[0], fileValue[1]
+		// walk through all sub paths as containers created later may miss the setting
+		err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+			if f == nil || !f.IsDir() {
+				return nil
 			}
-		case "memory":
-			if memInfo, err = getQosLevel(cgPath, constant.MemoryCgroupFileName); err != nil {
-				return errors.Errorf("read %s failed: %v", constant.MemoryCgroupFileName, err)
+			actual, err := cgroup.ReadValue(path, file)
+			if err != nil || actual == desired {
+				return nil
+			}
+			cgFilePath, err := cgroup.Join(path, file)
+			if err != nil {
+				return err
 			}
+			if err := writerOf(kind)(moduleName, cgFilePath, desired, "reconcile qos level"); err != nil {
+				metrics.WriteFailures.Inc(moduleName, pod.Namespace, pod.Name)
+				return errors.Errorf("Setting qos level failed for %s=%s: %v", cgFilePath, desired, err)
+			}
+			corrections = append(corrections, services.Correction{Pod: pod, File: cgFilePath, Actual: actual, Desired: desired})
+			return nil
+		})
+		if err != nil {
+			return corrections, err
 		}
 	}
+	return corrections, nil
+}
 
-	if (cpuInfo != qosLevel) || (memInfo != qosLevel) {
-		return errors.Errorf("check level failed")
+// validateQos is used for checking pod's qos level if equal to the value it should be set up to
+// by the cpu priority backend
+func validateQos(pod *typedef.PodInfo) error {
+	cgroupMap, err := initCgroupPath(pod.CgroupRoot, pod.CgroupPath)
+	if err != nil {
+		return err
+	}
+	for kind, fileValue := range desiredValues(podLevel(pod)) {
+		cgPath, ok := cgroupMap[kind]
+		if !ok {
+			continue
+		}
+		file, desired := fileValue[0], fileValue[1]
+		actual, err := getValue(cgPath, file)
+		if err != nil {
+			return errors.Errorf("read %s failed: %v", file, err)
+		}
+		if actual != desired {
+			return errors.Errorf("check level failed, %s is %s, expect %s", file, actual, desired)
+		}
 	}
 
 	return nil
 }
 
-func getQosLevel(root, file string) (int, error) {
-	var (
-		qosLevel int
-		rootQos  []byte
-		err      error
-	)
-
-	rootQos, err = util.ReadSmallFile(filepath.Join(root, file)) // nolint
+// getValue returns the content of file of root, which should be the same in all its sub cgroups
+func getValue(root, file string) (string, error) {
+	rootValue, err := cgroup.ReadValue(root, file)
 	if err != nil {
-		return constant.ErrCodeFailed, errors.Errorf("get root qos level failed: %v", err)
+		return "", errors.Errorf("get root qos level failed: %v", err)
 	}
 	// walk through all sub paths
 	if err = filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
 		if f != nil && f.IsDir() {
-			cgFilePath, err := securejoin.SecureJoin(path, file)
-			if err != nil {
-				return errors.Errorf("join path failed: %v", err)
-			}
-			data, err := util.ReadSmallFile(filepath.Clean(cgFilePath))
+			data, err := cgroup.ReadValue(path, file)
 			if err != nil {
 				return errors.Errorf("get qos level failed: %v", err)
 			}
-			if strings.Compare(string(data), string(rootQos)) != 0 {
+			if data != rootValue {
 				return errors.Errorf("qos differs")
 			}
 		}
 		return nil
 	}); err != nil {
+		return "", err
+	}
+	return rootValue, nil
+}
+
+func getQosLevel(root, file string) (int, error) {
+	value, err := getValue(root, file)
+	if err != nil {
 		return constant.ErrCodeFailed, err
 	}
-	qosLevel, err = strconv.Atoi(strings.TrimSpace(string(rootQos)))
+	qosLevel, err := strconv.Atoi(value)
 	if err != nil {
 		return constant.ErrCodeFailed, err
 	}
-
 	return qosLevel, nil
 }
 
@@ -205,6 +341,13 @@ func initCgroupPath(cgroupRoot, cgroupPath string) (map[string]string, error) {
 			return nil, err
 		}
 		fullPath := filepath.Join(cgroupRoot, kind, cgroupPath)
+		// memory.qos_level is not supported on the unified hierarchy, where cpu is not a sub directory
+		if cgroup.IsV2() {
+			if kind != "cpu" {
+				continue
+			}
+			fullPath = filepath.Join(cgroupRoot, cgroupPath)
+		}
 		if len(fullPath) > constant.MaxCgroupPathLen {
 			return nil, errors.Errorf("length of cgroup path exceeds max limit %d", constant.MaxCgroupPathLen)
 		}
@@ -215,7 +358,9 @@ func initCgroupPath(cgroupRoot, cgroupPath string) (map[string]string, error) {
 }
 
 func checkCgroupPath(path string) error {
-	pathPrefix, blacklist := "kubepods", []string{"kubepods", "kubepods/besteffort", "kubepods/burstable"}
+	pathPrefix := cgroup.KubepodsCgroupPath(corev1.PodQOSGuaranteed)
+	blacklist := []string{pathPrefix, cgroup.KubepodsCgroupPath(corev1.PodQOSBestEffort),
+		cgroup.KubepodsCgroupPath(corev1.PodQOSBurstable)}
 	cPath := filepath.Clean(path)
 
 	if !strings.HasPrefix(cPath, pathPrefix) {
@@ -25,6 +25,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/dryrun"
 	"isula.org/rubik/pkg/typedef"
 )
 
@@ -390,3 +391,43 @@ func TestPodInfo_SetQos(t *testing.T) {
 	err = validateQos(pod)
 	assert.Equal(t, true, err != nil)
 }
+
+// TestDryRunQos tests the qos level is only recorded in dry-run mode without failures
+func TestDryRunQos(t *testing.T) {
+	err := os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode)
+	assert.NoError(t, err)
+	defer os.RemoveAll(constant.TmpTestDir)
+	cgRoot, err := ioutil.TempDir(constant.TmpTestDir, t.Name())
+	assert.NoError(t, err)
+	dryrun.Enable(true)
+	defer dryrun.Enable(false)
+
+	pod := &typedef.PodInfo{
+		UID:        "poddryrun",
+		CgroupPath: "kubepods/besteffort/poddryrun",
+		CgroupRoot: cgRoot,
+		Offline:    true,
+	}
+	defer forgetValid(pod.UID)
+	cpuFile := filepath.Join(cgRoot, "cpu", pod.CgroupPath, constant.CPUCgroupFileName)
+	for _, kind := range SupportCgroupTypes {
+		dir := filepath.Join(cgRoot, kind, pod.CgroupPath)
+		assert.NoError(t, os.MkdirAll(dir, constant.DefaultDirMode))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, kind+".qos_level"), []byte("0"),
+			constant.DefaultFileMode))
+	}
+
+	records := len(dryrun.List())
+	assert.NoError(t, SetQosLevel(pod))
+	assert.Equal(t, records+len(SupportCgroupTypes), len(dryrun.List()))
+	b, err := ioutil.ReadFile(cpuFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", string(b))
+
+	// the pod recorded is not recorded again, and nothing is reconciled
+	assert.NoError(t, UpdateQosLevel(pod))
+	assert.Equal(t, records+len(SupportCgroupTypes), len(dryrun.List()))
+	corrections, err := reconcileQos(pod)
+	assert.NoError(t, err)
+	assert.Empty(t, corrections)
+}
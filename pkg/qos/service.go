@@ -0,0 +1,105 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-17
+// Description: qos service registration
+
+package qos
+
+import (
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+type qosService struct{}
+
+func init() {
+	services.Register(&qosService{})
+}
+
+// Name returns the service name
+func (s *qosService) Name() string {
+	return moduleName
+}
+
+// Enabled returns true as qos level setting is always enabled
+func (s *qosService) Enabled(cfg *config.Config) bool {
+	return true
+}
+
+// Init probes the cpu priority backend and the qos levels supported by the kernel
+func (s *qosService) Init(cfg *config.Config, cpm *checkpoint.Manager) error {
+	initBackend()
+	initLevelRange()
+	setFullCheckInterval(cfg.FullCheckInterval)
+	return nil
+}
+
+// Validate has nothing to check as fullCheckInterval is validated along with the global config
+func (s *qosService) Validate(cfg *config.Config) error {
+	return nil
+}
+
+// Reload applies the new full check interval to the validations cached
+func (s *qosService) Reload(cfg *config.Config) error {
+	setFullCheckInterval(cfg.FullCheckInterval)
+	return nil
+}
+
+// PodAdded sets qos level of the new pod
+func (s *qosService) PodAdded(pod *typedef.PodInfo) error {
+	return SetQosLevel(pod)
+}
+
+// PodUpdated checks and corrects qos level of the updated pod
+func (s *qosService) PodUpdated(old, new *typedef.PodInfo) error {
+	return UpdateQosLevel(new)
+}
+
+// PodDeleted forgets the validation of the pod as the pod cgroups are removed along with the pod
+func (s *qosService) PodDeleted(pod *typedef.PodInfo) error {
+	forgetValid(pod.UID)
+	return nil
+}
+
+// Sync sets qos level of all pods not in the default online level
+func (s *qosService) Sync(pods map[string]*typedef.PodInfo) error {
+	for _, pod := range pods {
+		if podLevel(pod) == constant.MaxLevel.Int() {
+			continue
+		}
+		if err := SetQosLevel(pod); err != nil {
+			log.Errorf("sync set pod %v qoslevel error: %v", pod.UID, err)
+		}
+	}
+	return nil
+}
+
+// Reconcile sets the qos level of the pods drifted again
+func (s *qosService) Reconcile(pods map[string]*typedef.PodInfo) ([]services.Correction, error) {
+	var corrections []services.Correction
+	for _, pod := range pods {
+		c, err := reconcileQos(pod)
+		if err != nil {
+			log.Errorf("reconcile pod %v qos level error: %v", pod.UID, err)
+		}
+		corrections = append(corrections, c...)
+	}
+	return corrections, nil
+}
+
+// Shutdown stops the service
+func (s *qosService) Shutdown() error {
+	return nil
+}
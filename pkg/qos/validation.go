@@ -0,0 +1,139 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-11-06
+// Description: cache of the pods whose qos level is validated
+
+package qos
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// validation is the qos level and the containers of a pod validated in full
+type validation struct {
+	level int
+	// containers maps the names of the containers validated to their IDs
+	containers map[string]string
+	time       time.Time
+}
+
+var (
+	validLock sync.Mutex
+	validated = make(map[string]*validation)
+	// fullCheckInterval is how long a validation is trusted, 0 means never
+	fullCheckInterval = time.Duration(constant.DefaultFullCheckInterval) * time.Second
+)
+
+func setFullCheckInterval(seconds int) {
+	validLock.Lock()
+	defer validLock.Unlock()
+	fullCheckInterval = time.Duration(seconds) * time.Second
+}
+
+// recordValid remembers the pod is validated in full
+func recordValid(pod *typedef.PodInfo) {
+	v := &validation{level: podLevel(pod), containers: make(map[string]string, len(pod.Containers)), time: time.Now()}
+	for name, c := range pod.Containers {
+		v.containers[name] = c.ID
+	}
+	validLock.Lock()
+	defer validLock.Unlock()
+	validated[pod.UID] = v
+}
+
+func forgetValid(uid string) {
+	validLock.Lock()
+	defer validLock.Unlock()
+	delete(validated, uid)
+}
+
+// newContainers returns the containers of the pod created since it is validated in full,
+// false is returned if the pod should be validated in full again, such as its level changes
+// or the validation is older than the full check interval
+func newContainers(pod *typedef.PodInfo) ([]*typedef.ContainerInfo, bool) {
+	validLock.Lock()
+	defer validLock.Unlock()
+	v, ok := validated[pod.UID]
+	if !ok || v.level != podLevel(pod) || fullCheckInterval == 0 || time.Since(v.time) >= fullCheckInterval {
+		return nil, false
+	}
+	var containers []*typedef.ContainerInfo
+	for name, c := range pod.Containers {
+		// the container being created has no cgroup yet
+		if c.ID != "" && v.containers[name] != c.ID {
+			containers = append(containers, c)
+		}
+	}
+	return containers, true
+}
+
+// validateQuick checks the qos level of the pod cgroup and the containers created since the pod is
+// validated in full, instead of walking through all its sub cgroups, and falls back to the full validation
+func validateQuick(pod *typedef.PodInfo) error {
+	containers, ok := newContainers(pod)
+	if !ok {
+		if err := validateQos(pod); err != nil {
+			return err
+		}
+		recordValid(pod)
+		return nil
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+
+	cgroupMap, err := initCgroupPath(pod.CgroupRoot, pod.CgroupPath)
+	if err != nil {
+		return err
+	}
+	for kind, fileValue := range desiredValues(podLevel(pod)) {
+		podPath, ok := cgroupMap[kind]
+		if !ok {
+			continue
+		}
+		file, desired := fileValue[0], fileValue[1]
+		dirs := []string{podPath}
+		for _, c := range containers {
+			dirs = append(dirs, cgroup.ContainerPath(kind, c))
+		}
+		for _, dir := range dirs {
+			actual, err := cgroup.ReadValue(dir, file)
+			if err != nil {
+				return errors.Errorf("read %s of %s failed: %v", file, dir, err)
+			}
+			if actual != desired {
+				return errors.Errorf("check level failed, %s of %s is %s, expect %s", file, dir, actual, desired)
+			}
+		}
+	}
+	recordContainers(pod, containers)
+	return nil
+}
+
+// recordContainers adds the containers validated to the validation of the pod
+func recordContainers(pod *typedef.PodInfo, containers []*typedef.ContainerInfo) {
+	validLock.Lock()
+	defer validLock.Unlock()
+	v, ok := validated[pod.UID]
+	if !ok {
+		return
+	}
+	for _, c := range containers {
+		v.containers[c.Name] = c.ID
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-11-06
+// Description: qos level validation cache test
+
+package qos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// TestUpdateQosLevelQuick tests only the new containers are checked on updates until the full check is due
+func TestUpdateQosLevelQuick(t *testing.T) {
+	assert.NoError(t, os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode))
+	defer os.RemoveAll(constant.TmpTestDir)
+	cgRoot, err := ioutil.TempDir(constant.TmpTestDir, "validation")
+	assert.NoError(t, err)
+	setBackend(newQosLevelBackend(), false)
+	defer setBackend(newQosLevelBackend(), true)
+	defer setFullCheckInterval(constant.DefaultFullCheckInterval)
+
+	const podPath = "kubepods/besteffort/poda5cb0d50-1234-1234-1234-e0ae4b7884b2"
+	podDir := filepath.Join(cgRoot, "cpu", podPath)
+	container := func(name string) *typedef.ContainerInfo {
+		assert.NoError(t, os.MkdirAll(filepath.Join(podDir, name), constant.DefaultDirMode))
+		return &typedef.ContainerInfo{Name: name, ID: name, CgroupRoot: cgRoot,
+			CgroupAddr: filepath.Join(podPath, name)}
+	}
+	level := func(elem ...string) string {
+		b, err := ioutil.ReadFile(filepath.Join(append([]string{podDir}, elem...)...))
+		assert.NoError(t, err)
+		return strings.TrimSpace(string(b))
+	}
+	pod := &typedef.PodInfo{UID: "poda5cb0d50", CgroupPath: podPath, CgroupRoot: cgRoot, Offline: true,
+		Containers: map[string]*typedef.ContainerInfo{"c1": container("c1")}}
+	assert.NoError(t, SetQosLevel(pod))
+	defer forgetValid(pod.UID)
+
+	// the new container with a wrong level is found and reset
+	pod.Containers["c2"] = container("c2")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(podDir, "c2", constant.CPUCgroupFileName),
+		[]byte("0"), constant.DefaultFileMode))
+	assert.NoError(t, UpdateQosLevel(pod))
+	assert.Equal(t, "-1", level("c2", constant.CPUCgroupFileName))
+	assert.NoError(t, UpdateQosLevel(pod))
+
+	// the sub cgroups of the containers known are left to the full check
+	assert.NoError(t, os.MkdirAll(filepath.Join(podDir, "c1", "sub"), constant.DefaultDirMode))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(podDir, "c1", "sub", constant.CPUCgroupFileName),
+		[]byte("0"), constant.DefaultFileMode))
+	assert.NoError(t, UpdateQosLevel(pod))
+	assert.Equal(t, "0", level("c1", "sub", constant.CPUCgroupFileName))
+	setFullCheckInterval(0)
+	assert.NoError(t, UpdateQosLevel(pod))
+	assert.Equal(t, "-1", level("c1", "sub", constant.CPUCgroupFileName))
+}
@@ -15,77 +15,123 @@
 package quota
 
 import (
-	"io/ioutil"
 	"math/big"
 	"os"
-	"path/filepath"
 
 	"github.com/pkg/errors"
 
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/services"
 	log "isula.org/rubik/pkg/tinylog"
 	"isula.org/rubik/pkg/typedef"
 )
 
+const (
+	moduleName = "quota"
+	burstFile  = "cpu.cfs_burst_us"
+)
 
 // SetPodsQuotaBurst sync pod's burst quota when autoconfig is set
 func SetPodsQuotaBurst(podInfos map[string]*typedef.PodInfo) {
 	for _, pi := range podInfos {
-		setPodQuotaBurst(pi)
+		if err := setPodQuotaBurst(pi); err != nil {
+			log.Errorf("sync set pod %v quota burst error: %v", pi.UID, err)
+		}
 	}
 }
 
-// UpdatePodQuotaBurst update pod's burst quota
-func UpdatePodQuotaBurst(opi, npi *typedef.PodInfo) {
+// UpdatePodQuotaBurst update pod's burst quota, the error of writing is returned to be retried
+func UpdatePodQuotaBurst(opi, npi *typedef.PodInfo) error {
 	// cpm.GetPod returns nil if pod.UID not exist
 	if opi == nil || npi == nil {
 		log.Errorf("quota-burst got invalid nil podInfo")
-		return
+		return nil
 	}
-	if opi.QuotaBurst == npi.QuotaBurst {
-		return
+	// containers restarted get new cgroups, which should be set again
+	if opi.QuotaBurst == npi.QuotaBurst && opi.SameContainers(npi) {
+		return nil
 	}
-	setPodQuotaBurst(npi)
+	return setPodQuotaBurst(npi)
 }
 
-// SetPodQuotaBurst set each container's cpu.cfs_burst_ns
-func SetPodQuotaBurst(podInfo *typedef.PodInfo) {
+// SetPodQuotaBurst set each container's cpu.cfs_burst_ns, the error of writing is returned to be retried
+func SetPodQuotaBurst(podInfo *typedef.PodInfo) error {
 	// cpm.GetPod returns nil if pod.UID not exist
 	if podInfo == nil {
 		log.Errorf("quota-burst got invalid nil podInfo")
-		return
+		return nil
 	}
-	setPodQuotaBurst(podInfo)
+	return setPodQuotaBurst(podInfo)
 }
 
-func setPodQuotaBurst(podInfo *typedef.PodInfo) {
-	if podInfo.QuotaBurst == constant.InvalidBurst {
-		return
+// setPodQuotaBurst sets all containers of the pod and returns the last error
+func setPodQuotaBurst(podInfo *typedef.PodInfo) error {
+	if podInfo.QuotaBurst == constant.InvalidBurst || !capability.Supported(capability.CfsBurst) {
+		return nil
 	}
 	burst := big.NewInt(podInfo.QuotaBurst).String()
+	var lastErr error
 	for _, c := range podInfo.Containers {
 		err := setCtrQuotaBurst([]byte(burst), c)
 		if err != nil {
 			log.Errorf("set container quota burst failed: %v", err)
+			lastErr = err
 		}
 	}
+	return lastErr
 }
 
 func setCtrQuotaBurst(burst []byte, c *typedef.ContainerInfo) error {
-	const (
-		fname = "cpu.cfs_burst_us"
-		subsys = "cpu"
-	)
-	cgpath := c.CgroupPath(subsys)
-	fpath := filepath.Join(cgpath, fname)
+	const subsys = "cpu"
+	// cpu.max.burst on cgroup v2 is also in microseconds
+	name, ok := cgroup.FileName(burstFile)
+	if !ok {
+		return nil
+	}
+	cgpath := cgroup.ContainerPath(subsys, c)
+	fpath, err := cgroup.Join(cgpath, name)
+	if err != nil {
+		return err
+	}
 
-	if _, err := os.Stat(fpath); err != nil && os.IsNotExist(err) {
+	if _, err := cgroup.ReadFile(fpath); err != nil && os.IsNotExist(err) {
 		return errors.Errorf("quota-burst path=%v missing", fpath)
 	}
 
-	if err := ioutil.WriteFile(fpath, burst, constant.DefaultFileMode); err != nil {
+	if err := journal.WriteFile(moduleName, fpath, string(burst), "set quota burst"); err != nil {
 		return errors.Errorf("quota-burst path=%v setting failed: %v", fpath, err)
 	}
 	log.Infof("quota-burst path=%v setting success", cgpath)
 	return nil
 }
+
+// reconcileQuotaBurst sets the quota burst of the containers of the pod drifted from its annotation again
+func reconcileQuotaBurst(podInfo *typedef.PodInfo) []services.Correction {
+	const subsys = "cpu"
+	if podInfo.QuotaBurst == constant.InvalidBurst || !capability.Supported(capability.CfsBurst) {
+		return nil
+	}
+	name, ok := cgroup.FileName(burstFile)
+	if !ok {
+		return nil
+	}
+	burst := big.NewInt(podInfo.QuotaBurst).String()
+	var corrections []services.Correction
+	for _, c := range podInfo.Containers {
+		cgpath := cgroup.ContainerPath(subsys, c)
+		actual, err := cgroup.ReadValue(cgpath, name)
+		if err != nil || actual == burst {
+			continue
+		}
+		if err := setCtrQuotaBurst([]byte(burst), c); err != nil {
+			log.Errorf("reconcile container quota burst failed: %v", err)
+			continue
+		}
+		fpath, _ := cgroup.Join(cgpath, name)
+		corrections = append(corrections, services.Correction{Pod: podInfo, File: fpath, Actual: actual, Desired: burst})
+	}
+	return corrections
+}
@@ -22,7 +22,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/journal"
 	"isula.org/rubik/pkg/typedef"
 )
 
@@ -214,6 +216,8 @@ func TestSetPodQuotaBurst(t *testing.T) {
 	}
 	err = setCtrQuotaBurst([]byte("0"), notExistPod.Containers["NotExist"])
 	assert.Contains(t, err.Error(), "missing")
+	// the error is returned to be retried
+	assert.Error(t, SetPodQuotaBurst(notExistPod))
 
 	for i, tt := range []podQuotaBurstTestCase{
 		{
@@ -243,3 +247,32 @@ func TestSetPodQuotaBurst(t *testing.T) {
 		})
 	}
 }
+
+// TestReconcileQuotaBurst tests the drifted quota burst is set again
+func TestReconcileQuotaBurst(t *testing.T) {
+	oldMode := cgroup.SetMode(cgroup.ModeV1)
+	defer cgroup.SetMode(oldMode)
+	fake := cgroup.NewFake()
+	oldBackend := cgroup.SetBackend(fake)
+	defer cgroup.SetBackend(oldBackend)
+	assert.NoError(t, journal.Init(""))
+	defer journal.Restore(moduleName)
+
+	pi := &typedef.PodInfo{UID: "testPod1", QuotaBurst: 1000,
+		Containers: map[string]*typedef.ContainerInfo{"FooCon": cis[0]}}
+	burstPath := filepath.Join(cis[0].CgroupPath(cpuSubsys), cfsBurstUs)
+	fake.Set(burstPath, "0\n")
+
+	corrections := reconcileQuotaBurst(pi)
+	assert.Len(t, corrections, 1)
+	assert.Equal(t, burstPath, corrections[0].File)
+	assert.Equal(t, "0", corrections[0].Actual)
+	assert.Equal(t, "1000", corrections[0].Desired)
+	v, _ := fake.Get(burstPath)
+	assert.Equal(t, "1000", v)
+	assert.Empty(t, reconcileQuotaBurst(pi))
+
+	pi.QuotaBurst = constant.InvalidBurst
+	fake.Set(burstPath, "0\n")
+	assert.Empty(t, reconcileQuotaBurst(pi))
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Yanting Song
+// Create: 2022-10-17
+// Description: quota burst service registration
+
+package quota
+
+import (
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+type quotaService struct{}
+
+func init() {
+	services.Register(&quotaService{})
+}
+
+// Name returns the service name
+func (s *quotaService) Name() string {
+	return moduleName
+}
+
+// Enabled returns true as quota burst is driven by pod annotation only
+func (s *quotaService) Enabled(cfg *config.Config) bool {
+	return true
+}
+
+// Init initializes the service, the quota burst annotations are ignored if the kernel does not support cfs burst
+func (s *quotaService) Init(cfg *config.Config, cpm *checkpoint.Manager) error {
+	if !capability.Supported(capability.CfsBurst) {
+		log.Infof("cfs burst is not supported by the kernel, quota burst of pods is not set")
+	}
+	return nil
+}
+
+// PodAdded sets quota burst of the new pod
+func (s *quotaService) PodAdded(pod *typedef.PodInfo) error {
+	return SetPodQuotaBurst(pod)
+}
+
+// PodUpdated sets quota burst of the updated pod if the burst value changes
+func (s *quotaService) PodUpdated(old, new *typedef.PodInfo) error {
+	return UpdatePodQuotaBurst(old, new)
+}
+
+// PodDeleted does nothing as the pod cgroups are removed along with the pod
+func (s *quotaService) PodDeleted(pod *typedef.PodInfo) error {
+	return nil
+}
+
+// Sync sets quota burst of all pods
+func (s *quotaService) Sync(pods map[string]*typedef.PodInfo) error {
+	SetPodsQuotaBurst(pods)
+	return nil
+}
+
+// Reconcile sets the quota burst of the containers drifted again
+func (s *quotaService) Reconcile(pods map[string]*typedef.PodInfo) ([]services.Correction, error) {
+	var corrections []services.Correction
+	for _, pod := range pods {
+		corrections = append(corrections, reconcileQuotaBurst(pod)...)
+	}
+	return corrections, nil
+}
+
+// Shutdown stops the service
+func (s *quotaService) Shutdown() error {
+	return nil
+}
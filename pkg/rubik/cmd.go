@@ -0,0 +1,223 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-25
+// Description: rubik command line
+
+package rubik
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/api"
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/util"
+	"isula.org/rubik/pkg/version"
+)
+
+const (
+	usage = `Usage: rubik [COMMAND]
+
+Commands:
+  daemon [--config PATH]   run rubik daemon, the default command
+  version                  show version information
+  check-config PATH        check config file and exit
+  status                   show status of the running rubik daemon
+  cleanup                  restore settings left by rubik, run it after rubik stops
+`
+	statusTimeout = 5 * time.Second
+)
+
+// Execute runs the rubik command specified by args
+func Execute(args []string) int {
+	if len(args) == 0 {
+		return Run(constant.ConfigFile)
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "daemon":
+		return daemonCmd(args)
+	case "version", "-v", "--version":
+		version.PrintVersion()
+		return 0
+	case "check-config":
+		return checkConfigCmd(args)
+	case "status":
+		return statusCmd(args)
+	case "cleanup":
+		if len(args) != 0 {
+			fmt.Print(usage)
+			return constant.ErrCodeFailed
+		}
+		return Cleanup()
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Printf("unknown command %q\n", cmd)
+		fmt.Print(usage)
+		return constant.ErrCodeFailed
+	}
+}
+
+func daemonCmd(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	cfgPath := fs.String("config", constant.ConfigFile, "path of config file")
+	if err := fs.Parse(args); err != nil {
+		return constant.ErrCodeFailed
+	}
+	if fs.NArg() != 0 {
+		fmt.Printf("unexpected arguments %v\n", fs.Args())
+		return constant.ErrCodeFailed
+	}
+	return Run(*cfgPath)
+}
+
+func checkConfigCmd(args []string) int {
+	if len(args) != 1 {
+		fmt.Print(usage)
+		return constant.ErrCodeFailed
+	}
+	if err := checkConfig(args[0]); err != nil {
+		fmt.Printf("config %s is invalid: %v\n", args[0], err)
+		return constant.ErrCodeFailed
+	}
+	fmt.Printf("config %s is valid\n", args[0])
+	return 0
+}
+
+// checkConfig loads the config file and validates it the same way as reload
+func checkConfig(path string) error {
+	if !util.PathExist(path) {
+		return errors.Errorf("file not exist")
+	}
+	cfg, err := config.NewConfig(path)
+	if err != nil {
+		return err
+	}
+	return validateConfig(cfg)
+}
+
+func statusCmd(args []string) int {
+	if len(args) != 0 {
+		fmt.Print(usage)
+		return constant.ErrCodeFailed
+	}
+	st, err := queryStatus(constant.RubikSock)
+	if err != nil {
+		fmt.Printf("query rubik status failed: %v\n", err)
+		return constant.ErrCodeFailed
+	}
+
+	fmt.Println("Version:        ", st.Version)
+	fmt.Println("Config File:    ", st.ConfigFile)
+	fmt.Println("Services:       ", strings.Join(st.Services, ","))
+	fmt.Println("Pods:           ", st.Pods)
+	fmt.Println("Pending Events: ", st.PendingEvents)
+	fmt.Println("Journal Entries:", st.JournalEntries)
+	fmt.Println("Cgroup Mode:    ", st.CgroupMode)
+	if st.PodSource != "" {
+		fmt.Println("Pod Source:     ", st.PodSource)
+	}
+	if len(st.Unsupported) != 0 {
+		fmt.Println("Unsupported:    ", strings.Join(st.Unsupported, ","))
+	}
+	if len(st.Capabilities) != 0 {
+		supported, missing := splitCapabilities(st.Capabilities)
+		fmt.Println("Capabilities:   ", strings.Join(supported, ","))
+		fmt.Println("Missing:        ", strings.Join(missing, ","))
+	}
+	return 0
+}
+
+// splitCapabilities returns the names of the supported and the missing capabilities in order
+func splitCapabilities(caps map[string]bool) ([]string, []string) {
+	var supported, missing []string
+	for name, ok := range caps {
+		if ok {
+			supported = append(supported, name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(supported)
+	sort.Strings(missing)
+	return supported, missing
+}
+
+// queryStatus gets status from the rubik daemon listening on sock
+func queryStatus(sock string) (*api.StatusResponse, error) {
+	client := &http.Client{
+		Timeout: statusTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	resp, err := client.Get("http://localhost/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var st api.StatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, errors.Errorf("decode status failed: %v", err)
+	}
+	return &st, nil
+}
+
+// Status returns the status of the running rubik
+func (r *Rubik) Status() *api.StatusResponse {
+	st := &api.StatusResponse{
+		Version:        version.Version,
+		ConfigFile:     r.cfgPath,
+		Services:       []string{},
+		JournalEntries: journal.Len(),
+		CgroupMode:     string(cgroup.GetMode()),
+		Unsupported:    cgroup.ListUnsupported(),
+		Capabilities:   capability.Report(),
+	}
+	for _, s := range r.listServices() {
+		st.Services = append(st.Services, s.Name())
+	}
+	if r.cpm != nil {
+		st.Pods = len(r.cpm.ListAllPods())
+	}
+	if r.queue != nil {
+		st.PendingEvents = r.queue.Len()
+	}
+	if r.podSource != nil {
+		st.PodSource = r.podSource.Active()
+	}
+	return st
+}
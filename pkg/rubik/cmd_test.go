@@ -0,0 +1,99 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Xiang Li
+// Create: 2022-10-25
+// Description: rubik command line test
+
+package rubik
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/api"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/try"
+)
+
+// TestExecute tests commands parse
+func TestExecute(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	cfgFile := filepath.Join(constant.TmpTestDir, "config.json")
+
+	tests := []struct {
+		name string
+		args []string
+		cfg  string
+		want int
+	}{
+		{name: "TC1-unknown command", args: []string{"invalid"}, want: constant.ErrCodeFailed},
+		{name: "TC2-version", args: []string{"version"}, want: 0},
+		{name: "TC3-help", args: []string{"--help"}, want: 0},
+		{name: "TC4-daemon with invalid flag", args: []string{"daemon", "--invalid"}, want: constant.ErrCodeFailed},
+		{name: "TC5-check-config without path", args: []string{"check-config"}, want: constant.ErrCodeFailed},
+		{name: "TC6-check-config file missing", args: []string{"check-config", cfgFile + "missing"},
+			want: constant.ErrCodeFailed},
+		{name: "TC7-check-config valid", args: []string{"check-config", cfgFile},
+			cfg: `{"logLevel": "debug", "memoryConfig": {"enable": true, "strategy": "fssr"}}`, want: 0},
+		{name: "TC8-check-config invalid json", args: []string{"check-config", cfgFile},
+			cfg: `{"logLevel": `, want: constant.ErrCodeFailed},
+		{name: "TC9-check-config invalid log level", args: []string{"check-config", cfgFile},
+			cfg: `{"logLevel": "invalid"}`, want: constant.ErrCodeFailed},
+		{name: "TC10-check-config invalid worker number", args: []string{"check-config", cfgFile},
+			cfg: `{"workerNum": -1}`, want: constant.ErrCodeFailed},
+		{name: "TC11-check-config invalid memory strategy", args: []string{"check-config", cfgFile},
+			cfg: `{"memoryConfig": {"enable": true, "strategy": "invalid"}}`, want: constant.ErrCodeFailed},
+		{name: "TC12-status with extra args", args: []string{"status", "extra"}, want: constant.ErrCodeFailed},
+		{name: "TC13-cleanup with extra args", args: []string{"cleanup", "extra"}, want: constant.ErrCodeFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cfg != "" {
+				try.WriteFile(cfgFile, []byte(tt.cfg), constant.DefaultFileMode).OrDie()
+			}
+			assert.Equal(t, tt.want, Execute(tt.args))
+		})
+	}
+}
+
+// TestQueryStatus tests status query over unix socket
+func TestQueryStatus(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	sock := filepath.Join(constant.TmpTestDir, "rubik.sock")
+
+	_, err := queryStatus(sock)
+	assert.Error(t, err)
+
+	l, err := net.Listen("unix", sock)
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewEncoder(w).Encode(&api.StatusResponse{Services: []string{"qos"}, Pods: 2}))
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(l)
+	defer server.Close()
+
+	st, err := queryStatus(sock)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"qos"}, st.Services)
+	assert.Equal(t, 2, st.Pods)
+
+	r := &Rubik{cfgPath: "config.json"}
+	assert.Equal(t, "config.json", r.Status().ConfigFile)
+	assert.Empty(t, r.Status().Services)
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-11-01
+// Description: periodic reconciliation of the settings drifted
+
+package rubik
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/eventqueue"
+	"isula.org/rubik/pkg/metrics"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
+)
+
+var reconcileCorrections = metrics.NewCounter("rubik_reconcile_corrections_total",
+	"Number of drifted settings repaired by reconciliation.",
+	metrics.LabelModule, metrics.LabelNamespace, metrics.LabelPod)
+
+// validateReconcileInterval checks the reconcile interval in seconds, 0 disables reconciliation
+func validateReconcileInterval(interval int) error {
+	if interval == 0 {
+		return nil
+	}
+	if interval < constant.MinReconcileInterval || interval > constant.MaxReconcileInterval {
+		return errors.Errorf("invalid reconcileInterval %d, expect 0 or [%d, %d]",
+			interval, constant.MinReconcileInterval, constant.MaxReconcileInterval)
+	}
+	return nil
+}
+
+// reconcileLoop reconciles the settings of all pods periodically until rubik shuts down,
+// the interval is read in every round so that the reloaded one takes effect from the next round
+func (r *Rubik) reconcileLoop() {
+	for {
+		r.lock.RLock()
+		seconds := r.config.ReconcileInterval
+		r.lock.RUnlock()
+		// check again later whether reconciliation is enabled by reload
+		enabled := seconds != 0
+		if !enabled {
+			seconds = constant.MinReconcileInterval
+		}
+
+		select {
+		case <-config.ShutdownChan:
+			return
+		case <-time.After(time.Duration(seconds) * time.Second):
+		}
+		if enabled {
+			r.Reconcile()
+		}
+	}
+}
+
+// Reconcile hands a reconcile job of every pod in the checkpoint over to the event queue, so that the
+// repairs are ordered with the other jobs of the pod and never reapply the settings replaced by them
+func (r *Rubik) Reconcile() {
+	for uid := range r.cpm.ListAllPods() {
+		uid := uid
+		r.dispatch(uid, &eventqueue.Job{
+			Desc: "reconcile",
+			Do: func() error {
+				r.reconcilePod(uid)
+				return nil
+			},
+		})
+	}
+}
+
+// reconcilePod compares the desired settings of the pod with the actual ones in every service and repairs
+// the differences, it returns the number of corrections. The errors are not retried as the next round does.
+func (r *Rubik) reconcilePod(uid string) int {
+	// the pod deleted after the job is dispatched has nothing to reconcile
+	pod := r.cpm.GetPod(types.UID(uid))
+	if pod == nil {
+		return 0
+	}
+	pods := map[string]*typedef.PodInfo{uid: pod}
+	total := 0
+	// hold the lock so that the services stopped by reload do not reapply the settings restored
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, s := range r.services {
+		rc, ok := s.(services.Reconciler)
+		if !ok {
+			continue
+		}
+		corrections, err := rc.Reconcile(pods)
+		if err != nil {
+			log.Errorf("service %s reconcile pod %s error: %v", s.Name(), uid, err)
+		}
+		for _, c := range corrections {
+			log.Logf("service %s corrects %s of pod %s/%s(%s) from %q to %q",
+				s.Name(), c.File, c.Pod.Namespace, c.Pod.Name, c.Pod.UID, c.Actual, c.Desired)
+			reconcileCorrections.Inc(s.Name(), c.Pod.Namespace, c.Pod.Name)
+		}
+		total += len(corrections)
+	}
+	if total != 0 {
+		log.Infof("reconcile corrected %d drifted settings of pod %s/%s", total, pod.Namespace, pod.Name)
+	}
+	return total
+}
@@ -0,0 +1,200 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-24
+// Description: config hot reload
+
+package rubik
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/cgroup"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/eventqueue"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/metrics"
+	"isula.org/rubik/pkg/podpolicy"
+	"isula.org/rubik/pkg/podsource"
+	"isula.org/rubik/pkg/services"
+	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/util"
+)
+
+// reloadDelay merges the bursts of file events generated by one config update
+const reloadDelay = 200 * time.Millisecond
+
+// reloadChan receives reload requests from SIGHUP
+var reloadChan = make(chan struct{}, 1)
+
+// watchConfig reloads config when the config file changes or SIGHUP received until rubik shuts down
+func (r *Rubik) watchConfig() {
+	fileChan, err := config.Watch(r.cfgPath, config.ShutdownChan)
+	if err != nil {
+		log.Errorf("watch config file failed: %v, reload with SIGHUP only", err)
+	}
+
+	for {
+		select {
+		case <-config.ShutdownChan:
+			return
+		case <-reloadChan:
+		case <-fileChan:
+			time.Sleep(reloadDelay)
+			select {
+			case <-fileChan:
+			default:
+			}
+		}
+		if err := r.Reload(); err != nil {
+			log.Errorf("reload config failed, keep the current config: %v", err)
+		}
+	}
+}
+
+// Reload loads the config file again and applies the differences to services,
+// the current config is kept if the new one is invalid
+func (r *Rubik) Reload() error {
+	if !util.PathExist(r.cfgPath) {
+		return errors.Errorf("config file %s not exist", r.cfgPath)
+	}
+	// the global cgroup root can not change while running
+	cfg, err := config.LoadConfig(r.cfgPath)
+	if err != nil {
+		return errors.Errorf("load config failed: %v", err)
+	}
+	if err := r.validate(cfg); err != nil {
+		return err
+	}
+	if err := log.InitConfig(cfg.LogDriver, cfg.LogDir, cfg.LogLevel, int64(cfg.LogSize)); err != nil {
+		return errors.Errorf("init log config failed: %v", err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// switch dry-run mode first so that the services reloaded follow the new mode
+	dryrun.Enable(cfg.DryRun)
+	r.reloadServices(cfg)
+	r.config = cfg
+	log.Logf("Reload rubik with cfg\n%v", cfg)
+	return nil
+}
+
+// validate checks the new config before anything is applied
+func (r *Rubik) validate(cfg *config.Config) error {
+	if cfg.CgroupRoot != r.config.CgroupRoot || cfg.WorkerNum != r.config.WorkerNum ||
+		cfg.CgroupDriver != r.config.CgroupDriver || cfg.KubeletCgroupRoot != r.config.KubeletCgroupRoot ||
+		cfg.MetricsAddr != r.config.MetricsAddr || !reflect.DeepEqual(cfg.PodPolicy, r.config.PodPolicy) ||
+		!reflect.DeepEqual(cfg.PodSource, r.config.PodSource) {
+		log.Infof("cgroupRoot, cgroupDriver, kubeletCgroupRoot, workerNum, metricsAddr, podPolicy and podSource " +
+			"changes take effect after restart")
+		cfg.CgroupRoot, cfg.WorkerNum = r.config.CgroupRoot, r.config.WorkerNum
+		cfg.CgroupDriver, cfg.KubeletCgroupRoot = r.config.CgroupDriver, r.config.KubeletCgroupRoot
+		cfg.MetricsAddr, cfg.PodPolicy = r.config.MetricsAddr, r.config.PodPolicy
+		cfg.PodSource = r.config.PodSource
+	}
+	return validateConfig(cfg)
+}
+
+// validateConfig checks the config of log, event queue and the services enabled
+func validateConfig(cfg *config.Config) error {
+	if err := eventqueue.ValidateWorkers(cfg.WorkerNum); err != nil {
+		return err
+	}
+	if err := cgroup.ValidateDriver(cfg.CgroupDriver); err != nil {
+		return err
+	}
+	if err := metrics.ValidateAddr(cfg.MetricsAddr); err != nil {
+		return err
+	}
+	if err := podpolicy.Validate(cfg.PodPolicy); err != nil {
+		return err
+	}
+	if err := podsource.ValidateConfig(cfg.PodSource); err != nil {
+		return err
+	}
+	if err := validateReconcileInterval(cfg.ReconcileInterval); err != nil {
+		return err
+	}
+	if err := validateFullCheckInterval(cfg.FullCheckInterval); err != nil {
+		return err
+	}
+	if err := log.ValidateConfig(cfg.LogDriver, cfg.LogLevel, int64(cfg.LogSize)); err != nil {
+		return errors.Errorf("invalid log config: %v", err)
+	}
+	for _, s := range services.List() {
+		rl, ok := s.(services.Reloader)
+		if !ok || !s.Enabled(cfg) {
+			continue
+		}
+		if err := rl.Validate(cfg); err != nil {
+			return errors.Errorf("invalid config of service %s: %v", s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// reloadServices starts, stops or reloads services according to the new config, the caller must hold the lock
+func (r *Rubik) reloadServices(cfg *config.Config) {
+	running := make(map[string]bool, len(r.services))
+	for _, s := range r.services {
+		running[s.Name()] = true
+	}
+
+	var enabled []services.Service
+	for _, s := range services.List() {
+		was, now := running[s.Name()], s.Enabled(cfg)
+		switch {
+		case was && !now:
+			r.stopService(s)
+			log.Infof("service %s is disabled", s.Name())
+		case !was && now:
+			if err := s.Init(cfg, r.cpm); err != nil {
+				log.Errorf("init service %s failed: %v", s.Name(), err)
+				continue
+			}
+			// apply the service to the pods running before it is enabled
+			if err := s.Sync(r.cpm.ListAllPods()); err != nil {
+				log.Errorf("service %s sync error: %v", s.Name(), err)
+			}
+			enabled = append(enabled, s)
+			log.Infof("service %s is enabled", s.Name())
+		case was && now:
+			if rl, ok := s.(services.Reloader); ok {
+				if err := rl.Reload(cfg); err != nil {
+					// the service may be half stopped, take it down rather than keep it running broken
+					log.Errorf("reload service %s failed: %v, stop it", s.Name(), err)
+					r.stopService(s)
+					continue
+				}
+			}
+			enabled = append(enabled, s)
+		}
+	}
+	r.services = enabled
+}
+
+// stopService drops the jobs of the service queued, then shuts it down and restores the settings it changed,
+// so that no job left reapplies the settings restored
+func (r *Rubik) stopService(s services.Service) {
+	if r.queue != nil {
+		r.queue.Drop(s.Name())
+	}
+	if err := s.Shutdown(); err != nil {
+		log.Errorf("shutdown service %s error: %v", s.Name(), err)
+	}
+	if err := journal.Restore(s.Name()); err != nil {
+		log.Errorf("restore settings of service %s failed: %v", s.Name(), err)
+	}
+}
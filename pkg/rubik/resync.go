@@ -0,0 +1,68 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-11-06
+// Description: skip the pod updates changing nothing
+
+package rubik
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/metrics"
+)
+
+var skippedUpdates = metrics.NewCounter("rubik_skipped_updates_total",
+	"Number of pod updates skipped as nothing rubik applies settings by changes.")
+
+// validateFullCheckInterval checks the full check interval in seconds, 0 checks every update in full
+func validateFullCheckInterval(interval int) error {
+	if interval == 0 {
+		return nil
+	}
+	if interval < constant.MinFullCheckInterval || interval > constant.MaxFullCheckInterval {
+		return errors.Errorf("invalid fullCheckInterval %d, expect 0 or [%d, %d]",
+			interval, constant.MinFullCheckInterval, constant.MaxFullCheckInterval)
+	}
+	return nil
+}
+
+// fullCheckDue tells whether the pod should be passed to the services even if it is unchanged,
+// which happens once in the full check interval so that the settings are still checked now and then
+func (r *Rubik) fullCheckDue(uid string) bool {
+	r.lock.RLock()
+	seconds := r.config.FullCheckInterval
+	r.lock.RUnlock()
+	if seconds == 0 {
+		return true
+	}
+	r.checkLock.Lock()
+	defer r.checkLock.Unlock()
+	last, ok := r.checked[uid]
+	return !ok || time.Since(last) >= time.Duration(seconds)*time.Second
+}
+
+func (r *Rubik) markChecked(uid string) {
+	r.checkLock.Lock()
+	defer r.checkLock.Unlock()
+	if r.checked == nil {
+		r.checked = make(map[string]time.Time)
+	}
+	r.checked[uid] = time.Now()
+}
+
+func (r *Rubik) forgetChecked(uid string) {
+	r.checkLock.Lock()
+	defer r.checkLock.Unlock()
+	delete(r.checked, uid)
+}
@@ -15,43 +15,70 @@
 package rubik
 
 import (
-	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/coreos/go-systemd/daemon"
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
-	"isula.org/rubik/pkg/autoconfig"
-	"isula.org/rubik/pkg/blkio"
-	"isula.org/rubik/pkg/cachelimit"
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/checkpoint"
 	"isula.org/rubik/pkg/config"
 	"isula.org/rubik/pkg/constant"
-	"isula.org/rubik/pkg/memory"
-	"isula.org/rubik/pkg/perf"
-	"isula.org/rubik/pkg/qos"
-	"isula.org/rubik/pkg/quota"
-	"isula.org/rubik/pkg/sync"
+	"isula.org/rubik/pkg/dryrun"
+	"isula.org/rubik/pkg/eventqueue"
+	"isula.org/rubik/pkg/events"
+	"isula.org/rubik/pkg/httpserver"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/metrics"
+	"isula.org/rubik/pkg/podpolicy"
+	"isula.org/rubik/pkg/podsource"
+	"isula.org/rubik/pkg/services"
 	log "isula.org/rubik/pkg/tinylog"
+	"isula.org/rubik/pkg/typedef"
 	"isula.org/rubik/pkg/util"
+
+	// feature modules register themselves to services
+	_ "isula.org/rubik/pkg/blkio"
+	_ "isula.org/rubik/pkg/cachelimit"
+	_ "isula.org/rubik/pkg/memory"
+	_ "isula.org/rubik/pkg/memprotect"
+	_ "isula.org/rubik/pkg/qos"
+	_ "isula.org/rubik/pkg/quota"
 )
 
 // Rubik defines rubik struct
 type Rubik struct {
+	// lock protects config and services changed by reload
+	lock       sync.RWMutex
 	config     *config.Config
+	cfgPath    string
+	kubeConfig *rest.Config
 	kubeClient *kubernetes.Clientset
+	podSource  *podsource.Switcher
 	cpm        *checkpoint.Manager
-	mm         *memory.MemoryManager
-	nodeName   string
+	services   []services.Service
+	queue      *eventqueue.Queue
+	server     *http.Server
+	// metricsServer is nil if the metrics listener is disabled
+	metricsServer *http.Server
+	nodeName      string
+	// checkLock protects checked, the time each pod is last checked in full by the services
+	checkLock sync.Mutex
+	checked   map[string]time.Time
 }
 
 // NewRubik creates a new rubik object
@@ -65,8 +92,24 @@ func NewRubik(cfgPath string) (*Rubik, error) {
 		return nil, errors.Errorf("init log config failed: %v", err)
 	}
 
+	if err = cgroup.Init(cfg); err != nil {
+		return nil, err
+	}
+	capability.Probe(cfg)
+	if err = podpolicy.Init(cfg.PodPolicy); err != nil {
+		return nil, err
+	}
+	if err = journal.Init(constant.JournalFile); err != nil {
+		return nil, err
+	}
+	dryrun.Enable(cfg.DryRun)
+
+	if cfgPath == "" {
+		cfgPath = constant.ConfigFile
+	}
 	r := &Rubik{
-		config: cfg,
+		config:  cfg,
+		cfgPath: cfgPath,
 	}
 
 	if err := r.initComponents(); err != nil {
@@ -77,62 +120,199 @@ func NewRubik(cfgPath string) (*Rubik, error) {
 }
 
 func (r *Rubik) initComponents() error {
-	if err := r.initKubeClient(); err != nil {
+	if err := r.initPodSource(); err != nil {
+		return err
+	}
+
+	// events are posted since the checkpoint is initialized, which may find invalid annotations,
+	// and they can only be posted through the apiserver
+	if r.kubeClient != nil {
+		events.Init(r.kubeClient, r.nodeName)
+	}
+
+	stale, err := r.initCheckpoint()
+	if err != nil {
 		return err
 	}
 
-	if err := r.initCheckpoint(); err != nil {
+	if err := r.initServices(); err != nil {
 		return err
 	}
 
+	if err := r.initEventQueue(); err != nil {
+		return err
+	}
+	// the pods deleted while rubik is down are cleaned up as if the delete events are received
+	for _, pi := range stale {
+		log.Infof("clean up pod %s/%s(%s) deleted during restart", pi.Namespace, pi.Name, pi.UID)
+		r.deletePod(pi)
+	}
+
 	if err := r.initEventHandler(); err != nil {
 		return err
 	}
 
-	if r.config.MemCfg.Enable {
-		if err := r.initMemoryManager(); err != nil {
-			return err
+	return nil
+}
+
+// initEventQueue starts the queue which handles pod events of services asynchronously
+func (r *Rubik) initEventQueue() error {
+	queue, err := eventqueue.New(r.config.WorkerNum)
+	if err != nil {
+		return err
+	}
+
+	queue.Run(config.ShutdownChan)
+	r.queue = queue
+	log.Infof("the event queue is initialized successfully")
+	return nil
+}
+
+// dispatch hands the job of the pod over to the event queue, or does it directly if there is no queue
+func (r *Rubik) dispatch(podID string, job *eventqueue.Job) {
+	if r.queue != nil {
+		r.queue.Add(podID, job)
+		return
+	}
+	if err := job.Do(); err != nil {
+		log.Errorf("pod %s job %s failed: %v", podID, job.Desc, err)
+	}
+}
+
+// dispatchServices dispatches a job of the event to every running service, the services are locked
+// so that none stopped by reload gets a job after its queued ones are dropped
+func (r *Rubik) dispatchServices(podID string, event string, do func(s services.Service) error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, s := range r.services {
+		s := s
+		r.dispatch(podID, &eventqueue.Job{
+			Desc:  s.Name() + " " + event,
+			Owner: s.Name(),
+			Do:    func() error { return do(s) },
+		})
+	}
+}
+
+// Serve starts the http server on rubik unix socket
+func (r *Rubik) Serve() error {
+	server, sock, err := httpserver.NewServer(constant.RubikSock, r, r.cpm)
+	if err != nil {
+		return err
+	}
+
+	r.server = server
+	go func() {
+		if err := server.Serve(sock); err != nil && err != http.ErrServerClosed {
+			log.Errorf("http server exited with error: %v", err)
 		}
+	}()
+	log.Infof("the http server is listening on %s", constant.RubikSock)
+	return nil
+}
+
+// ServeMetrics starts the metrics http server if metricsAddr is configured
+func (r *Rubik) ServeMetrics() error {
+	addr := r.config.MetricsAddr
+	if addr == "" {
+		return nil
+	}
+	server, listener, err := metrics.NewServer(addr)
+	if err != nil {
+		return err
 	}
 
+	r.metricsServer = server
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("metrics server exited with error: %v", err)
+		}
+	}()
+	log.Infof("the metrics server is listening on %s", addr)
 	return nil
 }
 
-// Monitor monitors shutdown signal
+// Monitor monitors shutdown signal and returns when the settings are restored
 func (r *Rubik) Monitor() {
 	<-config.ShutdownChan
-	os.Exit(1)
+	if r.server != nil {
+		httpserver.Shutdown(r.server, constant.RubikSock)
+	}
+	if r.metricsServer != nil {
+		metrics.Shutdown(r.metricsServer, r.config.MetricsAddr)
+	}
+	// the queue is stopped by the shutdown channel, the jobs in progress may overwrite the settings restored
+	if r.queue != nil {
+		r.queue.Wait()
+	}
+	r.shutdownServices()
+	events.Shutdown()
+	if err := journal.Restore(""); err != nil {
+		log.Errorf("restore settings failed: %v", err)
+	}
 }
 
-// Sync sync pods qos level
+// Sync checks and corrects settings of all pods in every service
 func (r *Rubik) Sync() error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 	if !r.config.AutoCheck {
 		return nil
 	}
-	return sync.Sync(r.cpm.ListOfflinePods())
+	pods := r.cpm.ListAllPods()
+	for _, s := range r.services {
+		if err := s.Sync(pods); err != nil {
+			log.Errorf("service %s sync error: %v", s.Name(), err)
+		}
+	}
+	return nil
 }
 
-// CacheLimit init cache limit module
-func (r *Rubik) CacheLimit() error {
-	if r.config.CacheCfg.Enable {
-		if r.cpm == nil {
-			return fmt.Errorf("checkpoint is not initialized before cachelimit")
+// initServices initializes the registered services enabled in config
+func (r *Rubik) initServices() error {
+	if r.cpm == nil {
+		return fmt.Errorf("checkpoint is not initialized before services")
+	}
+
+	for _, s := range services.List() {
+		if !s.Enabled(r.config) {
+			log.Infof("service %s is disabled", s.Name())
+			// roll back settings left by the service when it was enabled last time
+			if err := journal.Restore(s.Name()); err != nil {
+				log.Errorf("restore settings of service %s failed: %v", s.Name(), err)
+			}
+			continue
+		}
+		if err := s.Init(r.config, r.cpm); err != nil {
+			return errors.Errorf("init service %s failed: %v", s.Name(), err)
 		}
-		return cachelimit.Init(r.cpm, &r.config.CacheCfg)
+		r.services = append(r.services, s)
+		log.Infof("service %s is initialized successfully", s.Name())
 	}
 	return nil
 }
 
-// QuotaBurst sync all pod's cpu burst quota
-func (r *Rubik) QuotaBurst() {
-	if r.config.AutoCheck {
-		quota.SetPodsQuotaBurst(r.cpm.ListAllPods())
+// shutdownServices stops the services in reverse order of initialization
+func (r *Rubik) shutdownServices() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i := len(r.services) - 1; i >= 0; i-- {
+		if err := r.services[i].Shutdown(); err != nil {
+			log.Errorf("shutdown service %s error: %v", r.services[i].Name(), err)
+		}
 	}
 }
 
-// initKubeClient initialize kubeClient if autoconfig is enabled
+// listServices returns the running services
+func (r *Rubik) listServices() []services.Service {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]services.Service{}, r.services...)
+}
+
+// initKubeClient initialize kubeClient with the kubeconfig file, or the in-cluster config if it is not set
 func (r *Rubik) initKubeClient() error {
-	conf, err := rest.InClusterConfig()
+	conf, err := podsource.KubeConfig(r.config.PodSource.Kubeconfig)
 	if err != nil {
 		return err
 	}
@@ -142,60 +322,86 @@ func (r *Rubik) initKubeClient() error {
 		return err
 	}
 
+	r.kubeConfig = conf
 	r.kubeClient = kubeClient
 	log.Infof("the kube-client is initialized successfully")
 	return nil
 }
 
-// initEventHandler initialize the event handler and set the rubik callback function corresponding to the pod event.
-func (r *Rubik) initEventHandler() error {
-	if r.kubeClient == nil {
-		return fmt.Errorf("kube-client is not initialized")
-	}
-
-	autoconfig.Backend = r
-	if err := autoconfig.Init(r.kubeClient, r.nodeName); err != nil {
+// initPodSource creates the pod sources configured, the ones failed to create are skipped,
+// so that rubik starts with kubelet even if the apiserver can not be accessed
+func (r *Rubik) initPodSource() error {
+	cfg := r.config.PodSource
+	if err := podsource.ValidateConfig(cfg); err != nil {
 		return err
 	}
+	r.nodeName = os.Getenv(constant.NodeNameEnvKey)
+	var (
+		sources []podsource.Source
+		errs    []string
+	)
+	for _, name := range cfg.Sources {
+		src, err := r.newPodSource(name)
+		if err != nil {
+			log.Errorf("pod source %s is unavailable: %v", name, err)
+			errs = append(errs, name+": "+err.Error())
+			continue
+		}
+		sources = append(sources, src)
+	}
+	if len(sources) == 0 {
+		return errors.Errorf("no pod source available: %s", strings.Join(errs, "; "))
+	}
 
-	log.Infof("the event-handler is initialized successfully")
+	r.podSource = podsource.NewSwitcher(sources, r, time.Duration(cfg.CheckInterval)*time.Second)
+	log.Infof("the pod source is initialized successfully")
 	return nil
 }
 
-func (r *Rubik) initMemoryManager() error {
-	mm, err := memory.NewMemoryManager(r.cpm, r.config.MemCfg)
-	if err != nil {
-		return err
+// newPodSource creates the pod source of the name validated
+func (r *Rubik) newPodSource(name string) (podsource.Source, error) {
+	if name == podsource.Kubelet {
+		return podsource.NewKubelet(r.config.PodSource)
+	}
+	if err := r.initKubeClient(); err != nil {
+		return nil, err
+	}
+	if r.nodeName == "" {
+		return nil, errors.Errorf("missing %s", constant.NodeNameEnvKey)
+	}
+	return podsource.NewAPIServer(r.kubeConfig, r.nodeName)
+}
+
+// initEventHandler starts watching the pods and set the rubik callback function corresponding to the pod event.
+func (r *Rubik) initEventHandler() error {
+	if r.podSource == nil {
+		return fmt.Errorf("pod source is not initialized")
 	}
 
-	r.mm = mm
-	log.Infof("init memory manager ok")
+	r.podSource.Run(config.ShutdownChan)
+	log.Infof("the event-handler is initialized successfully")
 	return nil
 }
 
-func (r *Rubik) initCheckpoint() error {
-	if r.kubeClient == nil {
-		return fmt.Errorf("kube-client is not initialized")
+func (r *Rubik) initCheckpoint() ([]*typedef.PodInfo, error) {
+	if r.podSource == nil {
+		return nil, fmt.Errorf("pod source is not initialized")
 	}
 
 	cpm := checkpoint.NewManager(r.config.CgroupRoot)
-	node := os.Getenv(constant.NodeNameEnvKey)
-	if node == "" {
-		return fmt.Errorf("missing %s", constant.NodeNameEnvKey)
-	}
-
-	r.nodeName = node
-	const specNodeNameFormat = "spec.nodeName=%s"
-	pods, err := r.kubeClient.CoreV1().Pods("").List(context.Background(),
-		metav1.ListOptions{FieldSelector: fmt.Sprintf(specNodeNameFormat, node)})
+	pods, err := r.podSource.List()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// the checkpoint failed to load is rebuilt from the cluster, only the module states are lost
+	if err := cpm.Load(constant.CheckpointFile); err != nil {
+		log.Errorf("load checkpoint failed, rebuild it from cluster: %v", err)
 	}
-	cpm.SyncFromCluster(pods.Items)
+	stale := cpm.SyncFromCluster(pods)
 
 	r.cpm = cpm
 	log.Infof("the checkpoint is initialized successfully")
-	return nil
+	return stale, nil
 }
 
 // AddEvent handle add event from informer
@@ -207,17 +413,8 @@ func (r *Rubik) AddEvent(pod *corev1.Pod) {
 	r.cpm.AddPod(pod)
 
 	pi := r.cpm.GetPod(pod.UID)
-	if err := qos.SetQosLevel(pi); err != nil {
-		log.Errorf("AddEvent handle error: %v", err)
-	}
-	quota.SetPodQuotaBurst(pi)
-
-	if r.config.BlkioCfg.Enable {
-		blkio.SetBlkio(pod)
-	}
-	if r.config.MemCfg.Enable {
-		r.mm.UpdateConfig(pi)
-	}
+	r.markChecked(pi.UID)
+	r.dispatchServices(pi.UID, "add", func(s services.Service) error { return s.PodAdded(pi) })
 }
 
 // UpdateEvent handle update event from informer
@@ -225,43 +422,84 @@ func (r *Rubik) UpdateEvent(oldPod *corev1.Pod, newPod *corev1.Pod) {
 	// Rubik does not process updates of pods that are not in the running state
 	// if the container is not running, delete it.
 	if newPod.Status.Phase != corev1.PodRunning {
-		r.cpm.DelPod(newPod.UID)
+		r.DeleteEvent(newPod)
 		return
 	}
 
 	// after the Rubik is started, the pod adding events are transferred through the update handler of Kubernetes.
 	if !r.cpm.PodExist(newPod.UID) {
-		r.cpm.AddPod(newPod)
+		r.AddEvent(newPod)
+		return
+	}
 
-		if r.config.BlkioCfg.Enable {
-			blkio.SetBlkio(newPod)
-		}
+	// the informer resyncs the pods periodically with the same objects
+	due := r.fullCheckDue(string(newPod.UID))
+	if newPod.ResourceVersion != "" && oldPod.ResourceVersion == newPod.ResourceVersion && !due {
+		skippedUpdates.Inc()
+		return
+	}
+	r.updatePod(newPod, due)
+}
 
-		pi := r.cpm.GetPod(newPod.UID)
-		quota.SetPodQuotaBurst(pi)
+// updatePod updates the pod known and notifies the services if its settings change or the full check is due
+func (r *Rubik) updatePod(newPod *corev1.Pod, due bool) {
+	opi := r.cpm.GetPod(newPod.UID)
+	r.cpm.UpdatePod(newPod)
+	npi := r.cpm.GetPod(newPod.UID)
+	if npi.SameSettings(opi) && !due {
+		skippedUpdates.Inc()
+		return
+	}
+	r.markChecked(npi.UID)
+	r.dispatchServices(npi.UID, "update", func(s services.Service) error { return s.PodUpdated(opi, npi) })
+}
 
-		if r.config.MemCfg.Enable {
-			r.mm.UpdateConfig(pi)
+// SyncEvent handles all pods listed from the pod source switched to, the events lost while the
+// previous source is down are made up by comparing the pods with the checkpoint
+func (r *Rubik) SyncEvent(pods []corev1.Pod) {
+	listed := make(map[string]bool, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		listed[string(pod.UID)] = true
+		switch {
+		case pod.Status.Phase != corev1.PodRunning:
+			r.DeleteEvent(pod)
+		case !r.cpm.PodExist(pod.UID):
+			r.AddEvent(pod)
+		default:
+			r.updatePod(pod, r.fullCheckDue(string(pod.UID)))
 		}
-	} else {
-		opi := r.cpm.GetPod(oldPod.UID)
-		r.cpm.UpdatePod(newPod)
-		if r.config.BlkioCfg.Enable {
-			blkio.WriteBlkio(oldPod, newPod)
+	}
+	for uid, pi := range r.cpm.ListAllPods() {
+		if !listed[uid] {
+			log.Infof("clean up pod %s/%s(%s) deleted during pod source switch", pi.Namespace, pi.Name, pi.UID)
+			r.deletePod(pi)
 		}
-		npi := r.cpm.GetPod(newPod.UID)
-		quota.UpdatePodQuotaBurst(opi, npi)
 	}
+}
 
-	cpmPod := r.cpm.GetPod(newPod.UID)
-	if err := qos.UpdateQosLevel(cpmPod); err != nil {
-		log.Errorf("UpdateEvent handle error: %v", err)
+// DeleteEvent handle delete event from informer
+func (r *Rubik) DeleteEvent(pod *corev1.Pod) {
+	pi := r.cpm.GetPod(pod.UID)
+	if pi == nil {
+		return
 	}
+	r.deletePod(pi)
 }
 
-// DeleteEvent handle update event from informer
-func (r *Rubik) DeleteEvent(pod *corev1.Pod) {
-	r.cpm.DelPod(pod.UID)
+func (r *Rubik) deletePod(pi *typedef.PodInfo) {
+	r.dispatchServices(pi.UID, "delete", func(s services.Service) error { return s.PodDeleted(pi) })
+	// the cgroups are removed along with the pod, nothing to restore
+	r.dispatch(pi.UID, &eventqueue.Job{
+		Desc: "journal forget",
+		Do: func() error {
+			journal.Forget(pi.CgroupPath)
+			metrics.DeletePod(pi)
+			return nil
+		},
+	})
+	r.cpm.DelPod(types.UID(pi.UID))
+	r.forgetChecked(pi.UID)
 }
 
 func run(fcfg string) int {
@@ -271,23 +509,23 @@ func run(fcfg string) int {
 		return constant.ErrCodeFailed
 	}
 
-	if rubik.mm != nil {
-		rubik.mm.Run()
+	if err = rubik.Sync(); err != nil {
+		log.Errorf("sync qos level failed: %v", err)
 	}
 
-	log.Infof("perf hw support = %v", perf.HwSupport())
-	if err = rubik.CacheLimit(); err != nil {
-		log.Errorf("cache limit init error: %v", err)
+	if err = rubik.Serve(); err != nil {
+		log.Errorf("http server start failed: %v", err)
 		return constant.ErrCodeFailed
 	}
-
-	rubik.QuotaBurst()
-	if err = rubik.Sync(); err != nil {
-		log.Errorf("sync qos level failed: %v", err)
+	if err = rubik.ServeMetrics(); err != nil {
+		log.Errorf("metrics server start failed: %v", err)
+		return constant.ErrCodeFailed
 	}
 
 	log.Logf("Start rubik with cfg\n%v", rubik.config)
 	go signalHandler()
+	go rubik.watchConfig()
+	go rubik.reconcileLoop()
 
 	// Notify systemd that rubik is ready SdNotify() only tries to
 	// notify if the NOTIFY_SOCKET environment is set, so it's
@@ -305,10 +543,6 @@ func run(fcfg string) int {
 // Run start rubik server
 func Run(fcfg string) int {
 	unix.Umask(constant.DefaultUmask)
-	if len(os.Args) > 1 {
-		fmt.Println("args not allowed")
-		return constant.ErrCodeFailed
-	}
 
 	lock, err := util.CreateLockFile(constant.LockFile)
 	if err != nil {
@@ -322,12 +556,42 @@ func Run(fcfg string) int {
 	return ret
 }
 
+// Cleanup rolls back the settings recorded in journal when rubik is not running
+func Cleanup() int {
+	lock, err := util.CreateLockFile(constant.LockFile)
+	if err != nil {
+		fmt.Printf("set rubik lock failed: %v, stop rubik before cleanup\n", err)
+		return constant.ErrCodeFailed
+	}
+	defer util.RemoveLockFile(lock, constant.LockFile)
+
+	if err := journal.Init(constant.JournalFile); err != nil {
+		fmt.Printf("load journal failed: %v\n", err)
+		return constant.ErrCodeFailed
+	}
+	num := journal.Len()
+	if err := journal.Restore(""); err != nil {
+		fmt.Printf("cleanup failed: %v\n", err)
+		return constant.ErrCodeFailed
+	}
+	fmt.Printf("%d settings restored\n", num)
+	return 0
+}
+
 func signalHandler() {
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 
 	var forceCount int32 = 3
 	for sig := range signalChan {
+		if sig == syscall.SIGHUP {
+			log.Infof("Signal %v received and reloading config...", sig)
+			select {
+			case reloadChan <- struct{}{}:
+			default:
+			}
+			continue
+		}
 		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
 			if atomic.AddInt32(&config.ShutdownFlag, 1) == 1 {
 				log.Infof("Signal %v received and starting exit...", sig)
@@ -27,11 +27,18 @@ import (
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
 
+	"isula.org/rubik/pkg/capability"
+	"isula.org/rubik/pkg/cgroup"
 	"isula.org/rubik/pkg/checkpoint"
 	"isula.org/rubik/pkg/config"
 	"isula.org/rubik/pkg/constant"
+	"isula.org/rubik/pkg/journal"
+	"isula.org/rubik/pkg/podsource"
+	"isula.org/rubik/pkg/services"
+	"isula.org/rubik/pkg/try"
 	"isula.org/rubik/pkg/typedef"
 	"isula.org/rubik/pkg/util"
 )
@@ -57,7 +64,8 @@ func TestNewRubik(t *testing.T) {
 						"logDir": "/tmp/rubik-test",
 						"logSize": 2048,
 						"logLevel": "debug",
-						"cgroupRoot": "/tmp/rubik-test/cgroup"
+						"cgroupRoot": "/tmp/rubik-test/cgroup",
+						"podSource": {"sources": ["apiserver"]}
 }`)
 	assert.Equal(t, true, strings.Contains(err.Error(), "must be defined"))
 
@@ -101,10 +109,6 @@ var cfgA = `
 
 // TestRunAbnormality test run server abnormality
 func TestRunAbnormality(t *testing.T) {
-	old := os.Args
-	defer func() {
-		os.Args = old
-	}()
 	configFile := "config.json"
 	fcfg := filepath.Join(constant.TmpTestDir, configFile)
 	err := os.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode)
@@ -116,10 +120,8 @@ func TestRunAbnormality(t *testing.T) {
 		assert.NoError(t, err)
 	}
 	// case: argument not valid
-	os.Args = []string{"invalid", "failed"}
-	ret := Run(fcfg)
+	ret := Execute([]string{"daemon", "--config", fcfg, "failed"})
 	assert.Equal(t, constant.ErrCodeFailed, ret)
-	os.Args = []string{"rubik"}
 	// case: file is locked
 	lock, err := util.CreateLockFile(constant.LockFile)
 	ret = Run(fcfg) // set rubik lock failed: ...
@@ -152,8 +154,8 @@ func TestRun(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-// TestCacheLimit is CacheLimit function test
-func TestCacheLimit(t *testing.T) {
+// TestInitServices is initServices function test
+func TestInitServices(t *testing.T) {
 	rubik := &Rubik{
 		config: &config.Config{
 			CacheCfg: config.CacheConfig{
@@ -162,18 +164,29 @@ func TestCacheLimit(t *testing.T) {
 				DefaultResctrlDir: constant.TmpTestDir + "invalid",
 			},
 		},
-		cpm: &checkpoint.Manager{
-			Checkpoint: &checkpoint.Checkpoint{
-				Pods: make(map[string]*typedef.PodInfo),
-			},
+	}
+	err := rubik.initServices()
+	assert.Equal(t, true, strings.Contains(err.Error(), "checkpoint is not initialized"))
+
+	rubik.cpm = &checkpoint.Manager{
+		Checkpoint: &checkpoint.Checkpoint{
+			Pods: make(map[string]*typedef.PodInfo),
 		},
 	}
+	err = rubik.initServices()
+	assert.Equal(t, true, strings.Contains(err.Error(), "cachelimit"))
 
-	err := rubik.CacheLimit()
-	assert.Equal(t, true, err != nil)
+	rubik.services = nil
 	rubik.config.CacheCfg.Enable = false
-	err = rubik.CacheLimit()
+	err = rubik.initServices()
 	assert.NoError(t, err)
+	names := make([]string, 0, len(rubik.services))
+	for _, s := range rubik.services {
+		names = append(names, s.Name())
+	}
+	assert.Contains(t, names, "qos")
+	assert.Contains(t, names, "quota")
+	assert.NotContains(t, names, "cachelimit")
 }
 
 // TestSmallRun test run function
@@ -200,18 +213,39 @@ func TestSmallRun(t *testing.T) {
 
 // TestInitKubeClient test initKubeClient
 func TestInitKubeClient(t *testing.T) {
-	r := &Rubik{}
+	r := &Rubik{config: &config.Config{}}
 	err := r.initKubeClient()
 	assert.Equal(t, true, strings.Contains(err.Error(), "must be defined"))
 }
 
+// TestInitPodSource tests the pod sources failed to create are skipped
+func TestInitPodSource(t *testing.T) {
+	cfg, err := config.NewConfig("")
+	assert.NoError(t, err)
+	cfg.PodSource.Sources = []string{podsource.APIServer, podsource.Kubelet}
+	cfg.PodSource.KubeletInsecureSkipVerify = true
+	r := &Rubik{config: cfg}
+	assert.NoError(t, r.initPodSource())
+	assert.Nil(t, r.kubeClient)
+	assert.Equal(t, "", r.podSource.Active())
+
+	r.config.PodSource.Sources = []string{podsource.APIServer}
+	err = r.initPodSource()
+	assert.Contains(t, err.Error(), "no pod source available: apiserver:")
+	r.config.PodSource.Sources = []string{"etcd"}
+	assert.Contains(t, r.initPodSource().Error(), "unknown pod source")
+}
+
 // TestInitEventHandler test initEventHandler
 func TestInitEventHandler(t *testing.T) {
 	r := &Rubik{}
 	err := r.initEventHandler()
-	assert.Equal(t, true, strings.Contains(err.Error(), "kube-client is not initialized"))
+	assert.Equal(t, true, strings.Contains(err.Error(), "pod source is not initialized"))
 
-	r.kubeClient = &kubernetes.Clientset{}
+	// an unreachable api server keeps the informer from dereferencing an empty client
+	src, err := podsource.NewAPIServer(&rest.Config{Host: "http://127.0.0.1:1"}, "nodeName")
+	assert.NoError(t, err)
+	r.podSource = podsource.NewSwitcher([]podsource.Source{src}, r, time.Hour)
 	err = r.initEventHandler()
 	assert.NoError(t, err)
 }
@@ -219,12 +253,14 @@ func TestInitEventHandler(t *testing.T) {
 // TestInitCheckpoint test initCheckpoint
 func TestInitCheckpoint(t *testing.T) {
 	r := &Rubik{config: &config.Config{CgroupRoot: ""}}
-	err := r.initCheckpoint()
-	assert.Equal(t, true, strings.Contains(err.Error(), "kube-client is not initialized"))
+	_, err := r.initCheckpoint()
+	assert.Equal(t, true, strings.Contains(err.Error(), "pod source is not initialized"))
 
-	r.kubeClient = &kubernetes.Clientset{}
-	err = r.initCheckpoint()
-	assert.Equal(t, true, strings.Contains(err.Error(), "missing"))
+	src, err := podsource.NewAPIServer(&rest.Config{Host: "http://127.0.0.1:1"}, "nodeName")
+	assert.NoError(t, err)
+	r.podSource = podsource.NewSwitcher([]podsource.Source{src}, r, time.Hour)
+	_, err = r.initCheckpoint()
+	assert.Equal(t, true, strings.Contains(err.Error(), "no pod source available"))
 }
 
 // TestAddUpdateDelEvent test Event
@@ -273,3 +309,191 @@ func TestAddUpdateDelEvent(t *testing.T) {
 	r.UpdateEvent(oldPod, newPod)
 	assert.Equal(t, "podbbb", r.cpm.Checkpoint.Pods["aaa"].Name)
 }
+
+// TestReload tests config reload enables services and keeps current config if the new one is invalid
+func TestReload(t *testing.T) {
+	try.MkdirAll(constant.TmpTestDir, constant.DefaultDirMode).OrDie()
+	defer try.RemoveAll(constant.TmpTestDir)
+	cfgFile := filepath.Join(constant.TmpTestDir, "config.json")
+
+	cfg, err := config.NewConfig("")
+	assert.NoError(t, err)
+	r := &Rubik{
+		config:  cfg,
+		cfgPath: cfgFile,
+		cpm: &checkpoint.Manager{
+			Checkpoint: &checkpoint.Checkpoint{
+				Pods: make(map[string]*typedef.PodInfo),
+			},
+		},
+	}
+	assert.NoError(t, r.initServices())
+	assert.Error(t, r.Reload())
+
+	names := func() []string {
+		var ns []string
+		for _, s := range r.listServices() {
+			ns = append(ns, s.Name())
+		}
+		return ns
+	}
+	try.WriteFile(cfgFile, []byte(`{"cgroupRoot": "/tmp/rubik-test/cgroup", "blkioConfig": {"enable": true}}`),
+		constant.DefaultFileMode).OrDie()
+	assert.NoError(t, r.Reload())
+	assert.Contains(t, names(), "blkio")
+	assert.Equal(t, constant.DefaultCgroupRoot, config.CgroupRoot)
+	assert.Equal(t, constant.DefaultCgroupRoot, r.config.CgroupRoot)
+
+	for _, invalid := range []string{
+		`{"logLevel": "invalid"}`,
+		`{"memoryConfig": {"enable": true, "strategy": "invalid"}}`,
+		`{"reconcileInterval": 5}`,
+		`invalid`,
+	} {
+		try.WriteFile(cfgFile, []byte(invalid), constant.DefaultFileMode).OrDie()
+		assert.Error(t, r.Reload())
+		assert.Contains(t, names(), "blkio")
+		assert.NotContains(t, names(), "memory")
+	}
+
+	try.WriteFile(cfgFile, []byte(`{"memoryConfig": {"enable": true, "strategy": "none", "checkInterval": 5}}`),
+		constant.DefaultFileMode).OrDie()
+	assert.NoError(t, r.Reload())
+	assert.Contains(t, names(), "memory")
+	assert.NotContains(t, names(), "blkio")
+	r.shutdownServices()
+}
+
+// TestReconcile tests the drifted settings of pods are corrected by services
+func TestReconcile(t *testing.T) {
+	oldMode := cgroup.SetMode(cgroup.ModeV1)
+	defer cgroup.SetMode(oldMode)
+	// the capabilities probed on the host by the tests creating rubik are not used
+	defer capability.SetReport(capability.SetReport(nil))
+	fake := cgroup.NewFake()
+	oldBackend := cgroup.SetBackend(fake)
+	defer cgroup.SetBackend(oldBackend)
+	assert.NoError(t, journal.Init(""))
+	defer journal.Restore("")
+
+	cfg, err := config.NewConfig("")
+	assert.NoError(t, err)
+	pod := &typedef.PodInfo{Name: "pod1", Namespace: "default", UID: "pod1uid", QuotaBurst: 1000,
+		Containers: map[string]*typedef.ContainerInfo{
+			"c1": {Name: "c1", ID: "id1", CgroupRoot: "/cgroup", CgroupAddr: "kubepods/podpod1uid/id1"},
+		}}
+	r := &Rubik{
+		config: cfg,
+		cpm: &checkpoint.Manager{
+			Checkpoint: &checkpoint.Checkpoint{
+				Pods: map[string]*typedef.PodInfo{pod.UID: pod},
+			},
+		},
+	}
+	assert.NoError(t, r.initServices())
+	defer r.shutdownServices()
+
+	burstPath := "/cgroup/cpu/kubepods/podpod1uid/id1/cpu.cfs_burst_us"
+	fake.Set(burstPath, "0\n")
+	assert.Equal(t, 1, r.reconcilePod(pod.UID))
+	assert.Equal(t, 0, r.reconcilePod(pod.UID))
+	assert.Equal(t, 0, r.reconcilePod("deleted"))
+
+	// the jobs are done directly without the event queue
+	fake.Set(burstPath, "0\n")
+	r.Reconcile()
+	v, _ := fake.Get(burstPath)
+	assert.Equal(t, "1000", v)
+
+	assert.NoError(t, validateReconcileInterval(0))
+	assert.NoError(t, validateReconcileInterval(constant.DefaultReconcileInterval))
+	assert.Error(t, validateReconcileInterval(constant.MinReconcileInterval-1))
+	assert.Error(t, validateReconcileInterval(constant.MaxReconcileInterval+1))
+}
+
+// countService counts the pod updates passed to it
+type countService struct {
+	updated int
+}
+
+func (s *countService) Name() string                                           { return "count" }
+func (s *countService) Enabled(cfg *config.Config) bool                        { return true }
+func (s *countService) Init(cfg *config.Config, cpm *checkpoint.Manager) error { return nil }
+func (s *countService) PodAdded(pod *typedef.PodInfo) error                    { return nil }
+func (s *countService) PodDeleted(pod *typedef.PodInfo) error                  { return nil }
+func (s *countService) Sync(pods map[string]*typedef.PodInfo) error            { return nil }
+func (s *countService) Shutdown() error                                        { return nil }
+func (s *countService) PodUpdated(old, new *typedef.PodInfo) error {
+	s.updated++
+	return nil
+}
+
+// TestSkipUpdate tests the updates of informer resync and the ones changing nothing are skipped
+// until the full check is due
+func TestSkipUpdate(t *testing.T) {
+	cfg, err := config.NewConfig("")
+	assert.NoError(t, err)
+	s := &countService{}
+	r := &Rubik{config: cfg, cpm: checkpoint.NewManager(""), services: []services.Service{s}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: "aaa", Name: "podaaa", ResourceVersion: "1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	r.AddEvent(pod)
+
+	r.UpdateEvent(pod, pod.DeepCopy())
+	assert.Equal(t, 0, s.updated)
+	// the status changes nothing rubik applies settings by
+	relabeled := pod.DeepCopy()
+	relabeled.ResourceVersion = "2"
+	relabeled.Labels = map[string]string{"app": "foo"}
+	r.UpdateEvent(pod, relabeled)
+	assert.Equal(t, 0, s.updated)
+	offline := relabeled.DeepCopy()
+	offline.ResourceVersion = "3"
+	offline.Annotations = map[string]string{constant.PriorityAnnotationKey: "true"}
+	r.UpdateEvent(relabeled, offline)
+	assert.Equal(t, 1, s.updated)
+
+	// the full check is due after the interval
+	r.checked["aaa"] = time.Now().Add(-time.Duration(cfg.FullCheckInterval) * time.Second)
+	r.UpdateEvent(offline, offline.DeepCopy())
+	assert.Equal(t, 2, s.updated)
+	r.UpdateEvent(offline, offline.DeepCopy())
+	assert.Equal(t, 2, s.updated)
+
+	cfg.FullCheckInterval = 0
+	r.UpdateEvent(offline, offline.DeepCopy())
+	assert.Equal(t, 3, s.updated)
+
+	r.DeleteEvent(offline)
+	assert.NotContains(t, r.checked, "aaa")
+}
+
+// TestSyncEvent tests the pods changed or deleted while the pod source is switched are made up
+func TestSyncEvent(t *testing.T) {
+	cfg, err := config.NewConfig("")
+	assert.NoError(t, err)
+	s := &countService{}
+	r := &Rubik{config: cfg, cpm: checkpoint.NewManager(""), services: []services.Service{s}}
+	newPod := func(uid string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), Name: "pod" + uid, ResourceVersion: "1"},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	for _, uid := range []string{"aaa", "bbb", "ccc"} {
+		r.AddEvent(newPod(uid, corev1.PodRunning))
+	}
+
+	// the same resource version does not hide the change made while switching
+	offline := newPod("aaa", corev1.PodRunning)
+	offline.Annotations = map[string]string{constant.PriorityAnnotationKey: "true"}
+	r.SyncEvent([]corev1.Pod{*offline, *newPod("bbb", corev1.PodSucceeded), *newPod("ddd", corev1.PodRunning)})
+	assert.Equal(t, 1, s.updated)
+	assert.True(t, r.cpm.GetPod("aaa").Offline)
+	pods := r.cpm.ListAllPods()
+	assert.Len(t, pods, 2)
+	assert.Contains(t, pods, "aaa")
+	assert.Contains(t, pods, "ddd")
+}
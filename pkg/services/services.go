@@ -0,0 +1,96 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-17
+// Description: service registry of rubik feature modules
+
+// Package services provides the registry of rubik feature modules
+package services
+
+import (
+	"sync"
+
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/typedef"
+)
+
+// Service is the interface a rubik feature module implements to receive pod events
+type Service interface {
+	// Name returns the unique name of the service
+	Name() string
+	// Enabled tells whether the service should run with the config
+	Enabled(cfg *config.Config) bool
+	// Init initializes the service before any pod event is delivered
+	Init(cfg *config.Config, cpm *checkpoint.Manager) error
+	// PodAdded is called when a running pod is added to the checkpoint
+	PodAdded(pod *typedef.PodInfo) error
+	// PodUpdated is called when a pod already in the checkpoint is updated
+	PodUpdated(old, new *typedef.PodInfo) error
+	// PodDeleted is called before a pod is removed from the checkpoint
+	PodDeleted(pod *typedef.PodInfo) error
+	// Sync checks and corrects the settings of all pods
+	Sync(pods map[string]*typedef.PodInfo) error
+	// Shutdown stops the service
+	Shutdown() error
+}
+
+// Reloader is implemented by the services which have their own config to apply without restart
+type Reloader interface {
+	// Validate checks the new config before any service is reloaded
+	Validate(cfg *config.Config) error
+	// Reload applies the differences of the new config to the running service
+	Reload(cfg *config.Config) error
+}
+
+// Reconciler is implemented by the services whose settings may drift after they are applied,
+// such as being rewritten by others or lost when cgroups are recreated
+type Reconciler interface {
+	// Reconcile compares the desired settings of the pods with the actual ones,
+	// repairs the differences and returns the corrections made
+	Reconcile(pods map[string]*typedef.PodInfo) ([]Correction, error)
+}
+
+// Correction is a drifted setting repaired by reconciliation
+type Correction struct {
+	Pod *typedef.PodInfo
+	// File is the file repaired
+	File string
+	// Actual is the value found, empty if the file is missing
+	Actual string
+	// Desired is the value written
+	Desired string
+}
+
+var (
+	lock     sync.Mutex
+	registry []Service
+)
+
+// Register adds a service to the registry, services are invoked in the order they are registered
+func Register(s Service) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, old := range registry {
+		if old.Name() == s.Name() {
+			panic("service " + s.Name() + " registered twice")
+		}
+	}
+	registry = append(registry, s)
+}
+
+// List returns all registered services
+func List() []Service {
+	lock.Lock()
+	defer lock.Unlock()
+	list := make([]Service, len(registry))
+	copy(list, registry)
+	return list
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Danni Xia
+// Create: 2022-10-17
+// Description: service registry test
+
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"isula.org/rubik/pkg/checkpoint"
+	"isula.org/rubik/pkg/config"
+	"isula.org/rubik/pkg/typedef"
+)
+
+type fakeService struct {
+	name string
+}
+
+func (s *fakeService) Name() string                                   { return s.name }
+func (s *fakeService) Enabled(cfg *config.Config) bool                { return true }
+func (s *fakeService) Init(*config.Config, *checkpoint.Manager) error { return nil }
+func (s *fakeService) PodAdded(pod *typedef.PodInfo) error            { return nil }
+func (s *fakeService) PodUpdated(old, new *typedef.PodInfo) error     { return nil }
+func (s *fakeService) PodDeleted(pod *typedef.PodInfo) error          { return nil }
+func (s *fakeService) Sync(pods map[string]*typedef.PodInfo) error    { return nil }
+func (s *fakeService) Shutdown() error                                { return nil }
+
+// TestRegister is testcase for Register and List
+func TestRegister(t *testing.T) {
+	old := registry
+	defer func() { registry = old }()
+	registry = nil
+
+	Register(&fakeService{name: "foo"})
+	Register(&fakeService{name: "bar"})
+	list := List()
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "foo", list[0].Name())
+	assert.Equal(t, "bar", list[1].Name())
+
+	// modify the returned list should not affect the registry
+	list[0] = nil
+	assert.Equal(t, "foo", List()[0].Name())
+
+	assert.Panics(t, func() { Register(&fakeService{name: "foo"}) })
+}
@@ -77,14 +77,30 @@ func makeLogDir(logDir string) error {
 	return nil
 }
 
+// ValidateConfig checks log config without applying it
+func ValidateConfig(driver, level string, size int64) error {
+	if driver != "" && driver != logDriverStdio && driver != logDriverFile {
+		return fmt.Errorf("invalid log driver %s", driver)
+	}
+	if level != "" {
+		if _, err := logLevelFromString(level); err != nil {
+			return err
+		}
+	}
+	if size < logSizeMin || size > logSizeMax {
+		return fmt.Errorf("invalid log size %d", size)
+	}
+	return nil
+}
+
 // InitConfig init log config
 func InitConfig(driver, logdir, level string, size int64) error {
+	if err := ValidateConfig(driver, level, size); err != nil {
+		return err
+	}
 	if driver == "" {
 		driver = logDriverStdio
 	}
-	if driver != logDriverStdio && driver != logDriverFile {
-		return fmt.Errorf("invalid log driver %s", driver)
-	}
 	logDriver = logStdio
 	if driver == logDriverFile {
 		logDriver = logFile
@@ -98,10 +114,6 @@ func InitConfig(driver, logdir, level string, size int64) error {
 		return err
 	}
 	logLevel = levelstr
-
-	if size < logSizeMin || size > logSizeMax {
-		return fmt.Errorf("invalid log size %d", size)
-	}
 	logSize = size
 	logFileMaxSize = logSize / logFileNum
 
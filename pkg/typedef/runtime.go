@@ -0,0 +1,76 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-10-29
+// Description: container runtimes and their container references
+
+package typedef
+
+import "strings"
+
+// ContainerRuntime describes how a container runtime refers to and names its containers
+type ContainerRuntime struct {
+	// Name is the scheme of container ID in pod status, such as docker in docker://<id>
+	Name string
+	// DirPrefix is the prefix of the container cgroup directory created with cgroupfs cgroup driver,
+	// such as crio- in crio-<id>
+	DirPrefix string
+	// ScopePrefix is the prefix of the container scope created with systemd cgroup driver,
+	// such as cri-containerd in cri-containerd-<id>.scope
+	ScopePrefix string
+}
+
+// runtimes are the container runtimes known, the others are assumed to name scopes by their names
+var runtimes = map[string]*ContainerRuntime{
+	"docker":     {Name: "docker", ScopePrefix: "docker"},
+	"containerd": {Name: "containerd", ScopePrefix: "cri-containerd"},
+	"cri-o":      {Name: "cri-o", DirPrefix: "crio-", ScopePrefix: "crio"},
+	"isulad":     {Name: "isulad", ScopePrefix: "isulad"},
+	"iSulad":     {Name: "iSulad", ScopePrefix: "isulad"},
+}
+
+// ContainerRef is the container ID in pod status parsed, like <runtime>://<id>
+type ContainerRef struct {
+	// Runtime is nil if the container ID has no scheme
+	Runtime *ContainerRuntime
+	ID      string
+}
+
+// ParseContainerID parses the container ID in pod status, empty ID means the container
+// is in the creation or deletion phase
+func ParseContainerID(containerID string) ContainerRef {
+	const sep = "://"
+	i := strings.Index(containerID, sep)
+	if i < 0 {
+		return ContainerRef{ID: containerID}
+	}
+	name := containerID[:i]
+	r, ok := runtimes[name]
+	if !ok {
+		r = &ContainerRuntime{Name: name, ScopePrefix: name}
+	}
+	return ContainerRef{Runtime: r, ID: containerID[i+len(sep):]}
+}
+
+// DirName returns the name of the container cgroup directory created with cgroupfs cgroup driver
+func (ref ContainerRef) DirName() string {
+	if ref.Runtime == nil || ref.ID == "" {
+		return ref.ID
+	}
+	return ref.Runtime.DirPrefix + ref.ID
+}
+
+// ScopeName returns the name of the container scope created with systemd cgroup driver
+func (ref ContainerRef) ScopeName() string {
+	if ref.Runtime == nil || ref.ID == "" {
+		return ref.ID
+	}
+	return ref.Runtime.ScopePrefix + "-" + ref.ID + ".scope"
+}
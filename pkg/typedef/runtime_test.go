@@ -0,0 +1,42 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2022. All rights reserved.
+// rubik licensed under the Mulan PSL v2.
+// You can use this software according to the terms and conditions of the Mulan PSL v2.
+// You may obtain a copy of Mulan PSL v2 at:
+//     http://license.coscl.org.cn/MulanPSL2
+// THIS SOFTWARE IS PROVIDED ON AN "AS IS" BASIS, WITHOUT WARRANTIES OF ANY KIND, EITHER EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO NON-INFRINGEMENT, MERCHANTABILITY OR FIT FOR A PARTICULAR
+// PURPOSE.
+// See the Mulan PSL v2 for more details.
+// Author: Song Yanting
+// Create: 2022-10-29
+// Description: container runtimes test
+
+package typedef
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseContainerID tests container IDs of runtimes
+func TestParseContainerID(t *testing.T) {
+	tests := []struct {
+		containerID, id, dir, scope string
+	}{
+		{"docker://abc", "abc", "abc", "docker-abc.scope"},
+		{"containerd://abc", "abc", "abc", "cri-containerd-abc.scope"},
+		{"cri-o://abc", "abc", "crio-abc", "crio-abc.scope"},
+		{"isulad://abc", "abc", "abc", "isulad-abc.scope"},
+		{"other://abc", "abc", "abc", "other-abc.scope"},
+		{"abc", "abc", "abc", "abc"},
+		{"", "", "", ""},
+		{"containerd://", "", "", ""},
+	}
+	for _, tt := range tests {
+		ref := ParseContainerID(tt.containerID)
+		assert.Equal(t, tt.id, ref.ID, tt.containerID)
+		assert.Equal(t, tt.dir, ref.DirName(), tt.containerID)
+		assert.Equal(t, tt.scope, ref.ScopeName(), tt.containerID)
+	}
+}
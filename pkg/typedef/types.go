@@ -68,10 +68,16 @@ type PodInfo struct {
 
 	// Service Information
 	Offline         bool   `json:"offline"`
+	QosLevel        int    `json:"qosLevel,omitempty"`
 	CacheLimitLevel string `json:"cacheLimitLevel,omitempty"`
+	BlkioLimit      string `json:"blkioLimit,omitempty"`
 
 	// value of quota burst
 	QuotaBurst int64 `json:"quotaBurst"`
+	// MemoryRequest is the sum of the memory requests of the containers in bytes
+	MemoryRequest int64 `json:"memoryRequest,omitempty"`
+	// MemoryProtection is the memory protected set by annotation in bytes, -1 if not set
+	MemoryProtection int64 `json:"memoryProtection"`
 }
 
 // Clone return deepcopy object
@@ -88,6 +94,30 @@ func (pi *PodInfo) Clone() *PodInfo {
 	return &copy
 }
 
+// SameContainers returns true if both pods have the same containers with the same IDs
+func (pi *PodInfo) SameContainers(other *PodInfo) bool {
+	if len(pi.Containers) != len(other.Containers) {
+		return false
+	}
+	for name, c := range pi.Containers {
+		oc, ok := other.Containers[name]
+		if !ok || oc.ID != c.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// SameSettings returns true if both pods have the same containers and the same information rubik applies
+// settings by, so that an update between them changes nothing
+func (pi *PodInfo) SameSettings(other *PodInfo) bool {
+	return pi.Name == other.Name && pi.Namespace == other.Namespace && pi.CgroupPath == other.CgroupPath &&
+		pi.CgroupRoot == other.CgroupRoot && pi.Offline == other.Offline && pi.QosLevel == other.QosLevel &&
+		pi.CacheLimitLevel == other.CacheLimitLevel && pi.BlkioLimit == other.BlkioLimit &&
+		pi.QuotaBurst == other.QuotaBurst && pi.MemoryRequest == other.MemoryRequest &&
+		pi.MemoryProtection == other.MemoryProtection && pi.SameContainers(other)
+}
+
 // AddContainerInfo store container info to checkpoint
 func (pi *PodInfo) AddContainerInfo(containerInfo *ContainerInfo) {
 	// key should not be empty
@@ -140,3 +140,38 @@ func TestPodInfo_Clone(t *testing.T) {
 	newPi := pi.Clone()
 	assert.Equal(t, len(newPi.Containers), 1)
 }
+
+// TestPodInfo_SameContainers is testcase for PodInfo.SameContainers
+func TestPodInfo_SameContainers(t *testing.T) {
+	pi := &PodInfo{Containers: map[string]*ContainerInfo{"foo": {Name: "foo", ID: "aaa"}}}
+	same := pi.Clone()
+	assert.True(t, pi.SameContainers(same))
+
+	restarted := pi.Clone()
+	restarted.Containers["foo"].ID = "bbb"
+	assert.False(t, pi.SameContainers(restarted))
+
+	added := pi.Clone()
+	added.AddContainerInfo(&ContainerInfo{Name: "bar", ID: "ccc"})
+	assert.False(t, pi.SameContainers(added))
+	assert.False(t, added.SameContainers(pi))
+}
+
+// TestPodInfo_SameSettings is testcase for PodInfo.SameSettings
+func TestPodInfo_SameSettings(t *testing.T) {
+	pi := &PodInfo{Name: "foo", QosLevel: -1, Offline: true,
+		Containers: map[string]*ContainerInfo{"foo": {Name: "foo", ID: "aaa"}}}
+	assert.True(t, pi.SameSettings(pi.Clone()))
+
+	changed := pi.Clone()
+	changed.QuotaBurst = 1000
+	assert.False(t, pi.SameSettings(changed))
+
+	protected := pi.Clone()
+	protected.MemoryProtection = 1024
+	assert.False(t, pi.SameSettings(protected))
+
+	restarted := pi.Clone()
+	restarted.Containers["foo"].ID = "bbb"
+	assert.False(t, pi.SameSettings(restarted))
+}
@@ -16,7 +16,6 @@ package version
 
 import (
 	"fmt"
-	"os"
 	"runtime"
 )
 
@@ -31,19 +30,12 @@ var (
 	BuildTime string
 )
 
-func init() {
-	var showVersion bool
-	if len(os.Args) == 2 && os.Args[1] == "-v" {
-		showVersion = true
-	}
-
-	if showVersion {
-		fmt.Println("Version:      ", Version)
-		fmt.Println("Release:      ", Release)
-		fmt.Println("Go Version:   ", runtime.Version())
-		fmt.Println("Git Commit:   ", GitCommit)
-		fmt.Println("Built:        ", BuildTime)
-		fmt.Println("OS/Arch:      ", runtime.GOOS+"/"+runtime.GOARCH)
-		os.Exit(0)
-	}
+// PrintVersion prints the version information
+func PrintVersion() {
+	fmt.Println("Version:      ", Version)
+	fmt.Println("Release:      ", Release)
+	fmt.Println("Go Version:   ", runtime.Version())
+	fmt.Println("Git Commit:   ", GitCommit)
+	fmt.Println("Built:        ", BuildTime)
+	fmt.Println("OS/Arch:      ", runtime.GOOS+"/"+runtime.GOARCH)
 }
@@ -16,11 +16,9 @@ package main
 import (
 	"os"
 
-	_ "isula.org/rubik/pkg/version"
-	"isula.org/rubik/pkg/constant"
 	"isula.org/rubik/pkg/rubik"
 )
 
 func main() {
-	os.Exit(rubik.Run(constant.ConfigFile))
+	os.Exit(rubik.Execute(os.Args[1:]))
 }
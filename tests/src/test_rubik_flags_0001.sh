@@ -24,15 +24,18 @@ test_fun() {
         popd || exit 1 > /dev/null 2>&1
     fi
 
-    # check rubik flag
-    if "${top_dir}"/rubik -v > /dev/null 2>&1; then
-        if ! "${top_dir}"/rubik --help > /dev/null 2>&1 && ! "${top_dir}"/rubik -h > /dev/null 2>&1; then
+    # check rubik flag, help prints the usage and succeeds while unknown commands fail
+    if "${top_dir}"/rubik -v > /dev/null 2>&1 && "${top_dir}"/rubik version > /dev/null 2>&1; then
+        if "${top_dir}"/rubik --help > /dev/null 2>&1 && "${top_dir}"/rubik -h > /dev/null 2>&1 &&
+            ! "${top_dir}"/rubik --invalid > /dev/null 2>&1; then
             echo "PASS"
         else
             echo "FAILED"
+            exit_flag=1
         fi
     else
         echo "FAILED"
+        exit_flag=1
     fi
 }
 